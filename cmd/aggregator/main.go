@@ -2,30 +2,14 @@ package main
 
 import (
 	"log/slog"
+	"os"
 
-	"github.com/lat1992/blockchain-data-aggregator/config"
-	"github.com/lat1992/blockchain-data-aggregator/externals/clickhouse"
-	"github.com/lat1992/blockchain-data-aggregator/externals/coingecko"
-	"github.com/lat1992/blockchain-data-aggregator/externals/dataGetter"
-	"github.com/lat1992/blockchain-data-aggregator/internal/services"
-	"github.com/spf13/viper"
+	"github.com/lat1992/blockchain-data-aggregator/internal/cli"
 )
 
 func main() {
-	if err := config.LoadConfig(); err != nil {
-		slog.Error("Cannot load config", "error", err)
-		return
+	if err := cli.NewRootCommand().Execute(); err != nil {
+		slog.Error("Cannot run command", "error", err)
+		os.Exit(1)
 	}
-
-	dg := dataGetter.New(viper.GetString("DATA_PATH"), viper.GetInt("GOROUTINE_NUM"))
-	cg := coingecko.New(viper.GetString("COINGECKO_URL"), viper.GetString("COINGECKO_API_KEY"))
-
-	ch, err := clickhouse.New(viper.GetString("CLICKHOUSE_HOSTNAME"), viper.GetString("CLICKHOUSE_DATABASE"), viper.GetString("CLICKHOUSE_USERNAME"), viper.GetString("CLICKHOUSE_PASSWORD"))
-	if err != nil {
-		slog.Error("Cannot connect to clickhouse", "error", err)
-		return
-	}
-
-	pipeline := services.NewPipeline(cg, dg, ch, viper.GetInt("GOROUTINE_NUM"))
-	pipeline.Run()
 }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lat1992/blockchain-data-aggregator/config"
+	"github.com/lat1992/blockchain-data-aggregator/internal/app"
+)
+
+func main() {
+	if err := config.LoadConfig(); err != nil {
+		slog.Error("Cannot load config", "error", err)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := app.RunAPI(ctx); err != nil {
+		slog.Error("Cannot run api", "error", err)
+	}
+}
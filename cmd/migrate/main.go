@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/lat1992/blockchain-data-aggregator/config"
+	"github.com/lat1992/blockchain-data-aggregator/internal/app"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: migrate up|down|status")
+		os.Exit(2)
+	}
+	if err := config.LoadConfig(); err != nil {
+		slog.Error("Cannot load config", "error", err)
+		os.Exit(1)
+	}
+
+	if err := app.RunMigrate(context.Background(), os.Args[1], os.Stdout); err != nil {
+		slog.Error("Cannot migrate", "error", err)
+		os.Exit(1)
+	}
+}
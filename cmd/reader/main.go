@@ -0,0 +1,19 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/lat1992/blockchain-data-aggregator/config"
+	"github.com/lat1992/blockchain-data-aggregator/internal/app"
+)
+
+func main() {
+	if err := config.LoadConfig(); err != nil {
+		slog.Error("Cannot load config", "error", err)
+		return
+	}
+
+	if err := app.RunReader(); err != nil {
+		slog.Error("Cannot run reader", "error", err)
+	}
+}
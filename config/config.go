@@ -1,20 +1,124 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 
 	"github.com/spf13/viper"
 )
 
+// LoadConfig loads the config from the environment and, when present, the
+// .env file of the working directory.
 func LoadConfig() error {
-	viper.SetConfigName(".env")
-	viper.AddConfigPath(".")
+	return LoadConfigFile("")
+}
+
+// LoadConfigFile loads the config from the environment and the env file at
+// path, which must exist, or, when path is empty, the .env file of the
+// working directory when present. The environment overrides the file.
+func LoadConfigFile(path string) error {
+	if path != "" {
+		viper.SetConfigFile(path)
+	} else {
+		viper.SetConfigName(".env")
+		viper.AddConfigPath(".")
+	}
 	viper.AutomaticEnv()
 	viper.SetConfigType("env")
 	viper.SetDefault("GOROUTINE_NUM", 1)
+	viper.SetDefault("SCHEMA_TS", "ts")
+	viper.SetDefault("SCHEMA_EVENT", "event")
+	viper.SetDefault("SCHEMA_PROJECT_ID", "project_id")
+	viper.SetDefault("SCHEMA_PROPS", "props")
+	viper.SetDefault("SCHEMA_NUMS", "nums")
+	viper.SetDefault("S3_ENDPOINT", "s3.amazonaws.com")
+	viper.SetDefault("S3_USE_SSL", true)
+	viper.SetDefault("DATABASE_DRIVER", "clickhouse")
+	viper.SetDefault("DEDUPLICATE", true)
+	viper.SetDefault("FLUSH_SIZE", 0)
+	viper.SetDefault("FLUSH_INTERVAL", "0s")
+	viper.SetDefault("EVM_CONFIRMATIONS", 12)
+	viper.SetDefault("EVM_BLOCK_RANGE", 2000)
+	viper.SetDefault("KAFKA_GROUP_ID", "blockchain-data-aggregator")
+	viper.SetDefault("KAFKA_BATCH_SIZE", 1000)
+	viper.SetDefault("KAFKA_BATCH_TIMEOUT", "10s")
+	viper.SetDefault("API_ADDRESS", ":8080")
+	viper.SetDefault("GRPC_ADDRESS", ":9090")
+	viper.SetDefault("GRPC_MAX_RECORDS", 100000)
+	viper.SetDefault("GRPC_IDLE_TIMEOUT", "30s")
+	viper.SetDefault("INGEST_ADDRESS", ":8081")
+	viper.SetDefault("INGEST_MAX_BODY_SIZE", 10<<20)
+	viper.SetDefault("INGEST_BATCH_SIZE", 1000)
+	viper.SetDefault("INGEST_BATCH_TIMEOUT", "10s")
+	viper.SetDefault("METRICS_ADDRESS", ":2112")
+	viper.SetDefault("TRACING_SAMPLE_RATIO", 1.0)
+	viper.SetDefault("HEALTH_ADDRESS", ":8082")
+	viper.SetDefault("HEALTH_CHECK_INTERVAL", "30s")
+	viper.SetDefault("HEALTH_STALL_TIMEOUT", "5m")
+	viper.SetDefault("WATCH_INTERVAL", "1m")
 
 	if err := viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if path == "" && errors.As(err, &notFound) {
+			return nil
+		}
 		return fmt.Errorf("error reading config file: %s", err)
 	}
 	return nil
 }
+
+// GetList returns a comma separated config value as a list, skipping empty
+// entries.
+func GetList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(viper.GetString(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// secretKeys are the fragments of the config keys left out of Hash.
+var secretKeys = []string{"password", "api_key", "secret", "keys"}
+
+// secretSettings are the config keys left out of Hash that no fragment
+// matches: credentials, and URLs that may hold them.
+var secretSettings = []string{"database_url", "s3_access_key", "evm_rpc_url", "verify_rpc_urls"}
+
+// Hash returns the sha256 of the config settings, secrets left out, so that
+// runs of the pipeline with the same config can be told apart from the others.
+func Hash() string {
+	settings := viper.AllSettings()
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		if !isSecret(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%v\n", key, viper.Get(key))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	if slices.Contains(secretSettings, key) {
+		return true
+	}
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHash(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("DATA_PATH", "data")
+	hash := Hash()
+
+	for _, key := range []string{"CLICKHOUSE_PASSWORD", "COINGECKO_API_KEY", "INGEST_API_KEYS", "S3_SECRET_KEY", "S3_ACCESS_KEY", "DATABASE_URL", "EVM_RPC_URL", "VERIFY_RPC_URLS"} {
+		viper.Set(key, "secret")
+		assert.Equal(t, hash, Hash(), key)
+	}
+
+	viper.Set("DATA_PATH", "other")
+	assert.NotEqual(t, hash, Hash())
+}
@@ -0,0 +1,169 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+// backfillTables are the tables replaced by a backfill, both partitioned by
+// day.
+var backfillTables = []string{"market_transactions", "market_stats"}
+
+// backfill stages the transactions recomputed for a range of days in copies
+// of the tables, whose daily partitions replace the stored ones on commit.
+// The copies of a table are suffixed with _backfill_ and the id of the
+// backfill, so that backfills run side by side: the staging copy holds the
+// staged rows, the day copy the rows of the day being replaced.
+type backfill struct {
+	c  *ClickHouse
+	r  internal.BackfillRange
+	id string
+	// stageDay, when set, stages the transactions of a day right before it
+	// is replaced, holding the writes lock.
+	stageDay func(ctx context.Context, day time.Time) error
+}
+
+// Backfill creates the staging tables of a backfill. The staging tables of a
+// backfill that died are left, to be dropped by hand.
+func (c *ClickHouse) Backfill(r internal.BackfillRange) (externals.Backfill, error) {
+	return c.stage(r)
+}
+
+// stage creates the staging tables of a backfill of r.
+func (c *ClickHouse) stage(r internal.BackfillRange) (*backfill, error) {
+	b := &backfill{c: c, r: r, id: strings.ReplaceAll(uuid.NewString(), "-", "")}
+	ctx := context.Background()
+	for _, table := range backfillTables {
+		for _, name := range []string{b.staging(table), b.day(table)} {
+			if err := c.conn.Exec(ctx, fmt.Sprintf("CREATE TABLE %s AS %s", name, table)); err != nil {
+				b.drop()
+				return nil, fmt.Errorf("error creating staging table of %s: %w", table, err)
+			}
+		}
+	}
+	return b, nil
+}
+
+// staging returns the name of the staging copy of table.
+func (b *backfill) staging(table string) string {
+	return table + "_backfill_" + b.id
+}
+
+// day returns the name of the copy of table holding the day being replaced.
+func (b *backfill) day(table string) string {
+	return table + "_backfill_" + b.id + "_day"
+}
+
+func (b *backfill) InsertMarket(stats map[string]internal.MarketStat) error {
+	return b.c.insertMarket(b.staging("market_stats"), stats)
+}
+
+func (b *backfill) InsertTransactions(transactions []internal.Transaction) error {
+	return b.c.insertTransactions(b.staging("market_transactions"), transactions)
+}
+
+// InsertRun records the run of the backfill with the others.
+func (b *backfill) InsertRun(run internal.PipelineRun) error {
+	return b.c.InsertRun(run)
+}
+
+// Commit replaces the partitions of the range with the staged ones, day by
+// day. Each partition is replaced atomically, and a day without records is
+// emptied. A failed commit keeps the staging tables, to be committed again:
+// the days already replaced are replaced again with the same rows.
+func (b *backfill) Commit() error {
+	return b.commit(context.Background(), b.r.Days())
+}
+
+// commit commits the staged transactions of days only.
+func (b *backfill) commit(ctx context.Context, days []time.Time) error {
+	for _, day := range days {
+		// The day is staged and replaced under the writes lock, held shared
+		// by the pipeline runs, for no row stored meanwhile to be lost.
+		err := b.c.withLock(ctx, writesLock, func() error {
+			if b.stageDay != nil {
+				if err := b.stageDay(ctx, day); err != nil {
+					return err
+				}
+			}
+			if err := b.derive(ctx, day); err != nil {
+				return err
+			}
+			return b.replace(ctx, day)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return b.drop()
+}
+
+// derive stages the stats of day from its staged transactions, as the
+// market_stats_mv materialized view does, in place of the stats staged by an
+// earlier commit of the day.
+func (b *backfill) derive(ctx context.Context, day time.Time) error {
+	// The partition id of a day is its date as YYYYMMDD.
+	partition := day.Format("20060102")
+	err := b.c.conn.Exec(ctx, fmt.Sprintf("ALTER TABLE %s DROP PARTITION ID '%s'", b.staging("market_stats"), partition))
+	if err != nil {
+		return fmt.Errorf("error clearing staged market stats of %s: %w", day.Format(time.DateOnly), err)
+	}
+	err = b.c.conn.Exec(ctx, fmt.Sprintf(`INSERT INTO %s (date, project_id, num_transactions, total_volume_usd, dimensions, dimensions_key, run_ids)
+SELECT toDate(timestamp), project_id, toUInt64(1), value_usd, dimensions, dimensions_key, [run_id]
+FROM %s
+WHERE _partition_id = ?`, b.staging("market_stats"), b.staging("market_transactions")), partition)
+	if err != nil {
+		return fmt.Errorf("error staging market stats of %s: %w", day.Format(time.DateOnly), err)
+	}
+	return nil
+}
+
+// replace replaces the partitions of day with the staged rows of the day,
+// and the rows of the other projects when the backfill is of one project.
+func (b *backfill) replace(ctx context.Context, day time.Time) error {
+	partition := day.Format("20060102")
+	for _, table := range backfillTables {
+		source := b.staging(table)
+		if b.r.ProjectID != 0 {
+			// The day copy starts from the staged rows of the day, whether
+			// or not an earlier commit of the day failed.
+			source = b.day(table)
+			err := b.c.conn.Exec(ctx, fmt.Sprintf("ALTER TABLE %s REPLACE PARTITION ID '%s' FROM %s", source, partition, b.staging(table)))
+			if err != nil {
+				return fmt.Errorf("error staging partition %s of %s: %w", day.Format(time.DateOnly), table, err)
+			}
+			err = b.c.conn.Exec(ctx, fmt.Sprintf("INSERT INTO %s SELECT * FROM %s WHERE _partition_id = ? AND project_id != ?", source, table), partition, b.r.ProjectID)
+			if err != nil {
+				return fmt.Errorf("error staging %s of the other projects on %s: %w", table, day.Format(time.DateOnly), err)
+			}
+		}
+		err := b.c.conn.Exec(ctx, fmt.Sprintf("ALTER TABLE %s REPLACE PARTITION ID '%s' FROM %s", table, partition, source))
+		if err != nil {
+			return fmt.Errorf("error replacing partition %s of %s: %w", day.Format(time.DateOnly), table, err)
+		}
+	}
+	return nil
+}
+
+func (b *backfill) Rollback() error {
+	return b.drop()
+}
+
+// drop drops the staging tables.
+func (b *backfill) drop() error {
+	for _, table := range backfillTables {
+		for _, name := range []string{b.staging(table), b.day(table)} {
+			if err := b.c.conn.Exec(context.Background(), "DROP TABLE IF EXISTS "+name); err != nil {
+				return fmt.Errorf("error dropping staging table of %s: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
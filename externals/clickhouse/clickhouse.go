@@ -5,19 +5,48 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/metrics"
 )
 
 type ClickHouse struct {
 	conn driver.Conn
+	// migrationLock is the lock row of the migrations, while they are
+	// locked.
+	migrationLock *heldLock
 }
 
-func New(host, database, user, password string) (*ClickHouse, error) {
-	conn, err := connect(host, database, user, password)
+type settings struct {
+	options *clickhouse.Options
+}
+
+type Option func(*settings)
+
+// WithAsyncInsert lets the server buffer the inserts and write them in
+// batches, across clients. Inserts still wait for the data to be written.
+func WithAsyncInsert() Option {
+	return func(s *settings) {
+		s.options.Settings["async_insert"] = 1
+		s.options.Settings["wait_for_async_insert"] = 1
+	}
+}
+
+// New connects to the database. Its schema is migrated by the migrator of
+// Migrator.
+func New(host, database, user, password string, opts ...Option) (*ClickHouse, error) {
+	s := &settings{
+		options: options(host, database, user, password),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	conn, err := connect(s.options)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to clickhouse: %w", err)
 	}
@@ -26,44 +55,45 @@ func New(host, database, user, password string) (*ClickHouse, error) {
 	}, nil
 }
 
-func connect(host, database, user, password string) (driver.Conn, error) {
-	var (
-		ctx       = context.Background()
-		conn, err = clickhouse.Open(&clickhouse.Options{
-			Addr: []string{host},
-			Auth: clickhouse.Auth{
-				Database: database,
-				Username: user,
-				Password: password,
-			},
-			DialContext: func(ctx context.Context, addr string) (net.Conn, error) {
-				var d net.Dialer
-				return d.DialContext(ctx, "tcp", addr)
+func options(host, database, user, password string) *clickhouse.Options {
+	return &clickhouse.Options{
+		Addr: []string{host},
+		Auth: clickhouse.Auth{
+			Database: database,
+			Username: user,
+			Password: password,
+		},
+		DialContext: func(ctx context.Context, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", addr)
+		},
+		Settings: clickhouse.Settings{
+			"max_execution_time": 60,
+		},
+		Compression: &clickhouse.Compression{
+			Method: clickhouse.CompressionLZ4,
+		},
+		DialTimeout:          time.Second * 30,
+		MaxOpenConns:         5,
+		MaxIdleConns:         5,
+		ConnMaxLifetime:      time.Duration(10) * time.Minute,
+		ConnOpenStrategy:     clickhouse.ConnOpenInOrder,
+		BlockBufferSize:      10,
+		MaxCompressionBuffer: 10240,
+		ClientInfo: clickhouse.ClientInfo{
+			Products: []struct {
+				Name    string
+				Version string
+			}{
+				{Name: "aggregator-client", Version: "0.1"},
 			},
-			Settings: clickhouse.Settings{
-				"max_execution_time": 60,
-			},
-			Compression: &clickhouse.Compression{
-				Method: clickhouse.CompressionLZ4,
-			},
-			DialTimeout:          time.Second * 30,
-			MaxOpenConns:         5,
-			MaxIdleConns:         5,
-			ConnMaxLifetime:      time.Duration(10) * time.Minute,
-			ConnOpenStrategy:     clickhouse.ConnOpenInOrder,
-			BlockBufferSize:      10,
-			MaxCompressionBuffer: 10240,
-			ClientInfo: clickhouse.ClientInfo{
-				Products: []struct {
-					Name    string
-					Version string
-				}{
-					{Name: "aggregator-client", Version: "0.1"},
-				},
-			},
-		})
-	)
+		},
+	}
+}
 
+func connect(options *clickhouse.Options) (driver.Conn, error) {
+	ctx := context.Background()
+	conn, err := clickhouse.Open(options)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to clickhouse: %w", err)
 	}
@@ -77,17 +107,149 @@ func connect(host, database, user, password string) (driver.Conn, error) {
 	return conn, nil
 }
 
+// Ping checks that the database is reachable.
+func (c *ClickHouse) Ping(ctx context.Context) error {
+	if err := c.conn.Ping(ctx); err != nil {
+		return fmt.Errorf("error pinging clickhouse: %w", err)
+	}
+	return nil
+}
+
 func (c *ClickHouse) InsertMarket(stats map[string]internal.MarketStat) error {
+	return c.insertMarket("market_stats", stats)
+}
+
+func (c *ClickHouse) insertMarket(table string, stats map[string]internal.MarketStat) error {
 	ctx := context.Background()
-	batch, err := c.conn.PrepareBatch(ctx, "INSERT INTO market_stats")
+	batch, err := c.conn.PrepareBatch(ctx, "INSERT INTO "+table)
 	if err != nil {
 		return err
 	}
 	for _, stat := range stats {
-		err := batch.Append(stat.Date, stat.ProjectID, stat.NumTx, stat.TotalVolume)
+		dimensions := stat.Dimensions
+		if dimensions == nil {
+			dimensions = map[string]string{}
+		}
+		runIDs := []string{}
+		if stat.RunID != "" {
+			runIDs = append(runIDs, stat.RunID)
+		}
+		err := batch.Append(stat.Date, stat.ProjectID, stat.NumTx, stat.TotalVolume, dimensions, internal.DimensionsKey(dimensions), runIDs)
 		if err != nil {
 			return fmt.Errorf("error appending to batch: %w", err)
 		}
 	}
-	return batch.Send()
+	return send(table, batch)
+}
+
+// InsertTransactions stores priced records in market_transactions, from which
+// the market_stats_mv materialized view feeds market_stats. The records are
+// sent as a single insert, so that a failed insert stores none of them.
+func (c *ClickHouse) InsertTransactions(transactions []internal.Transaction) error {
+	return c.insertTransactions("market_transactions", transactions)
+}
+
+func (c *ClickHouse) insertTransactions(table string, transactions []internal.Transaction) error {
+	ctx := context.Background()
+	batch, err := c.conn.PrepareBatch(ctx, "INSERT INTO "+table)
+	if err != nil {
+		return err
+	}
+	for _, tx := range transactions {
+		dimensions := tx.Dimensions
+		if dimensions == nil {
+			dimensions = map[string]string{}
+		}
+		err := batch.Append(tx.Timestamp, tx.Event, tx.ProjectID, tx.ChainID, tx.CurrencyAddress, tx.CurrencySymbol,
+			tx.Amount, tx.PriceUSD, tx.ValueUSD, tx.TxnHash, tx.CollectionAddress, tx.TokenID, tx.SourceFile,
+			dimensions, internal.DimensionsKey(dimensions), tx.RunID)
+		if err != nil {
+			return fmt.Errorf("error appending to batch: %w", err)
+		}
+	}
+	return send(table, batch)
+}
+
+func (c *ClickHouse) InsertVerifications(verifications []internal.Verification) error {
+	ctx := context.Background()
+	batch, err := c.conn.PrepareBatch(ctx, "INSERT INTO transaction_verifications")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, v := range verifications {
+		projectID, _ := strconv.ParseUint(v.ProjectID, 10, 64)
+		err := batch.Append(v.Date, projectID, v.ChainID, v.TxnHash, v.CurrencyAddress, v.CurrencyValueRaw, v.Status, v.Reason, now)
+		if err != nil {
+			return fmt.Errorf("error appending to batch: %w", err)
+		}
+	}
+	return send("transaction_verifications", batch)
+}
+
+// InsertRun stores the record of a pipeline run in pipeline_runs.
+func (c *ClickHouse) InsertRun(run internal.PipelineRun) error {
+	ctx := context.Background()
+	batch, err := c.conn.PrepareBatch(ctx, "INSERT INTO pipeline_runs")
+	if err != nil {
+		return err
+	}
+	sources, errors := run.Sources, run.Errors
+	if sources == nil {
+		sources = map[string]uint64{}
+	}
+	if errors == nil {
+		errors = []string{}
+	}
+	err = batch.Append(run.ID, run.StartedAt, run.EndedAt, run.Status, run.ConfigHash, sources,
+		run.RecordsAggregated, run.RecordsFailed, run.RecordsDuplicated, run.RowsWritten, run.PriceCalls, errors)
+	if err != nil {
+		return fmt.Errorf("error appending to batch: %w", err)
+	}
+	return send("pipeline_runs", batch)
+}
+
+// Seen returns the keys, "chainId-txnHash-tokenId", of the trades stored in
+// market_transactions: a trade is seen once stored, in the same insert. The
+// transactions are looked up by the txn_hash_index skip index.
+func (c *ClickHouse) Seen(keys []string) (map[string]bool, error) {
+	hashes := make([]string, 0, len(keys))
+	for _, key := range keys {
+		// Chain ids, hashes and token ids are numbers, hexadecimal for the
+		// hashes.
+		first, last := strings.Index(key, "-"), strings.LastIndex(key, "-")
+		if first < last {
+			hashes = append(hashes, key[first+1:last])
+		}
+	}
+	seen := make(map[string]bool)
+	if len(hashes) == 0 {
+		return seen, nil
+	}
+	rows, err := c.conn.Query(context.Background(),
+		"SELECT DISTINCT chain_id, lower(txn_hash), token_id FROM market_transactions WHERE lower(txn_hash) IN ?", hashes)
+	if err != nil {
+		return nil, fmt.Errorf("error querying seen transactions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var chainID, txnHash, tokenID string
+		if err := rows.Scan(&chainID, &txnHash, &tokenID); err != nil {
+			return nil, fmt.Errorf("error scanning seen transaction: %w", err)
+		}
+		seen[chainID+"-"+txnHash+"-"+tokenID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying seen transactions: %w", err)
+	}
+	return seen, nil
+}
+
+// send inserts a batch into a table, recording its size and latency.
+func send(table string, batch driver.Batch) error {
+	metrics.ClickHouseBatchRows.WithLabelValues(table).Observe(float64(batch.Rows()))
+	start := time.Now()
+	err := batch.Send()
+	metrics.ClickHouseInsertDuration.WithLabelValues(table).Observe(time.Since(start).Seconds())
+	return err
 }
@@ -0,0 +1,38 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// testClickHouse connects to a database of its own on the ClickHouse server
+// at CLICKHOUSE_TEST_HOSTNAME, dropped at the end of the test. The test is
+// skipped when no server is set.
+func testClickHouse(t *testing.T) *ClickHouse {
+	t.Helper()
+	host := os.Getenv("CLICKHOUSE_TEST_HOSTNAME")
+	if host == "" {
+		t.Skip("CLICKHOUSE_TEST_HOSTNAME is not set")
+	}
+	user, password := os.Getenv("CLICKHOUSE_TEST_USERNAME"), os.Getenv("CLICKHOUSE_TEST_PASSWORD")
+	admin, err := New(host, "default", user, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	ctx := context.Background()
+	if err := admin.Exec(ctx, "CREATE DATABASE "+database); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(context.Background(), "DROP DATABASE IF EXISTS "+database)
+	})
+	c, err := New(host, database, user, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
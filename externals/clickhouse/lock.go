@@ -0,0 +1,163 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// lockTimeout is the age past which the lock row of a process that died
+	// holding it is ignored. The rows of the live processes are refreshed
+	// every lockRefreshInterval, however long they hold their lock.
+	lockTimeout         = time.Minute
+	lockRefreshInterval = 15 * time.Second
+	// lockPollInterval is the interval between two checks of a lock waited
+	// for.
+	lockPollInterval = time.Second
+	// locksTable holds the locks of the pipeline runs and backfills, created
+	// by a migration; the lock of the migrations is in migrationLocksTable,
+	// created before they run.
+	locksTable = "locks"
+	// writesLock is held shared by the pipeline runs storing to
+	// market_transactions and market_stats, and exclusively by a backfill
+	// replacing a day of them.
+	writesLock = "writes"
+)
+
+// heldLock is a lock row, refreshed until released.
+type heldLock struct {
+	c     *ClickHouse
+	table string
+	name  string
+	owner string
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// lock takes the lock name of table, shared by the processes of the
+// database: it records a lock row, and waits for it to come first among the
+// rows of the lock not released nor expired, the processes taking the lock
+// in the order of their rows. The row times are the server's, for a row
+// inserted later to sort last. A shared lock only waits for the exclusive
+// rows before it.
+func (c *ClickHouse) lock(ctx context.Context, table, name string, shared bool) (*heldLock, error) {
+	l := &heldLock{c: c, table: table, name: name, owner: uuid.NewString(), stop: make(chan struct{}), done: make(chan struct{})}
+	var flag uint8
+	if shared {
+		flag = 1
+	}
+	err := c.conn.Exec(ctx, "INSERT INTO "+table+" SELECT ?, ?, ?, now64(6), 0, now64(6)", name, l.owner, flag)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting lock: %w", err)
+	}
+	go l.refresh()
+	for {
+		acquired, err := l.acquired(ctx)
+		if err != nil {
+			l.release(context.Background())
+			return nil, fmt.Errorf("error waiting for lock %s: %w", name, err)
+		}
+		if acquired {
+			return l, nil
+		}
+		select {
+		case <-time.After(lockPollInterval):
+		case <-ctx.Done():
+			l.release(context.Background())
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// acquired tells whether the row of l comes first among the live rows of its
+// lock, or after shared rows only when it is shared.
+func (l *heldLock) acquired(ctx context.Context) (bool, error) {
+	rows, err := l.c.conn.Query(ctx, `SELECT owner, shared FROM `+l.table+` FINAL
+WHERE name = ? AND released = 0 AND updated_at > now64(6) - toIntervalSecond(?)
+ORDER BY locked_at, owner`, l.name, int64(lockTimeout.Seconds()))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for first := true; rows.Next(); first = false {
+		var owner string
+		var shared uint8
+		if err := rows.Scan(&owner, &shared); err != nil {
+			return false, err
+		}
+		if owner == l.owner {
+			return first || shared == 1, nil
+		}
+		if shared == 0 {
+			// An exclusive row before l holds it off, whatever l is.
+			return false, rows.Err()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	return false, errors.New("lock expired while waiting")
+}
+
+// refresh updates the row of l every lockRefreshInterval until it is
+// released.
+func (l *heldLock) refresh() {
+	defer close(l.done)
+	ticker := time.NewTicker(lockRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := l.c.conn.Exec(context.Background(), `INSERT INTO `+l.table+`
+SELECT name, owner, shared, locked_at, 0, now64(6) FROM `+l.table+` FINAL
+WHERE name = ? AND owner = ? AND released = 0`, l.name, l.owner)
+			if err != nil {
+				slog.Error("failed to refresh lock", "name", l.name, "err", err)
+			}
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// release stops refreshing the row of l, then releases it.
+func (l *heldLock) release(ctx context.Context) error {
+	close(l.stop)
+	<-l.done
+	err := l.c.conn.Exec(ctx, `INSERT INTO `+l.table+`
+SELECT name, owner, shared, locked_at, 1, now64(6) FROM `+l.table+` FINAL
+WHERE name = ? AND owner = ?`, l.name, l.owner)
+	if err != nil {
+		return fmt.Errorf("error releasing lock %s: %w", l.name, err)
+	}
+	return nil
+}
+
+// withLock runs fn holding the lock name of locksTable exclusively.
+func (c *ClickHouse) withLock(ctx context.Context, name string, fn func() error) (err error) {
+	l, err := c.lock(ctx, locksTable, name, false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if releaseErr := l.release(context.Background()); err == nil {
+			err = releaseErr
+		}
+	}()
+	return fn()
+}
+
+// LockRun holds the writes lock shared for the duration of a pipeline run,
+// so that a backfill does not replace a day while the run stores to it.
+func (c *ClickHouse) LockRun(ctx context.Context) (func() error, error) {
+	l, err := c.lock(ctx, locksTable, writesLock, true)
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return l.release(context.Background()) }, nil
+}
@@ -0,0 +1,56 @@
+package clickhouse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLock(t *testing.T) {
+	c := testClickHouse(t)
+	ctx := context.Background()
+	migrator, err := c.Migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The runs share the lock, a backfill waits for them to release it.
+	unlockRun, err := c.LockRun(ctx)
+	assert.NoError(t, err)
+	unlockOther, err := c.LockRun(ctx)
+	assert.NoError(t, err)
+	backfill := make(chan error, 1)
+	go func() {
+		backfill <- c.withLock(ctx, writesLock, func() error { return nil })
+	}()
+	select {
+	case <-backfill:
+		t.Fatal("backfill ran while the runs held the lock")
+	case <-time.After(3 * lockPollInterval):
+	}
+
+	// A run started after the backfill waits for it.
+	run := make(chan error, 1)
+	go func() {
+		unlock, err := c.LockRun(ctx)
+		if err == nil {
+			err = unlock()
+		}
+		run <- err
+	}()
+	time.Sleep(3 * lockPollInterval)
+	select {
+	case <-run:
+		t.Fatal("run started while the backfill waited")
+	default:
+	}
+	assert.NoError(t, unlockRun())
+	assert.NoError(t, unlockOther())
+	assert.NoError(t, <-backfill)
+	assert.NoError(t, <-run)
+}
@@ -0,0 +1,100 @@
+package clickhouse
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrator returns the migrator of the schema of the database.
+func (c *ClickHouse) Migrator() (*migrate.Migrator, error) {
+	return migrate.FromFS(c, migrationFiles, "migrations")
+}
+
+// migrationLock is the name of the lock of the migrations, and
+// migrationLocksTable its table.
+const (
+	migrationLock       = "migrations"
+	migrationLocksTable = "schema_migrations_locks"
+)
+
+// Lock takes the lock row of the migrations, waiting for the other
+// processes migrating the database to release theirs. The table of the lock
+// is created first, as the migrations have not run yet; its rows are
+// deleted a day after their last update.
+func (c *ClickHouse) Lock(ctx context.Context) error {
+	err := c.conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+migrationLocksTable+` (
+    name String,
+    owner String,
+    shared UInt8,
+    locked_at DateTime64(6),
+    released UInt8,
+    updated_at DateTime64(6),
+) ENGINE = ReplacingMergeTree (updated_at)
+ORDER BY
+    (name, owner) TTL toDateTime(updated_at) + INTERVAL 1 DAY`)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", migrationLocksTable, err)
+	}
+	l, err := c.lock(ctx, migrationLocksTable, migrationLock, false)
+	if err != nil {
+		return err
+	}
+	c.migrationLock = l
+	return nil
+}
+
+// Unlock releases the lock row of the migrations.
+func (c *ClickHouse) Unlock(ctx context.Context) error {
+	l := c.migrationLock
+	c.migrationLock = nil
+	return l.release(ctx)
+}
+
+// Applied returns the versions of the migrations applied. A migration is
+// reverted by recording it again, as not applied.
+func (c *ClickHouse) Applied(ctx context.Context) (map[uint64]bool, error) {
+	err := c.conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version UInt64,
+    name String,
+    applied UInt8,
+    updated_at DateTime64(3),
+) ENGINE = ReplacingMergeTree (updated_at)
+ORDER BY
+    version`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+	rows, err := c.conn.Query(ctx, "SELECT version FROM schema_migrations FINAL WHERE applied = 1")
+	if err != nil {
+		return nil, fmt.Errorf("error querying schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[uint64]bool)
+	for rows.Next() {
+		var version uint64
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("error scanning schema_migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (c *ClickHouse) Exec(ctx context.Context, statement string) error {
+	return c.conn.Exec(ctx, statement)
+}
+
+func (c *ClickHouse) SetApplied(ctx context.Context, migration migrate.Migration, applied bool) error {
+	var flag uint8
+	if applied {
+		flag = 1
+	}
+	return c.conn.Exec(ctx, "INSERT INTO schema_migrations VALUES (?, ?, ?, ?)", migration.Version, migration.Name, flag, time.Now())
+}
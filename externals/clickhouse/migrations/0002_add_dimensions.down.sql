@@ -0,0 +1,31 @@
+DROP TABLE IF EXISTS market_stats_baseline;
+
+CREATE TABLE market_stats_baseline (
+    date Date,
+    project_id UInt64,
+    num_transactions UInt64,
+    total_volume_usd Float64,
+    INDEX project_id_index (project_id) TYPE
+    SET
+        (100) GRANULARITY 4,
+) ENGINE = MergeTree ()
+PARTITION BY
+    date
+ORDER BY
+    (project_id, date) SETTINGS index_granularity = 8192;
+
+INSERT INTO market_stats_baseline
+SELECT
+    date,
+    project_id,
+    sum(num_transactions),
+    sum(total_volume_usd)
+FROM
+    market_stats
+GROUP BY
+    date,
+    project_id;
+
+EXCHANGE TABLES market_stats AND market_stats_baseline;
+
+DROP TABLE market_stats_baseline;
@@ -0,0 +1,32 @@
+DROP TABLE IF EXISTS market_stats_dimensions;
+
+CREATE TABLE market_stats_dimensions (
+    date Date,
+    project_id UInt64,
+    num_transactions UInt64,
+    total_volume_usd Float64,
+    dimensions Map(String, String),
+    dimensions_key String,
+    INDEX project_id_index (project_id) TYPE
+    SET
+        (100) GRANULARITY 4,
+) ENGINE = SummingMergeTree ((num_transactions, total_volume_usd))
+PARTITION BY
+    date
+ORDER BY
+    (project_id, date, dimensions_key) SETTINGS index_granularity = 8192;
+
+INSERT INTO market_stats_dimensions (date, project_id, num_transactions, total_volume_usd, dimensions, dimensions_key)
+SELECT
+    date,
+    project_id,
+    num_transactions,
+    total_volume_usd,
+    map(),
+    ''
+FROM
+    market_stats;
+
+EXCHANGE TABLES market_stats AND market_stats_dimensions;
+
+DROP TABLE market_stats_dimensions;
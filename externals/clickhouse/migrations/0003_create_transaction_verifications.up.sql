@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS transaction_verifications (
+    date Date,
+    project_id UInt64,
+    chain_id String,
+    txn_hash String,
+    currency_address String,
+    currency_value_raw String,
+    status LowCardinality(String),
+    reason String,
+    verified_at DateTime,
+) ENGINE = ReplacingMergeTree (verified_at)
+PARTITION BY
+    date
+ORDER BY
+    (project_id, txn_hash, currency_value_raw) SETTINGS index_granularity = 8192;
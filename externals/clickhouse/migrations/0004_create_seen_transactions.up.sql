@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS seen_transactions (
+    key String,
+    seen_at DateTime,
+) ENGINE = ReplacingMergeTree ()
+ORDER BY
+    key SETTINGS index_granularity = 8192;
@@ -0,0 +1,3 @@
+DROP VIEW IF EXISTS market_stats_mv;
+
+DROP TABLE IF EXISTS market_transactions;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS market_transactions (
+    timestamp DateTime64(3),
+    event LowCardinality(String),
+    project_id UInt64,
+    chain_id LowCardinality(String),
+    currency_address String,
+    currency_symbol LowCardinality(String),
+    amount Float64,
+    price_usd Float64,
+    value_usd Float64,
+    txn_hash String,
+    collection_address String,
+    token_id String,
+    source_file String,
+    dimensions Map(String, String),
+    dimensions_key String,
+) ENGINE = MergeTree ()
+PARTITION BY
+    toDate(timestamp)
+ORDER BY
+    (project_id, timestamp) SETTINGS index_granularity = 8192;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS market_stats_mv TO market_stats AS
+SELECT
+    toDate(timestamp) AS date,
+    project_id,
+    toUInt64(1) AS num_transactions,
+    value_usd AS total_volume_usd,
+    dimensions,
+    dimensions_key
+FROM
+    market_transactions;
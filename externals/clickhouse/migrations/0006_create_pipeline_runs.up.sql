@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS pipeline_runs (
+    run_id String,
+    started_at DateTime64(3),
+    ended_at DateTime64(3),
+    status LowCardinality(String),
+    config_hash String,
+    sources Map(String, UInt64),
+    records_aggregated UInt64,
+    records_failed UInt64,
+    records_duplicated UInt64,
+    rows_written UInt64,
+    price_calls UInt64,
+    errors Array(String),
+) ENGINE = MergeTree ()
+PARTITION BY
+    toYYYYMM(started_at)
+ORDER BY
+    (started_at, run_id) SETTINGS index_granularity = 8192;
@@ -0,0 +1,14 @@
+ALTER TABLE market_stats_mv MODIFY QUERY
+SELECT
+    toDate(timestamp) AS date,
+    project_id,
+    toUInt64(1) AS num_transactions,
+    value_usd AS total_volume_usd,
+    dimensions,
+    dimensions_key
+FROM
+    market_transactions;
+
+ALTER TABLE market_stats DROP COLUMN IF EXISTS run_ids;
+
+ALTER TABLE market_transactions DROP COLUMN IF EXISTS run_id;
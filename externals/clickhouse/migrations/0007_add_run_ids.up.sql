@@ -0,0 +1,15 @@
+ALTER TABLE market_transactions ADD COLUMN IF NOT EXISTS run_id String;
+
+ALTER TABLE market_stats ADD COLUMN IF NOT EXISTS run_ids SimpleAggregateFunction(groupUniqArrayArray, Array(String));
+
+ALTER TABLE market_stats_mv MODIFY QUERY
+SELECT
+    toDate(timestamp) AS date,
+    project_id,
+    toUInt64(1) AS num_transactions,
+    value_usd AS total_volume_usd,
+    dimensions,
+    dimensions_key,
+    [run_id] AS run_ids
+FROM
+    market_transactions;
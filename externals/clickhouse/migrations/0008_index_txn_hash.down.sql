@@ -0,0 +1 @@
+ALTER TABLE market_transactions DROP INDEX IF EXISTS txn_hash_index;
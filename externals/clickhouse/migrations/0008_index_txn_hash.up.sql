@@ -0,0 +1,3 @@
+ALTER TABLE market_transactions ADD INDEX IF NOT EXISTS txn_hash_index lower(txn_hash) TYPE bloom_filter GRANULARITY 4;
+
+ALTER TABLE market_transactions MATERIALIZE INDEX txn_hash_index;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS locks (
+    name String,
+    owner String,
+    shared UInt8,
+    locked_at DateTime64(6),
+    released UInt8,
+    updated_at DateTime64(6),
+) ENGINE = ReplacingMergeTree (updated_at)
+ORDER BY
+    (name, owner) TTL toDateTime(updated_at) + INTERVAL 1 DAY;
@@ -0,0 +1,67 @@
+package clickhouse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrations(t *testing.T) {
+	migrator, err := (&ClickHouse{}).Migrator()
+	assert.NoError(t, err)
+	migrations := migrator.Migrations()
+	assert.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		assert.Equal(t, uint64(i+1), migration.Version)
+		assert.NotEmpty(t, migration.Up, migration.Name)
+		assert.NotEmpty(t, migration.Down, migration.Name)
+	}
+}
+
+// TestMigrateFromBaseline migrates a database holding the market_stats table
+// of the first release, created before the migrations, and reverts it.
+func TestMigrateFromBaseline(t *testing.T) {
+	c := testClickHouse(t)
+	ctx := context.Background()
+	migrator, err := c.Migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range migrator.Migrations()[0].Up {
+		if err := c.Exec(ctx, statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := c.conn.Exec(ctx, "INSERT INTO market_stats VALUES (?, 1234, 3, 10.5)", day); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = migrator.Up(ctx)
+	assert.NoError(t, err)
+	err = c.InsertMarket(map[string]internal.MarketStat{
+		"01-01-2024-1234": {Date: day, ProjectID: 1234, NumTx: 2, TotalVolume: 4.5, Dimensions: map[string]string{"chain": "1"}},
+	})
+	assert.NoError(t, err)
+	stats, err := c.MarketStats(ctx, internal.StatsQuery{ProjectID: 1234, From: day, To: day, Limit: 10})
+	assert.NoError(t, err)
+	var numTx uint64
+	var volume float64
+	for _, stat := range stats {
+		numTx += stat.NumTx
+		volume += stat.TotalVolume
+	}
+	assert.Equal(t, uint64(5), numTx)
+	assert.Equal(t, 15.0, volume)
+
+	for range migrator.Migrations()[1:] {
+		_, err := migrator.Down(ctx)
+		assert.NoError(t, err)
+	}
+	var count uint64
+	assert.NoError(t, c.conn.QueryRow(ctx, "SELECT sum(num_transactions) FROM market_stats WHERE project_id = 1234").Scan(&count))
+	assert.Equal(t, uint64(5), count)
+}
@@ -0,0 +1,86 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+func (c *ClickHouse) CurrencyVolumes(ctx context.Context, from, to time.Time) ([]internal.CurrencyVolume, error) {
+	rows, err := c.conn.Query(ctx, `SELECT toDate(timestamp) AS date, currency_symbol, sum(amount), sum(value_usd)
+FROM market_transactions
+WHERE timestamp >= ? AND timestamp < ?
+GROUP BY date, currency_symbol
+ORDER BY date, currency_symbol`, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, fmt.Errorf("error querying currency volumes: %w", err)
+	}
+	defer rows.Close()
+	var volumes []internal.CurrencyVolume
+	for rows.Next() {
+		var volume internal.CurrencyVolume
+		if err := rows.Scan(&volume.Date, &volume.CurrencySymbol, &volume.Amount, &volume.VolumeUSD); err != nil {
+			return nil, fmt.Errorf("error scanning currency volumes: %w", err)
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, rows.Err()
+}
+
+// Reprice stages the transactions of the days of the prices, repriced, and
+// swaps them in with their stats, day by day, like a backfill. The currencies
+// without a price keep theirs.
+func (c *ClickHouse) Reprice(ctx context.Context, prices []internal.Price) (err error) {
+	if len(prices) == 0 {
+		return nil
+	}
+	var days []time.Time
+	byDay := make(map[time.Time][]internal.Price)
+	for _, price := range prices {
+		day := price.Date.UTC().Truncate(24 * time.Hour)
+		if _, ok := byDay[day]; !ok {
+			days = append(days, day)
+		}
+		byDay[day] = append(byDay[day], price)
+	}
+	slices.SortFunc(days, time.Time.Compare)
+
+	b, err := c.stage(internal.BackfillRange{From: days[0], To: days[len(days)-1]})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			b.drop()
+		}
+	}()
+	// A day is staged right before it is replaced, under the writes lock,
+	// for no trade stored meanwhile to be lost.
+	b.stageDay = func(ctx context.Context, day time.Time) error {
+		symbols := make([]string, 0, len(byDay[day]))
+		values := make([]float64, 0, len(byDay[day]))
+		for _, price := range byDay[day] {
+			symbols = append(symbols, price.CurrencySymbol)
+			values = append(values, price.PriceUSD)
+		}
+		staging := b.staging("market_transactions")
+		err := c.conn.Exec(ctx, fmt.Sprintf("ALTER TABLE %s DROP PARTITION ID '%s'", staging, day.Format("20060102")))
+		if err != nil {
+			return fmt.Errorf("error clearing staged transactions of %s: %w", day.Format(time.DateOnly), err)
+		}
+		err = c.conn.Exec(ctx, `INSERT INTO `+staging+`
+SELECT * REPLACE (
+    transform(currency_symbol, ?, CAST(? AS Array(Float64)), price_usd) AS price_usd,
+    amount * transform(currency_symbol, ?, CAST(? AS Array(Float64)), price_usd) AS value_usd)
+FROM market_transactions
+WHERE toDate(timestamp) = ?`, symbols, values, symbols, values, day.Format(time.DateOnly))
+		if err != nil {
+			return fmt.Errorf("error staging repriced transactions of %s: %w", day.Format(time.DateOnly), err)
+		}
+		return nil
+	}
+	return b.commit(ctx, days)
+}
@@ -0,0 +1,48 @@
+package clickhouse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReprice(t *testing.T) {
+	c := testClickHouse(t)
+	ctx := context.Background()
+	migrator, err := c.Migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	transaction := func(symbol string, amount, price float64) internal.Transaction {
+		return internal.Transaction{Timestamp: day.Add(12 * time.Hour), ProjectID: 1234, CurrencySymbol: symbol,
+			Amount: amount, PriceUSD: price, ValueUSD: amount * price, RunID: "run"}
+	}
+	err = c.InsertTransactions([]internal.Transaction{transaction("BTC", 2, 40000), transaction("ETH", 10, 2000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ETH has no price, and keeps its volume.
+	err = c.Reprice(ctx, []internal.Price{{Date: day, CurrencySymbol: "BTC", PriceUSD: 50000}})
+	assert.NoError(t, err)
+
+	volumes, err := c.CurrencyVolumes(ctx, day, day)
+	assert.NoError(t, err)
+	assert.Equal(t, []internal.CurrencyVolume{
+		{Date: day, CurrencySymbol: "BTC", Amount: 2, VolumeUSD: 100000},
+		{Date: day, CurrencySymbol: "ETH", Amount: 10, VolumeUSD: 20000},
+	}, volumes)
+	stats, err := c.MarketStats(ctx, internal.StatsQuery{ProjectID: 1234, From: day, To: day, Limit: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, uint64(2), stats[0].NumTx)
+		assert.Equal(t, 120000.0, stats[0].TotalVolume)
+	}
+}
@@ -0,0 +1,109 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+// periods maps the granularities to the expression of their first date.
+var periods = map[string]string{
+	internal.GranularityDay:   "date",
+	internal.GranularityWeek:  "toStartOfWeek(date, 1)",
+	internal.GranularityMonth: "toStartOfMonth(date)",
+}
+
+func (c *ClickHouse) ProjectStats(ctx context.Context, query internal.StatsQuery) ([]internal.PeriodStat, error) {
+	period, ok := periods[query.Granularity]
+	if !ok {
+		return nil, fmt.Errorf("unknown granularity %q", query.Granularity)
+	}
+	rows, err := c.conn.Query(ctx, fmt.Sprintf(`SELECT %s AS period, sum(num_transactions), sum(total_volume_usd)
+FROM market_stats
+WHERE project_id = ? AND date >= ? AND date <= ?
+GROUP BY period
+ORDER BY period
+LIMIT ? OFFSET ?`, period), query.ProjectID, query.From, query.To, query.Limit, query.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("error querying project stats: %w", err)
+	}
+	defer rows.Close()
+	var stats []internal.PeriodStat
+	for rows.Next() {
+		var stat internal.PeriodStat
+		if err := rows.Scan(&stat.Period, &stat.NumTx, &stat.TotalVolume); err != nil {
+			return nil, fmt.Errorf("error scanning project stats: %w", err)
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+func (c *ClickHouse) TopProjects(ctx context.Context, query internal.StatsQuery) ([]internal.ProjectStat, error) {
+	rows, err := c.conn.Query(ctx, `SELECT project_id, sum(num_transactions), sum(total_volume_usd) AS volume
+FROM market_stats
+WHERE date >= ? AND date <= ?
+GROUP BY project_id
+ORDER BY volume DESC, project_id
+LIMIT ? OFFSET ?`, query.From, query.To, query.Limit, query.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("error querying top projects: %w", err)
+	}
+	defer rows.Close()
+	var stats []internal.ProjectStat
+	for rows.Next() {
+		var stat internal.ProjectStat
+		if err := rows.Scan(&stat.ProjectID, &stat.NumTx, &stat.TotalVolume); err != nil {
+			return nil, fmt.Errorf("error scanning top projects: %w", err)
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+// CurrencyStats reads the detail of the transactions, the stats having no
+// currency.
+func (c *ClickHouse) CurrencyStats(ctx context.Context, query internal.StatsQuery) ([]internal.CurrencyStat, error) {
+	rows, err := c.conn.Query(ctx, `SELECT currency_symbol, count(), sum(amount), sum(value_usd) AS volume
+FROM market_transactions
+WHERE project_id = ? AND timestamp >= ? AND timestamp < ?
+GROUP BY currency_symbol
+ORDER BY volume DESC, currency_symbol
+LIMIT ? OFFSET ?`, query.ProjectID, query.From, query.To.AddDate(0, 0, 1), query.Limit, query.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("error querying currency stats: %w", err)
+	}
+	defer rows.Close()
+	var stats []internal.CurrencyStat
+	for rows.Next() {
+		var stat internal.CurrencyStat
+		if err := rows.Scan(&stat.CurrencySymbol, &stat.NumTx, &stat.Amount, &stat.TotalVolume); err != nil {
+			return nil, fmt.Errorf("error scanning currency stats: %w", err)
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+func (c *ClickHouse) MarketStats(ctx context.Context, query internal.StatsQuery) ([]internal.MarketStat, error) {
+	rows, err := c.conn.Query(ctx, `SELECT date, project_id, any(dimensions), sum(num_transactions), sum(total_volume_usd)
+FROM market_stats
+WHERE (? = 0 OR project_id = ?) AND date >= ? AND date <= ?
+GROUP BY date, project_id, dimensions_key
+ORDER BY date, project_id, dimensions_key
+LIMIT ? OFFSET ?`, query.ProjectID, query.ProjectID, query.From, query.To, query.Limit, query.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("error querying market stats: %w", err)
+	}
+	defer rows.Close()
+	var stats []internal.MarketStat
+	for rows.Next() {
+		var stat internal.MarketStat
+		if err := rows.Scan(&stat.Date, &stat.ProjectID, &stat.Dimensions, &stat.NumTx, &stat.TotalVolume); err != nil {
+			return nil, fmt.Errorf("error scanning market stats: %w", err)
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
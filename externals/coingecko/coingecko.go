@@ -1,15 +1,25 @@
 package coingecko
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal/metrics"
+	"github.com/lat1992/blockchain-data-aggregator/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/lat1992/blockchain-data-aggregator/externals/coingecko")
+
 type Client struct {
 	httpClient *http.Client
 	url        string
@@ -29,8 +39,14 @@ func New(url, apiKey string) *Client {
 	}
 }
 
-func (c *Client) buildAndSendRequest(endpoint string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", endpoint, nil)
+// buildAndSendRequest sends a request to the url, name being the endpoint of
+// its metrics and span.
+func (c *Client) buildAndSendRequest(ctx context.Context, name, endpoint string) (res *http.Response, err error) {
+	ctx, span := tracer.Start(ctx, "GET "+name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("http.request.method", "GET"), attribute.String("url.full", endpoint)))
+	defer func() { tracing.End(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -38,14 +54,34 @@ func (c *Client) buildAndSendRequest(endpoint string) (*http.Response, error) {
 	req.Header.Add("accept", "application/json")
 	req.Header.Add("x-cg-demo-api-key", c.apiKey)
 
-	res, err := c.httpClient.Do(req)
+	start := time.Now()
+	res, err = c.httpClient.Do(req)
+	metrics.CoinGeckoRequestDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
 	if err != nil {
-		return nil, fmt.Errorf("failed to get token ids from coingecko: %w", err)
+		metrics.CoinGeckoRequests.WithLabelValues(name, "error").Inc()
+		return nil, fmt.Errorf("failed to send request to coingecko: %w", err)
 	}
+	metrics.CoinGeckoRequests.WithLabelValues(name, strconv.Itoa(res.StatusCode)).Inc()
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
 
 	return res, nil
 }
 
+// Ping checks that CoinGecko is reachable and accepts the API key.
+func (c *Client) Ping(ctx context.Context) error {
+	res, err := c.buildAndSendRequest(ctx, "ping", c.url+"/ping")
+	if err != nil {
+		return err
+	}
+	if err := res.Body.Close(); err != nil {
+		slog.Error("failed to close response body", "err", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code from coingecko: %d", res.StatusCode)
+	}
+	return nil
+}
+
 func (c *Client) InitTokenIDs() error {
 	response, err := c.getTokenIDs()
 	if err != nil {
@@ -63,7 +99,7 @@ type coinGeckoCoinsListResponse struct {
 }
 
 func (c *Client) getTokenIDs() ([]coinGeckoCoinsListResponse, error) {
-	res, err := c.buildAndSendRequest(c.url + "/coins/list")
+	res, err := c.buildAndSendRequest(context.Background(), "coins/list", c.url+"/coins/list")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get token ids from coingecko: %w", err)
 	}
@@ -89,13 +125,23 @@ func (c *Client) GetTokenID(symbol string) string {
 	return ""
 }
 
-func (c *Client) GetPrice(symbol, date string) (float64, error) {
+// GetPrice returns the USD price of a token at a date, DD-MM-YYYY, requested
+// once and cached. It is traced in a span child of the span of ctx.
+func (c *Client) GetPrice(ctx context.Context, symbol, date string) (_ float64, err error) {
+	ctx, span := tracer.Start(ctx, "GetPrice", trace.WithAttributes(
+		attribute.String("symbol", symbol),
+		attribute.String("date", date)))
+	defer func() { tracing.End(span, err) }()
+
 	key := symbol + "-" + date
 	price, exist := c.priceCache.Load(key)
+	span.SetAttributes(attribute.Bool("cache_hit", exist))
 	if exist {
+		metrics.CoinGeckoCacheHits.Inc()
 		return price.(float64), nil
 	}
-	result, err := c.getPriceFromSource(symbol, date)
+	metrics.CoinGeckoCacheMisses.Inc()
+	result, err := c.getPriceFromSource(ctx, symbol, date)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get price from source: %w", err)
 	}
@@ -111,7 +157,7 @@ type coinGeckoCoinsHistoryResponse struct {
 	} `json:"market_data"`
 }
 
-func (c *Client) getPriceFromSource(symbol, date string) (float64, error) {
+func (c *Client) getPriceFromSource(ctx context.Context, symbol, date string) (float64, error) {
 	id := c.GetTokenID(symbol)
 	if id == "" {
 		slog.Error("id not found with token symbol", "symbol", symbol)
@@ -124,7 +170,7 @@ func (c *Client) getPriceFromSource(symbol, date string) (float64, error) {
 		return 0, nil
 	}
 
-	res, err := c.buildAndSendRequest(c.url + "/coins/" + id + "/history?date=" + date + "&localization=false")
+	res, err := c.buildAndSendRequest(ctx, "coins/history", c.url+"/coins/"+id+"/history?date="+date+"&localization=false")
 	if err != nil {
 		return 0, fmt.Errorf("failed to get token price from coingecko: %w", err)
 	}
@@ -1,6 +1,7 @@
 package coingecko
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -49,7 +50,7 @@ func TestGetTokenPrice(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			price, err := client.GetPrice(tc.symbol, tc.date)
+			price, err := client.GetPrice(context.Background(), tc.symbol, tc.date)
 			if tc.err != nil {
 				assert.Error(t, err)
 			}
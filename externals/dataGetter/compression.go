@@ -0,0 +1,66 @@
+package dataGetter
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress returns a reader over the content of a gzip or zstd compressed
+// file, detected by its extension or its magic bytes, along with the file
+// name stripped of the compression extension. Other files are returned as is.
+// The returned function releases the decompressor.
+func decompress(r io.Reader, fileName string) (io.Reader, string, func(), error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	name := fileName
+	switch ext {
+	case ".gz", ".gzip", ".zst", ".zstd":
+		name = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	}
+
+	// Files and S3 objects are kept as is rather than buffered, for the
+	// parquet reader to read them at random. An object moves its offset on
+	// ReadAt, hence the seek back.
+	magic := make([]byte, len(zstdMagic))
+	if f, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		n, _ := f.ReadAt(magic, 0)
+		magic = magic[:n]
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, "", nil, fmt.Errorf("failed to rewind file: %w", err)
+		}
+	} else {
+		buffered := bufio.NewReader(r)
+		magic, _ = buffered.Peek(len(magic))
+		r = buffered
+	}
+
+	switch {
+	case ext == ".gz" || ext == ".gzip" || bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		return zr, name, func() { zr.Close() }, nil
+	case ext == ".zst" || ext == ".zstd" || bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to open zstd stream: %w", err)
+		}
+		return zr, name, zr.Close, nil
+	}
+	return r, name, func() {}, nil
+}
@@ -1,54 +1,145 @@
 package dataGetter
 
 import (
-	"encoding/csv"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
-	"os"
 
 	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/metrics"
+	"github.com/lat1992/blockchain-data-aggregator/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/lat1992/blockchain-data-aggregator/externals/dataGetter")
+
 type DataGetter struct {
-	path          string
+	source        Source
 	recordChannel chan internal.Record
 	endChannel    chan bool
 	goroutineNum  int
+	schema        Schema
+	format        string
+	trackerPath   string
+	tracker       *tracker
+}
+
+type Option func(*DataGetter)
+
+// WithSchema sets the mapping from record fields to the columns of the files.
+func WithSchema(schema Schema) Option {
+	return func(g *DataGetter) {
+		g.schema = schema
+	}
+}
+
+// WithFormat reads every file with the given format instead of picking it
+// from the file extension.
+func WithFormat(format string) Option {
+	return func(g *DataGetter) {
+		g.format = format
+	}
+}
+
+// WithSource reads the files of the given source instead of the local path.
+func WithSource(source Source) Option {
+	return func(g *DataGetter) {
+		g.source = source
+	}
 }
 
-func New(path string, gNum int) *DataGetter {
-	return &DataGetter{
-		path:          path,
+// WithTracking records the processed files in the given state file and skips
+// them on later runs. Files are recorded when the data read is committed.
+func WithTracking(path string) Option {
+	return func(g *DataGetter) {
+		g.trackerPath = path
+	}
+}
+
+func New(path string, gNum int, opts ...Option) *DataGetter {
+	g := &DataGetter{
+		source:        &localSource{dir: path},
 		recordChannel: make(chan internal.Record, gNum*2),
 		endChannel:    make(chan bool),
 		goroutineNum:  gNum,
+		schema:        DefaultSchema(),
+	}
+	for _, opt := range opts {
+		opt(g)
 	}
+	return g
 }
 
-func (g *DataGetter) ReadDataFromFiles() error {
-	p, err := os.Open(g.path)
-	if err != nil {
-		return fmt.Errorf("failed to open path: %w", err)
+// ReadDataFromFiles sends the records of the files not processed yet. A file
+// that cannot be read is skipped, to be read again at the next run, unless
+// some of its records were sent: the read then fails, for the run not to
+// store these records and read them again. The files read by a previous run
+// not committed are forgotten. The read stops when ctx is done.
+func (g *DataGetter) ReadDataFromFiles(ctx context.Context) error {
+	defer func() {
+		for i := 0; i < g.goroutineNum; i++ {
+			g.endChannel <- true
+		}
+	}()
+
+	if g.trackerPath != "" && g.tracker == nil {
+		t, err := loadTracker(g.trackerPath)
+		if err != nil {
+			return err
+		}
+		g.tracker = t
+	}
+	if g.tracker != nil {
+		g.tracker.reset()
 	}
-	files, err := p.Readdir(0)
+
+	files, err := g.source.List(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to list files: %w", err)
+		return err
 	}
 
 	for _, f := range files {
-		g.readDataFromFile(g.path + "/" + f.Name())
-	}
-	for i := 0; i < g.goroutineNum; i++ {
-		g.endChannel <- true
+		if g.tracker != nil && g.tracker.isProcessed(f) {
+			slog.Info("skipping processed file", "file", f.URI)
+			continue
+		}
+		if records, err := g.readDataFromFile(ctx, f); err != nil {
+			if records > 0 || ctx.Err() != nil {
+				return fmt.Errorf("failed to read file %s after %d records: %w", f.URI, records, err)
+			}
+			slog.Error("failed to read file", "file", f.URI, "err", err)
+			continue
+		}
+		if g.tracker != nil {
+			g.tracker.add(f)
+		}
 	}
 	return nil
 }
 
-func (g *DataGetter) readDataFromFile(fileName string) error {
-	file, err := os.Open(fileName)
+// Commit records the files read so far as processed.
+func (g *DataGetter) Commit() error {
+	if g.tracker == nil {
+		return nil
+	}
+	return g.tracker.commit()
+}
+
+// readDataFromFile sends the records of the file, and returns how many were
+// sent.
+func (g *DataGetter) readDataFromFile(ctx context.Context, f File) (records int, err error) {
+	ctx, span := tracer.Start(ctx, "ReadFile", trace.WithAttributes(attribute.String("file", f.URI)))
+	defer func() {
+		span.SetAttributes(attribute.Int("records", records))
+		tracing.End(span, err)
+	}()
+
+	file, err := g.source.Open(ctx, f)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		return 0, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer func() {
 		if err := file.Close(); err != nil {
@@ -56,44 +147,43 @@ func (g *DataGetter) readDataFromFile(fileName string) error {
 		}
 	}()
 
-	parser := csv.NewReader(file)
+	content, name, release, err := decompress(file, f.Name)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
 
-	header, err := g.getHeader(parser)
+	format := formatOf(name, g.format)
+	span.SetAttributes(attribute.String("format", format))
+	reader, closeReader, err := newRowReader(format, content)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s file: %w", format, err)
+	}
+	defer closeReader()
+	sources, err := g.schema.resolve(reader.Columns())
 	if err != nil {
-		return fmt.Errorf("failed to get header: %w", err)
+		return 0, fmt.Errorf("invalid columns: %w", err)
 	}
 
+	read := metrics.RecordsRead.WithLabelValues(f.URI)
 	for {
-		record, err := parser.Read()
+		row, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed to read record: %w", err)
-		}
-		g.recordChannel <- internal.Record{
-			Timestamp: record[header["ts"]],
-			Event:     record[header["event"]],
-			ProjectID: record[header["project_id"]],
-			Props:     record[header["props"]],
-			Nums:      record[header["nums"]],
+			return records, fmt.Errorf("failed to read record: %w", err)
 		}
-	}
-	return nil
-}
-
-func (g *DataGetter) getHeader(parser *csv.Reader) (map[string]int, error) {
-	record, err := parser.Read()
-	if err != nil {
-		return nil, fmt.Errorf("failed to read header: %w", err)
-	}
-	header := make(map[string]int)
-	for i, r := range record {
-		if r == "ts" || r == "event" || r == "project_id" || r == "props" || r == "nums" {
-			header[r] = i
+		if err := ctx.Err(); err != nil {
+			return records, err
 		}
+		record := newRecord(sources, row)
+		record.Source = f.URI
+		g.recordChannel <- record
+		read.Inc()
+		records++
 	}
-	return header, nil
+	return records, nil
 }
 
 func (g *DataGetter) Channel() chan internal.Record {
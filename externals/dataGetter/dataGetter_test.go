@@ -1,10 +1,21 @@
 package dataGetter
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -44,7 +55,9 @@ func TestReadDataFromFiles(t *testing.T) {
 		defer wg.Done()
 		for {
 			select {
-			case _ = <-g.Channel():
+			case record := <-g.Channel():
+				assert.Equal(t, "DE", record.Columns["country"])
+				assert.Equal(t, "desktop", record.Columns["device_type"])
 				count++
 			case _ = <-g.EndChannel():
 				assert.Equal(t, 4, count)
@@ -55,9 +68,420 @@ func TestReadDataFromFiles(t *testing.T) {
 
 	go func() {
 		defer wg.Done()
-		if err := g.ReadDataFromFiles(); err != nil {
+		if err := g.ReadDataFromFiles(context.Background()); err != nil {
 			t.Errorf("failed to read data from files: %v", err)
 		}
 	}()
 	wg.Wait()
 }
+
+func TestReadDataFromFileSchema(t *testing.T) {
+	dir := t.TempDir()
+	content := `"time","payload","props","nums"
+"2024-04-15 02:15:07.167","{""project"":{""id"":""4974""},""event"":""BUY_ITEMS""}","{""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""0.61""}"
+`
+	if err := os.WriteFile(dir+"/data.csv", []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name   string
+		schema Schema
+		err    bool
+	}{
+		{
+			name: "columns and json paths",
+			schema: Schema{
+				FieldTimestamp: "time",
+				FieldEvent:     "payload.event",
+				FieldProjectID: "payload.project.id",
+				FieldProps:     "props",
+				FieldNums:      "nums",
+			},
+		},
+		{
+			name:   "missing required column",
+			schema: DefaultSchema(),
+			err:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := New(dir, 1, WithSchema(tc.schema))
+			errChan := make(chan error, 1)
+			go func() {
+				_, err := g.readDataFromFile(context.Background(), File{Name: "data.csv"})
+				errChan <- err
+			}()
+
+			if tc.err {
+				assert.ErrorContains(t, <-errChan, `missing column "ts" for field "ts"`)
+				return
+			}
+			record := <-g.Channel()
+			assert.NoError(t, <-errChan)
+			assert.Equal(t, "2024-04-15 02:15:07.167", record.Timestamp)
+			assert.Equal(t, "BUY_ITEMS", record.Event)
+			assert.Equal(t, "4974", record.ProjectID)
+			assert.Equal(t, `{"currencySymbol":"SFL"}`, record.Props)
+			assert.Equal(t, `{"currencyValueDecimal":"0.61"}`, record.Nums)
+		})
+	}
+}
+
+func TestReadDataFromFileFormats(t *testing.T) {
+	dir := t.TempDir()
+	jsonl := `{"ts":"2024-04-15 02:15:07.167","event":"BUY_ITEMS","project_id":4974,"props":{"currencySymbol":"SFL","chainId":"137"},"nums":{"currencyValueDecimal":"0.61"},"country":"DE"}
+
+{"ts":"2024-04-15 02:36:37.134","event":"BUY_ITEMS","project_id":4974,"props":{"currencySymbol":"SFL","chainId":"137"},"nums":{"currencyValueDecimal":"2.36"},"country":"DE"}
+`
+	if err := os.WriteFile(dir+"/data.ndjson", []byte(jsonl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parquetData, err := os.ReadFile("../../internal/parquet/testdata/records.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/data.parquet", parquetData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	csvData := `"ts","event","project_id","props","nums","country"
+"2024-04-15 02:15:07.167","BUY_ITEMS","4974","{""chainId"":""137""}","{""currencyValueDecimal"":""0.61""}","DE"
+`
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	if _, err := gw.Write([]byte(csvData)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/data.csv.gz", gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/data", gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/data.jsonl.zst", zw.EncodeAll([]byte(jsonl), nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/data.parquet.zst", zw.EncodeAll(parquetData, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		fileName string
+		count    int
+	}{
+		{
+			name:     "json lines",
+			fileName: "data.ndjson",
+			count:    2,
+		},
+		{
+			name:     "parquet",
+			fileName: "data.parquet",
+			count:    3,
+		},
+		{
+			name:     "gzip csv",
+			fileName: "data.csv.gz",
+			count:    1,
+		},
+		{
+			name:     "gzip detected by magic bytes",
+			fileName: "data",
+			count:    1,
+		},
+		{
+			name:     "zstd json lines",
+			fileName: "data.jsonl.zst",
+			count:    2,
+		},
+		{
+			name:     "zstd parquet",
+			fileName: "data.parquet.zst",
+			count:    3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := New(dir, 1)
+			errChan := make(chan error, 1)
+			go func() {
+				_, err := g.readDataFromFile(context.Background(), File{Name: tc.fileName})
+				errChan <- err
+			}()
+
+			record := <-g.Channel()
+			assert.Equal(t, "2024-04-15 02:15:07.167", record.Timestamp)
+			assert.Equal(t, "BUY_ITEMS", record.Event)
+			assert.Equal(t, "4974", record.ProjectID)
+			assert.Equal(t, `{"currencyValueDecimal":"0.61"}`, record.Nums)
+			assert.Equal(t, "DE", record.Columns["country"])
+			chainID, _ := record.Field("props.chainId")
+			assert.Equal(t, "137", chainID)
+			for i := 1; i < tc.count; i++ {
+				<-g.Channel()
+			}
+			assert.NoError(t, <-errChan)
+		})
+	}
+}
+
+func TestReadDataFromFilesPartialFile(t *testing.T) {
+	dir := t.TempDir()
+	content := "ts,event,project_id,props,nums\n" +
+		"2024-04-15 02:15:07.167,BUY_ITEMS,4974,{},{}\n" +
+		"2024-04-15 02:16:07.167,BUY_ITEMS\n"
+	if err := os.WriteFile(dir+"/data.csv", []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/columns.csv", []byte("a,b\n1,2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := New(dir, 1, WithTracking(dir+"/processed.json"))
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- g.ReadDataFromFiles(context.Background())
+	}()
+	record := <-g.Channel()
+	assert.Equal(t, "2024-04-15 02:15:07.167", record.Timestamp)
+	<-g.EndChannel()
+	// The file is not tracked, and the run is failed before it stores the
+	// record sent. The file without any record read is only skipped.
+	assert.ErrorContains(t, <-errChan, "after 1 records")
+	assert.Empty(t, g.tracker.pending)
+}
+
+func TestReadDataFromFilesNotCommitted(t *testing.T) {
+	dir := t.TempDir()
+	moved := t.TempDir() + "/data.csv"
+	content := "ts,event,project_id,props,nums\n" +
+		"2024-04-15 02:15:07.167,BUY_ITEMS,4974,{},{}\n"
+	if err := os.WriteFile(dir+"/data.csv", []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := New(dir, 1, WithTracking(t.TempDir()+"/processed"))
+	run := func() int {
+		count := 0
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				select {
+				case <-g.Channel():
+					count++
+				case <-g.EndChannel():
+					// Drain the buffered records for the next run.
+					for len(g.Channel()) > 0 {
+						<-g.Channel()
+						count++
+					}
+					return
+				}
+			}
+		}()
+		assert.NoError(t, g.ReadDataFromFiles(context.Background()))
+		<-done
+		return count
+	}
+
+	// The first run fails after reading the file, and is not committed. The
+	// next one, committed, does not see the file, moved away meanwhile.
+	assert.Equal(t, 1, run())
+	if err := os.Rename(dir+"/data.csv", moved); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, run())
+	assert.NoError(t, g.Commit())
+
+	// Moved back unchanged, the file is read again: the commit did not record
+	// it for the failed run.
+	if err := os.Rename(moved, dir+"/data.csv"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, run())
+}
+
+func TestFormatOf(t *testing.T) {
+	assert.Equal(t, FormatCSV, formatOf("datas/sample_data.csv", ""))
+	assert.Equal(t, FormatJSONL, formatOf("datas/sample_data.jsonl", ""))
+	assert.Equal(t, FormatParquet, formatOf("datas/sample_data.PARQUET", ""))
+	assert.Equal(t, FormatCSV, formatOf("datas/sample_data", ""))
+	assert.Equal(t, FormatJSONL, formatOf("datas/sample_data.csv", FormatJSONL))
+}
+
+// fakeS3 serves the objects of one bucket with the subset of the S3 API used
+// by the S3 source: ListObjectsV2 and GetObject.
+type fakeS3 struct {
+	bucket  string
+	objects map[string][]byte
+	// ranges records the Range header of every object GET.
+	ranges []string
+}
+
+type fakeS3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+}
+
+type fakeS3List struct {
+	XMLName     xml.Name       `xml:"ListBucketResult"`
+	Name        string         `xml:"Name"`
+	Prefix      string         `xml:"Prefix"`
+	KeyCount    int            `xml:"KeyCount"`
+	IsTruncated bool           `xml:"IsTruncated"`
+	Contents    []fakeS3Object `xml:"Contents"`
+}
+
+func (s *fakeS3) etag(key string) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(s.objects[key]))
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+s.bucket), "/")
+	if key == "" && r.URL.Query().Get("list-type") == "2" {
+		prefix := r.URL.Query().Get("prefix")
+		list := fakeS3List{Name: s.bucket, Prefix: prefix}
+		for k, data := range s.objects {
+			if strings.HasPrefix(k, prefix) {
+				list.Contents = append(list.Contents, fakeS3Object{
+					Key:          k,
+					LastModified: "2024-04-15T00:00:00.000Z",
+					ETag:         s.etag(k),
+					Size:         len(data),
+				})
+			}
+		}
+		list.KeyCount = len(list.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		if err := xml.NewEncoder(w).Encode(list); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	data, ok := s.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodGet {
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+	}
+	w.Header().Set("ETag", s.etag(key))
+	http.ServeContent(w, r, key, time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC), bytes.NewReader(data))
+}
+
+func TestReadDataFromS3(t *testing.T) {
+	csvData := `"ts","event","project_id","props","nums"
+"2024-04-15 02:15:07.167","BUY_ITEMS","4974","{}","{}"
+"2024-04-15 02:26:37.134","BUY_ITEMS","4974","{}","{}"
+`
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	if _, err := gw.Write([]byte(csvData)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s3 := &fakeS3{
+		bucket: "exports",
+		objects: map[string][]byte{
+			"daily/2024-04-15.csv":    []byte(csvData),
+			"daily/2024-04-16.csv.gz": gz.Bytes(),
+			"weekly/2024-15.csv":      []byte(csvData),
+		},
+	}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	source, err := NewS3Source("s3://exports/daily/", S3Config{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		AccessKey: "access",
+		SecretKey: "secret",
+		Region:    "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trackingPath := t.TempDir() + "/processed"
+
+	run := func() int {
+		g := New("", 1, WithSource(source), WithTracking(trackingPath))
+		count := 0
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				select {
+				case <-g.Channel():
+					count++
+				case <-g.EndChannel():
+					// The record channel is buffered: count what is left.
+					count += len(g.Channel())
+					return
+				}
+			}
+		}()
+		assert.NoError(t, g.ReadDataFromFiles(context.Background()))
+		<-done
+		assert.NoError(t, g.Commit())
+		return count
+	}
+
+	assert.Equal(t, 4, run())
+	assert.Equal(t, 0, run(), "processed files are skipped")
+
+	s3.objects["daily/2024-04-15.csv"] = []byte(csvData + `"2024-04-15 03:00:00.000","BUY_ITEMS","4974","{}","{}"` + "\n")
+	assert.Equal(t, 3, run(), "changed files are read again")
+}
+
+func TestReadDataFromS3Parquet(t *testing.T) {
+	parquetData, err := os.ReadFile("../../internal/parquet/testdata/records.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3 := &fakeS3{
+		bucket:  "exports",
+		objects: map[string][]byte{"daily/records.parquet": parquetData},
+	}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	source, err := NewS3Source("s3://exports/daily/", S3Config{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		AccessKey: "access",
+		SecretKey: "secret",
+		Region:    "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := New("", 1, WithSource(source))
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := g.readDataFromFile(context.Background(), File{Name: "daily/records.parquet"})
+		errChan <- err
+	}()
+	for range 3 {
+		<-g.Channel()
+	}
+	assert.NoError(t, <-errChan)
+
+	// The object is only read at the offsets asked for, the footer first.
+	assert.Contains(t, s3.ranges, fmt.Sprintf("bytes=%d-%d", len(parquetData)-8, len(parquetData)-1))
+	assert.NotContains(t, s3.ranges, "")
+}
@@ -0,0 +1,241 @@
+package dataGetter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/parquet"
+	"github.com/minio/minio-go/v7"
+)
+
+// Input file formats.
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// rowReader reads the rows of an input file.
+type rowReader interface {
+	// Columns returns the column names of the file.
+	Columns() []string
+	// Read returns the next row keyed by column name, or io.EOF after the
+	// last one.
+	Read() (map[string]string, error)
+}
+
+// formatOf returns the format of a file: the configured one if any, else the
+// one matching its extension, defaulting to CSV.
+func formatOf(fileName, format string) string {
+	if format != "" {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	case ".parquet":
+		return FormatParquet
+	}
+	return FormatCSV
+}
+
+// newRowReader returns a reader of the rows of r, and a function releasing
+// what it holds.
+func newRowReader(format string, r io.Reader) (rowReader, func(), error) {
+	switch format {
+	case FormatCSV:
+		reader, err := newCSVReader(r)
+		return reader, func() {}, err
+	case FormatJSONL:
+		reader, err := newJSONLReader(r)
+		return reader, func() {}, err
+	case FormatParquet:
+		return newParquetReader(r)
+	}
+	return nil, nil, fmt.Errorf("unknown format %q", format)
+}
+
+type csvReader struct {
+	parser *csv.Reader
+	header []string
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	parser := csv.NewReader(r)
+	header, err := parser.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	return &csvReader{
+		parser: parser,
+		header: header,
+	}, nil
+}
+
+func (r *csvReader) Columns() []string {
+	return r.header
+}
+
+func (r *csvReader) Read() (map[string]string, error) {
+	record, err := r.parser.Read()
+	if err != nil {
+		return nil, err
+	}
+	row := make(map[string]string, len(r.header))
+	for i, name := range r.header {
+		row[name] = record[i]
+	}
+	return row, nil
+}
+
+// jsonlReader reads one JSON object per line. Nested values are kept in their
+// JSON form, so that JSON columns such as props read as they do in CSV.
+type jsonlReader struct {
+	reader  *bufio.Reader
+	columns []string
+	next    map[string]string
+}
+
+func newJSONLReader(r io.Reader) (*jsonlReader, error) {
+	reader := &jsonlReader{reader: bufio.NewReader(r)}
+	first, err := reader.readLine()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	for column := range first {
+		reader.columns = append(reader.columns, column)
+	}
+	slices.Sort(reader.columns)
+	reader.next = first
+	return reader, nil
+}
+
+func (r *jsonlReader) Columns() []string {
+	return r.columns
+}
+
+func (r *jsonlReader) Read() (map[string]string, error) {
+	if r.next != nil {
+		row := r.next
+		r.next = nil
+		return row, nil
+	}
+	return r.readLine()
+}
+
+func (r *jsonlReader) readLine() (map[string]string, error) {
+	for {
+		line, err := r.reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		row, err := DecodeJSONRow(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode line: %w", err)
+		}
+		return row, nil
+	}
+}
+
+// DecodeJSONRow decodes a JSON object into a row keyed by column name. Nested
+// values are kept in their JSON form.
+func DecodeJSONRow(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	row := make(map[string]string, len(object))
+	for column, value := range object {
+		row[column], _ = internal.JSONValue(value)
+	}
+	return row, nil
+}
+
+type parquetReader struct {
+	reader  *parquet.Reader
+	columns []string
+}
+
+func newParquetReader(r io.Reader) (*parquetReader, func(), error) {
+	at, size, release, err := readerAt(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := parquet.NewReader(at, size)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return &parquetReader{
+		reader:  reader,
+		columns: reader.Columns(),
+	}, release, nil
+}
+
+func (r *parquetReader) Columns() []string {
+	return r.columns
+}
+
+func (r *parquetReader) Read() (map[string]string, error) {
+	values, err := r.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	row := make(map[string]string, len(r.columns))
+	for i, name := range r.columns {
+		row[name] = values[i]
+	}
+	return row, nil
+}
+
+// readerAt gives random access to r, which parquet needs to read its footer
+// first: local files and S3 objects are read at the offsets asked for, with
+// ranged requests for the latter. Decompressed streams, which cannot seek, are
+// copied to a temporary file removed by the returned function.
+func readerAt(r io.Reader) (io.ReaderAt, int64, func(), error) {
+	switch f := r.(type) {
+	case *os.File:
+		info, err := f.Stat()
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("failed to stat file: %w", err)
+		}
+		return f, info.Size(), func() {}, nil
+	case *minio.Object:
+		info, err := f.Stat()
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("failed to stat object: %w", err)
+		}
+		return f, info.Size, func() {}, nil
+	}
+	tmp, err := os.CreateTemp("", "parquet-*")
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	release := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		release()
+		return nil, 0, nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return tmp, size, release, nil
+}
@@ -0,0 +1,84 @@
+package dataGetter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Region    string
+	UseSSL    bool
+}
+
+// S3Source reads the objects under a prefix of an S3 compatible bucket.
+type S3Source struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Source creates a source for an s3://bucket/prefix URI.
+func NewS3Source(uri string, config S3Config) (*S3Source, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 uri %q", uri)
+	}
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+	return &S3Source{
+		client: client,
+		bucket: u.Host,
+		prefix: strings.TrimPrefix(u.Path, "/"),
+	}, nil
+}
+
+// IsS3URI reports whether a data path points to S3.
+func IsS3URI(path string) bool {
+	return strings.HasPrefix(path, "s3://")
+}
+
+func (s *S3Source) List(ctx context.Context) ([]File, error) {
+	var files []File
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", object.Err)
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		files = append(files, File{
+			Name:    object.Key,
+			URI:     "s3://" + path.Join(s.bucket, object.Key),
+			Version: object.ETag,
+		})
+	}
+	return files, nil
+}
+
+// Open streams an object. The returned reader also supports ranged reads.
+func (s *S3Source) Open(ctx context.Context, file File) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, file.Name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	return object, nil
+}
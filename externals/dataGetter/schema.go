@@ -0,0 +1,101 @@
+package dataGetter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+// Logical fields of a record.
+const (
+	FieldTimestamp = "ts"
+	FieldEvent     = "event"
+	FieldProjectID = "project_id"
+	FieldProps     = "props"
+	FieldNums      = "nums"
+)
+
+var requiredFields = []string{FieldTimestamp, FieldProjectID, FieldProps, FieldNums}
+
+// Schema maps the logical fields of a record to their source. A source is
+// either a column name or a column holding a JSON object followed by a dotted
+// path into it, e.g. "payload.project.id".
+type Schema map[string]string
+
+func DefaultSchema() Schema {
+	return Schema{
+		FieldTimestamp: FieldTimestamp,
+		FieldEvent:     FieldEvent,
+		FieldProjectID: FieldProjectID,
+		FieldProps:     FieldProps,
+		FieldNums:      FieldNums,
+	}
+}
+
+type fieldSource struct {
+	column string
+	path   string
+}
+
+// resolve locates the source of every field in the columns of a file, failing
+// when a required field has no matching column.
+func (s Schema) resolve(columns []string) (map[string]fieldSource, error) {
+	exists := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		exists[column] = true
+	}
+	sources := make(map[string]fieldSource, len(s))
+	for field, source := range s {
+		if exists[source] {
+			sources[field] = fieldSource{column: source}
+			continue
+		}
+		column, path, _ := strings.Cut(source, ".")
+		if exists[column] && path != "" {
+			sources[field] = fieldSource{column: column, path: path}
+		}
+	}
+	for _, field := range requiredFields {
+		if _, ok := sources[field]; !ok {
+			return nil, fmt.Errorf("missing column %q for field %q", s[field], field)
+		}
+	}
+	return sources, nil
+}
+
+// Record maps a single row to a record, for sources that have no file wide
+// columns such as message streams.
+func (s Schema) Record(row map[string]string) (internal.Record, error) {
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		columns = append(columns, column)
+	}
+	sources, err := s.resolve(columns)
+	if err != nil {
+		return internal.Record{}, err
+	}
+	return newRecord(sources, row), nil
+}
+
+func newRecord(sources map[string]fieldSource, row map[string]string) internal.Record {
+	value := func(field string) string {
+		source, ok := sources[field]
+		if !ok {
+			return ""
+		}
+		if source.path == "" {
+			return row[source.column]
+		}
+		v, _ := internal.JSONPath(row[source.column], source.path)
+		return v
+	}
+	return internal.Record{
+		Timestamp: value(FieldTimestamp),
+		Event:     value(FieldEvent),
+		ProjectID: value(FieldProjectID),
+		Props:     value(FieldProps),
+		Nums:      value(FieldNums),
+		Columns:   row,
+	}
+}
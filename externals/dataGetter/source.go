@@ -0,0 +1,59 @@
+package dataGetter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Source lists and opens the input files.
+type Source interface {
+	// List returns the files to read.
+	List(ctx context.Context) ([]File, error)
+	// Open opens a file returned by List for reading.
+	Open(ctx context.Context, file File) (io.ReadCloser, error)
+}
+
+type File struct {
+	// Name is the name of the file within the source.
+	Name string
+	// URI identifies the file across sources.
+	URI string
+	// Version changes whenever the content of the file changes.
+	Version string
+}
+
+// localSource reads the files of a local directory.
+type localSource struct {
+	dir string
+}
+
+func (s *localSource) List(_ context.Context) ([]File, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open path: %w", err)
+	}
+	files := make([]File, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %w", err)
+		}
+		files = append(files, File{
+			Name:    entry.Name(),
+			URI:     filepath.Join(s.dir, entry.Name()),
+			Version: strconv.FormatInt(info.Size(), 10) + "-" + strconv.FormatInt(info.ModTime().UnixNano(), 10),
+		})
+	}
+	return files, nil
+}
+
+func (s *localSource) Open(_ context.Context, file File) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.dir, file.Name))
+}
@@ -0,0 +1,94 @@
+package dataGetter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// tracker remembers the files already processed in a state file holding one
+// "uri<TAB>version" line per file, so that later runs skip them. Files read
+// during a run are only recorded once committed.
+type tracker struct {
+	mutex     sync.Mutex
+	path      string
+	processed map[string]string
+	pending   []File
+}
+
+func loadTracker(path string) (*tracker, error) {
+	t := &tracker{
+		path:      path,
+		processed: make(map[string]string),
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open processed files: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		uri, version, _ := strings.Cut(scanner.Text(), "\t")
+		t.processed[uri] = version
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read processed files: %w", err)
+	}
+	return t, nil
+}
+
+func (t *tracker) isProcessed(file File) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	version, ok := t.processed[file.URI]
+	return ok && version == file.Version
+}
+
+// reset drops the pending files of a run that was not committed, for them to
+// be read again rather than recorded by the commit of the next run.
+func (t *tracker) reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.pending = nil
+}
+
+func (t *tracker) add(file File) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.pending = append(t.pending, file)
+}
+
+// commit records the pending files as processed.
+func (t *tracker) commit() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.pending) == 0 {
+		return nil
+	}
+	file, err := os.OpenFile(t.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open processed files: %w", err)
+	}
+	var lines strings.Builder
+	for _, f := range t.pending {
+		lines.WriteString(f.URI + "\t" + f.Version + "\n")
+	}
+	if _, err := file.WriteString(lines.String()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write processed files: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write processed files: %w", err)
+	}
+	for _, f := range t.pending {
+		t.processed[f.URI] = f.Version
+	}
+	t.pending = nil
+	return nil
+}
@@ -0,0 +1,75 @@
+package evm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// eventTopic returns the topic of an event signature, its keccak256 hash.
+func eventTopic(signature string) string {
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write([]byte(signature))
+	return "0x" + hex.EncodeToString(hash.Sum(nil))
+}
+
+func decodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+}
+
+// topicAddress returns the address held by an indexed address topic.
+func topicAddress(topic string) string {
+	b, err := decodeHex(topic)
+	if err != nil || len(b) != 32 {
+		return ""
+	}
+	return "0x" + hex.EncodeToString(b[12:])
+}
+
+// word returns the 32 bytes word at offset of ABI encoded data.
+func word(data []byte, offset uint64) ([]byte, error) {
+	if offset+32 > uint64(len(data)) || offset+32 < offset {
+		return nil, fmt.Errorf("data too short for word at %d", offset)
+	}
+	return data[offset : offset+32], nil
+}
+
+func wordUint(data []byte, offset uint64) (uint64, error) {
+	w, err := word(data, offset)
+	if err != nil {
+		return 0, err
+	}
+	v := new(big.Int).SetBytes(w)
+	if !v.IsUint64() {
+		return 0, fmt.Errorf("value at %d out of range", offset)
+	}
+	return v.Uint64(), nil
+}
+
+// decodeUintArray decodes the uint256[] parameter at index i of ABI encoded
+// data.
+func decodeUintArray(data []byte, i int) ([]*big.Int, error) {
+	offset, err := wordUint(data, uint64(i)*32)
+	if err != nil {
+		return nil, err
+	}
+	length, err := wordUint(data, offset)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(len(data))/32 {
+		return nil, fmt.Errorf("array length %d out of range", length)
+	}
+	values := make([]*big.Int, length)
+	for j := range values {
+		w, err := word(data, offset+32+uint64(j)*32)
+		if err != nil {
+			return nil, err
+		}
+		values[j] = new(big.Int).SetBytes(w)
+	}
+	return values, nil
+}
@@ -0,0 +1,191 @@
+package evm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Client is a minimal EVM JSON-RPC client.
+type Client struct {
+	httpClient *http.Client
+	url        string
+	id         atomic.Int64
+}
+
+func NewClient(url string) *Client {
+	return &Client{
+		httpClient: &http.Client{
+			Timeout: time.Second * 30,
+		},
+		url: url,
+	}
+}
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int64  `json:"id"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+func (c *Client) call(ctx context.Context, result any, method string, params ...any) error {
+	if params == nil {
+		params = []any{}
+	}
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: c.id.Add(1), Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("content-type", "application/json")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", method, err)
+	}
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			slog.Error("failed to close response body", "err", err)
+		}
+	}()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to call %s: status %s", method, res.Status)
+	}
+
+	var response rpcResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to decode response body: %w", err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("failed to call %s: %w", method, response.Error)
+	}
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return fmt.Errorf("failed to decode %s result: %w", method, err)
+	}
+	return nil
+}
+
+// quantity is a hex encoded number of the JSON-RPC API.
+type quantity uint64
+
+func (q *quantity) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid quantity %q: %w", s, err)
+	}
+	*q = quantity(v)
+	return nil
+}
+
+func toQuantity(v uint64) string {
+	return "0x" + strconv.FormatUint(v, 16)
+}
+
+// ChainID returns the id of the chain of the node.
+func (c *Client) ChainID(ctx context.Context) (*big.Int, error) {
+	var id string
+	if err := c.call(ctx, &id, "eth_chainId"); err != nil {
+		return nil, err
+	}
+	v, ok := new(big.Int).SetString(strings.TrimPrefix(id, "0x"), 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid chain id %q", id)
+	}
+	return v, nil
+}
+
+// BlockNumber returns the number of the latest block.
+func (c *Client) BlockNumber(ctx context.Context) (uint64, error) {
+	var n quantity
+	if err := c.call(ctx, &n, "eth_blockNumber"); err != nil {
+		return 0, err
+	}
+	return uint64(n), nil
+}
+
+// BlockTime returns the timestamp of a block.
+func (c *Client) BlockTime(ctx context.Context, number uint64) (time.Time, error) {
+	var block *struct {
+		Timestamp quantity `json:"timestamp"`
+	}
+	if err := c.call(ctx, &block, "eth_getBlockByNumber", toQuantity(number), false); err != nil {
+		return time.Time{}, err
+	}
+	if block == nil {
+		return time.Time{}, fmt.Errorf("block %d not found", number)
+	}
+	return time.Unix(int64(block.Timestamp), 0).UTC(), nil
+}
+
+// Log is an event log of a transaction.
+type Log struct {
+	Address         string   `json:"address"`
+	Topics          []string `json:"topics"`
+	Data            string   `json:"data"`
+	BlockNumber     quantity `json:"blockNumber"`
+	TransactionHash string   `json:"transactionHash"`
+	LogIndex        quantity `json:"logIndex"`
+	Removed         bool     `json:"removed"`
+}
+
+// FilterLogs returns the logs of the given contracts and first topics in a
+// range of blocks.
+func (c *Client) FilterLogs(ctx context.Context, from, to uint64, addresses, topics []string) ([]Log, error) {
+	filter := map[string]any{
+		"fromBlock": toQuantity(from),
+		"toBlock":   toQuantity(to),
+		"address":   addresses,
+		"topics":    []any{topics},
+	}
+	var logs []Log
+	if err := c.call(ctx, &logs, "eth_getLogs", filter); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// Receipt is the receipt of a mined transaction.
+type Receipt struct {
+	Status quantity `json:"status"`
+	Logs   []Log    `json:"logs"`
+}
+
+// TransactionReceipt returns the receipt of a transaction, or nil when the
+// transaction is unknown or not mined yet.
+func (c *Client) TransactionReceipt(ctx context.Context, hash string) (*Receipt, error) {
+	var receipt *Receipt
+	if err := c.call(ctx, &receipt, "eth_getTransactionReceipt", hash); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
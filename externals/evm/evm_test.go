@@ -0,0 +1,262 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+// rpcFixture is a recorded JSON-RPC call of a Polygon node.
+type rpcFixture struct {
+	Method string          `json:"method"`
+	Params any             `json:"params"`
+	Result json.RawMessage `json:"result"`
+}
+
+// newFixtureServer replays the calls recorded in testdata/rpc.json.
+func newFixtureServer(t *testing.T) (*httptest.Server, *[]string) {
+	data, err := os.ReadFile("testdata/rpc.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []rpcFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     int64  `json:"id"`
+			Method string `json:"method"`
+			Params any    `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		calls = append(calls, req.Method)
+		mutex.Unlock()
+		for _, fixture := range fixtures {
+			if fixture.Method == req.Method && reflect.DeepEqual(fixture.Params, req.Params) {
+				_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": fixture.Result})
+				return
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "error": map[string]any{"code": -32602, "message": "no fixture"}})
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+var testMarket = Market{
+	Address:           "0x8bb759bb68995343ff1e9d57ac85ff5c5fb79334",
+	ProjectID:         "4974",
+	CollectionAddress: "0x22d5f9b75c524fec1d6619787e582644cd4d7422",
+	CurrencyAddress:   "0xd1f9c58e33933a993a3891f8acfe05a68e1afc05",
+	CurrencySymbol:    "SFL",
+	CurrencyDecimals:  18,
+}
+
+func readAll(t *testing.T, g *Getter) ([]internal.Record, error) {
+	var records []internal.Record
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case record := <-g.Channel():
+				records = append(records, record)
+			case <-g.EndChannel():
+				for len(g.Channel()) > 0 {
+					records = append(records, <-g.Channel())
+				}
+				return
+			}
+		}
+	}()
+	err := g.ReadDataFromFiles(context.Background())
+	<-done
+	return records, err
+}
+
+func TestGetter(t *testing.T) {
+	server, calls := newFixtureServer(t)
+	statePath := filepath.Join(t.TempDir(), "block")
+	newGetter := func() *Getter {
+		return New(NewClient(server.URL), []Market{testMarket}, statePath, 1, WithStartBlock(55847436), WithBlockRange(50))
+	}
+
+	g := newGetter()
+	records, err := readAll(t, g)
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, internal.Record{
+		Timestamp: "2024-04-15 02:18:51.000",
+		Event:     "BUY_ITEMS",
+		ProjectID: "4974",
+		Props:     `{"tokenId":"215","txnHash":"0xd919290e80df271e77d1cbca61f350d2727531e0334266671ec20d626b2104a2","chainId":"137","collectionAddress":"0x22d5f9b75c524fec1d6619787e582644cd4d7422","currencyAddress":"0xd1f9c58e33933a993a3891f8acfe05a68e1afc05","currencySymbol":"SFL","marketplaceType":"amm"}`,
+		Nums:      `{"currencyValueDecimal":"0.6136203411678249","currencyValueRaw":"613620341167824900"}`,
+		Source:    "evm://137/0xd919290e80df271e77d1cbca61f350d2727531e0334266671ec20d626b2104a2#5",
+		Columns: map[string]string{
+			"chain_id":         "137",
+			"block_number":     "55847440",
+			"log_index":        "5",
+			"exchange_address": "0x8bb759bb68995343ff1e9d57ac85ff5c5fb79334",
+			"buyer":            "0x0896ae95dcaeee38e83fa5c43bef99780d7b2be2",
+			"recipient":        "0x0896ae95dcaeee38e83fa5c43bef99780d7b2be2",
+			"token_amount":     "1",
+		},
+	}, records[0])
+	assert.Equal(t, `{"currencyValueDecimal":"2.361412166673735","currencyValueRaw":"2361412166673735000"}`, records[1].Nums)
+	assert.Equal(t, "2", records[1].Columns["token_amount"])
+	assert.Contains(t, records[2].Props, `"tokenId":"201"`, "later exchange versions are decoded too")
+
+	_, err = os.Stat(statePath)
+	assert.ErrorIs(t, err, os.ErrNotExist, "the state is written on commit")
+	assert.NoError(t, g.Commit())
+	assert.Equal(t, uint64(55847520), g.LastBlock(), "the last confirmed block")
+
+	*calls = nil
+	records, err = readAll(t, newGetter())
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+	assert.Equal(t, []string{"eth_chainId", "eth_blockNumber"}, *calls, "processed blocks are not read again")
+}
+
+func TestGetterError(t *testing.T) {
+	server, _ := newFixtureServer(t)
+	g := New(NewClient(server.URL), []Market{testMarket}, "", 1, WithStartBlock(55847436), WithBlockRange(60))
+
+	_, err := readAll(t, g)
+	assert.Error(t, err, "the range has no fixture")
+	assert.NoError(t, g.Commit())
+	assert.Equal(t, uint64(55847435), g.LastBlock(), "nothing is committed")
+}
+
+func TestLoadMarkets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "markets.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"address":"0x8BB759BB68995343FF1E9D57AC85FF5C5FB79334","projectId":"4974","currencySymbol":"SFL"}]`), 0644))
+	markets, err := LoadMarkets(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []Market{{Address: "0x8bb759bb68995343ff1e9d57ac85ff5c5fb79334", ProjectID: "4974", CurrencySymbol: "SFL", CurrencyDecimals: 18}}, markets)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"projectId":"4974"}]`), 0644))
+	_, err = LoadMarkets(path)
+	assert.Error(t, err)
+}
+
+func TestDecodeUintArray(t *testing.T) {
+	_, err := decodeUintArray([]byte{0x01}, 0)
+	assert.Error(t, err)
+
+	data := make([]byte, 96)
+	data[31] = 0x20 // offset of the array
+	data[63] = 0x01 // length
+	data[95] = 0x2a
+	values, err := decodeUintArray(data, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "42", values[0].String())
+
+	data[63] = 0xff
+	_, err = decodeUintArray(data, 0)
+	assert.Error(t, err)
+}
+
+func TestVerifier(t *testing.T) {
+	server, _ := newFixtureServer(t)
+	v := NewVerifier(map[string]string{"137": server.URL})
+	record := func(txnHash, chainID, valueRaw string) internal.Record {
+		return internal.Record{
+			Timestamp: "2024-04-15 02:15:07.167",
+			ProjectID: "4974",
+			Props:     `{"txnHash":"` + txnHash + `","chainId":"` + chainID + `","currencyAddress":"0xD1F9C58E33933A993A3891F8ACFE05A68E1AFC05"}`,
+			Nums:      `{"currencyValueRaw":"` + valueRaw + `"}`,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		record internal.Record
+		status string
+		reason string
+	}{
+		{
+			name:   "verified",
+			record: record("0xd919290e80df271e77d1cbca61f350d2727531e0334266671ec20d626b2104a2", "137", "613620341167824900"),
+			status: internal.VerificationVerified,
+		},
+		{
+			name:   "amount mismatch",
+			record: record("0x1133d2837267e0de2eddf3655a3df99e055d172cb53c4e8e108e70322438e994", "137", "2361412166673735000"),
+			status: internal.VerificationMismatched,
+			reason: "no transfer of the reported amount",
+		},
+		{
+			name:   "failed transaction",
+			record: record("0xcd5e34370546c26bc426bcfda6fcfc8fd0e08d2be6ee2e00f4d6d455318f8640", "137", "3277643971527509500"),
+			status: internal.VerificationMismatched,
+			reason: "transaction failed",
+		},
+		{
+			name:   "unknown transaction",
+			record: record("0x6c51abf80365cbf6a8a03d9e5fe939712742dff4b088d4f99ba44551907e5c2f", "137", "364528625334421950"),
+			status: internal.VerificationUnverified,
+			reason: "transaction not found",
+		},
+		{
+			name:   "unknown chain",
+			record: record("0xd919290e80df271e77d1cbca61f350d2727531e0334266671ec20d626b2104a2", "43114", "613620341167824900"),
+			status: internal.VerificationUnverified,
+			reason: `no endpoint for chain "43114"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			verification := v.Verify(tc.record)
+			assert.Equal(t, tc.status, verification.Status)
+			assert.Equal(t, tc.reason, verification.Reason)
+			assert.Equal(t, "4974", verification.ProjectID)
+			assert.Equal(t, "2024-04-15", verification.Date.Format(time.DateOnly))
+		})
+	}
+}
+
+func TestVerifierCache(t *testing.T) {
+	server, calls := newFixtureServer(t)
+	v := NewVerifier(map[string]string{"137": server.URL})
+	record := internal.Record{
+		Timestamp: "2024-04-15 02:15:07.167",
+		ProjectID: "4974",
+		Props:     `{"txnHash":"0xd919290e80df271e77d1cbca61f350d2727531e0334266671ec20d626b2104a2","chainId":"137","currencyAddress":"0xD1F9C58E33933A993A3891F8ACFE05A68E1AFC05"}`,
+		Nums:      `{"currencyValueRaw":"613620341167824900"}`,
+	}
+
+	// The trades of a transaction fetch its receipt once per run.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, internal.VerificationVerified, v.Verify(record).Status)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, []string{"eth_getTransactionReceipt"}, *calls)
+
+	v.Reset()
+	assert.Equal(t, internal.VerificationVerified, v.Verify(record).Status)
+	assert.Len(t, *calls, 2)
+}
@@ -0,0 +1,211 @@
+package evm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+// Getter reads the sales of marketplace contracts from the logs of an EVM
+// node. Every call to ReadDataFromFiles reads the blocks from the last
+// processed one up to the latest confirmed one, and Commit records the last
+// block read in a state file once its stats are stored.
+type Getter struct {
+	client        *Client
+	markets       map[string]Market
+	statePath     string
+	startBlock    uint64
+	confirmations uint64
+	blockRange    uint64
+	recordChannel chan internal.Record
+	endChannel    chan bool
+	goroutineNum  int
+	chainID       string
+	lastBlock     uint64
+	readBlock     uint64
+}
+
+type Option func(*Getter)
+
+// WithStartBlock sets the first block read when no block was processed yet.
+func WithStartBlock(block uint64) Option {
+	return func(g *Getter) {
+		g.startBlock = block
+	}
+}
+
+// WithConfirmations sets the number of blocks a block must be buried under
+// before it is read, so that reorganized blocks are not indexed.
+func WithConfirmations(confirmations uint64) Option {
+	return func(g *Getter) {
+		g.confirmations = confirmations
+	}
+}
+
+// WithBlockRange sets the maximum number of blocks of a single log query.
+func WithBlockRange(blockRange uint64) Option {
+	return func(g *Getter) {
+		if blockRange > 0 {
+			g.blockRange = blockRange
+		}
+	}
+}
+
+func New(client *Client, markets []Market, statePath string, gNum int, opts ...Option) *Getter {
+	g := &Getter{
+		client:        client,
+		markets:       make(map[string]Market, len(markets)),
+		statePath:     statePath,
+		confirmations: 12,
+		blockRange:    2000,
+		recordChannel: make(chan internal.Record, gNum*2),
+		endChannel:    make(chan bool),
+		goroutineNum:  gNum,
+	}
+	for _, market := range markets {
+		g.markets[strings.ToLower(market.Address)] = market
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+func (g *Getter) ReadDataFromFiles(ctx context.Context) error {
+	defer func() {
+		for i := 0; i < g.goroutineNum; i++ {
+			g.endChannel <- true
+		}
+	}()
+
+	if g.chainID == "" {
+		chainID, err := g.client.ChainID(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get chain id: %w", err)
+		}
+		g.chainID = chainID.String()
+		if g.lastBlock, err = g.loadState(); err != nil {
+			return err
+		}
+	}
+	// The blocks read by a run that failed, and was not committed, are read
+	// again.
+	g.readBlock = g.lastBlock
+
+	head, err := g.client.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get block number: %w", err)
+	}
+	if head < g.confirmations {
+		return nil
+	}
+	head -= g.confirmations
+
+	addresses := make([]string, 0, len(g.markets))
+	for address := range g.markets {
+		addresses = append(addresses, address)
+	}
+	for from := g.readBlock + 1; from <= head; from = g.readBlock + 1 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		to := min(from+g.blockRange-1, head)
+		if err := g.readBlocks(ctx, from, to, addresses); err != nil {
+			return fmt.Errorf("failed to read blocks %d to %d: %w", from, to, err)
+		}
+		g.readBlock = to
+	}
+	return nil
+}
+
+func (g *Getter) readBlocks(ctx context.Context, from, to uint64, addresses []string) error {
+	logs, err := g.client.FilterLogs(ctx, from, to, addresses, []string{tokensPurchaseTopic, tokensPurchaseV2Topic})
+	if err != nil {
+		return err
+	}
+	blockTimes := make(map[uint64]time.Time)
+	for _, log := range logs {
+		market, ok := g.markets[strings.ToLower(log.Address)]
+		if !ok || log.Removed {
+			continue
+		}
+		blockTime, ok := blockTimes[uint64(log.BlockNumber)]
+		if !ok {
+			if blockTime, err = g.client.BlockTime(ctx, uint64(log.BlockNumber)); err != nil {
+				return fmt.Errorf("failed to get block time: %w", err)
+			}
+			blockTimes[uint64(log.BlockNumber)] = blockTime
+		}
+		records, err := market.decodeSales(log, g.chainID, blockTime)
+		if err != nil {
+			slog.Error("failed to decode sale", "txnHash", log.TransactionHash, "logIndex", uint64(log.LogIndex), "err", err)
+			continue
+		}
+		for _, record := range records {
+			g.recordChannel <- record
+		}
+	}
+	return nil
+}
+
+// loadState returns the last processed block, or the block before the start
+// block when there is none.
+func (g *Getter) loadState() (uint64, error) {
+	last := uint64(0)
+	if g.startBlock > 0 {
+		last = g.startBlock - 1
+	}
+	if g.statePath == "" {
+		return last, nil
+	}
+	data, err := os.ReadFile(g.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return last, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read state: %w", err)
+	}
+	last, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid state: %w", err)
+	}
+	return last, nil
+}
+
+// Commit records the blocks read so far as processed.
+func (g *Getter) Commit() error {
+	if g.readBlock == g.lastBlock {
+		return nil
+	}
+	if g.statePath != "" {
+		tmp := g.statePath + ".tmp"
+		if err := os.WriteFile(tmp, []byte(strconv.FormatUint(g.readBlock, 10)+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write state: %w", err)
+		}
+		if err := os.Rename(tmp, g.statePath); err != nil {
+			return fmt.Errorf("failed to write state: %w", err)
+		}
+	}
+	g.lastBlock = g.readBlock
+	return nil
+}
+
+// LastBlock returns the last processed block.
+func (g *Getter) LastBlock() uint64 {
+	return g.lastBlock
+}
+
+func (g *Getter) Channel() chan internal.Record {
+	return g.recordChannel
+}
+
+func (g *Getter) EndChannel() chan bool {
+	return g.endChannel
+}
@@ -0,0 +1,138 @@
+package evm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+// TokensPurchase events of the Niftyswap exchanges, the AMM marketplace
+// contracts. The later versions add the extra fees to the event.
+var (
+	tokensPurchaseTopic   = eventTopic("TokensPurchase(address,address,uint256[],uint256[],uint256[])")
+	tokensPurchaseV2Topic = eventTopic("TokensPurchase(address,address,uint256[],uint256[],uint256[],address[],uint256[])")
+)
+
+// Market is a marketplace contract to index: a Niftyswap exchange trading the
+// tokens of a collection against a currency.
+type Market struct {
+	Address           string `json:"address"`
+	ProjectID         string `json:"projectId"`
+	CollectionAddress string `json:"collectionAddress"`
+	CurrencyAddress   string `json:"currencyAddress"`
+	CurrencySymbol    string `json:"currencySymbol"`
+	CurrencyDecimals  int    `json:"currencyDecimals"`
+}
+
+// LoadMarkets reads the markets of a JSON file holding a list of markets.
+func LoadMarkets(path string) ([]Market, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read markets: %w", err)
+	}
+	var markets []Market
+	if err := json.Unmarshal(data, &markets); err != nil {
+		return nil, fmt.Errorf("failed to decode markets: %w", err)
+	}
+	for i, market := range markets {
+		if market.Address == "" || market.ProjectID == "" {
+			return nil, fmt.Errorf("market %d: address and projectId are required", i)
+		}
+		markets[i].Address = strings.ToLower(market.Address)
+		if market.CurrencyDecimals == 0 {
+			markets[i].CurrencyDecimals = 18
+		}
+	}
+	return markets, nil
+}
+
+type saleProps struct {
+	TokenID           string `json:"tokenId"`
+	TxnHash           string `json:"txnHash"`
+	ChainID           string `json:"chainId"`
+	CollectionAddress string `json:"collectionAddress"`
+	CurrencyAddress   string `json:"currencyAddress"`
+	CurrencySymbol    string `json:"currencySymbol"`
+	MarketplaceType   string `json:"marketplaceType"`
+}
+
+type saleNums struct {
+	CurrencyValueDecimal string `json:"currencyValueDecimal"`
+	CurrencyValueRaw     string `json:"currencyValueRaw"`
+}
+
+// decodeSales decodes a TokensPurchase log into one BUY_ITEMS record per
+// token bought, in the form of the exported records.
+func (m Market) decodeSales(log Log, chainID string, blockTime time.Time) ([]internal.Record, error) {
+	if len(log.Topics) != 3 {
+		return nil, fmt.Errorf("unexpected %d topics", len(log.Topics))
+	}
+	data, err := decodeHex(log.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+	ids, err := decodeUintArray(data, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token ids: %w", err)
+	}
+	amounts, err := decodeUintArray(data, 1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token amounts: %w", err)
+	}
+	currencyAmounts, err := decodeUintArray(data, 2)
+	if err != nil {
+		return nil, fmt.Errorf("invalid currency amounts: %w", err)
+	}
+	if len(amounts) != len(ids) || len(currencyAmounts) != len(ids) {
+		return nil, fmt.Errorf("mismatched array lengths")
+	}
+
+	unit := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(m.CurrencyDecimals)), nil))
+	records := make([]internal.Record, 0, len(ids))
+	for i, id := range ids {
+		props, err := json.Marshal(saleProps{
+			TokenID:           id.String(),
+			TxnHash:           log.TransactionHash,
+			ChainID:           chainID,
+			CollectionAddress: m.CollectionAddress,
+			CurrencyAddress:   m.CurrencyAddress,
+			CurrencySymbol:    m.CurrencySymbol,
+			MarketplaceType:   "amm",
+		})
+		if err != nil {
+			return nil, err
+		}
+		value, _ := new(big.Rat).Quo(new(big.Rat).SetInt(currencyAmounts[i]), unit).Float64()
+		nums, err := json.Marshal(saleNums{
+			CurrencyValueDecimal: strconv.FormatFloat(value, 'f', -1, 64),
+			CurrencyValueRaw:     currencyAmounts[i].String(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, internal.Record{
+			Timestamp: blockTime.Format(time.DateTime + ".000"),
+			Event:     "BUY_ITEMS",
+			ProjectID: m.ProjectID,
+			Props:     string(props),
+			Nums:      string(nums),
+			Source:    fmt.Sprintf("evm://%s/%s#%d", chainID, log.TransactionHash, uint64(log.LogIndex)),
+			Columns: map[string]string{
+				"chain_id":         chainID,
+				"block_number":     strconv.FormatUint(uint64(log.BlockNumber), 10),
+				"log_index":        strconv.FormatUint(uint64(log.LogIndex), 10),
+				"exchange_address": m.Address,
+				"buyer":            topicAddress(log.Topics[1]),
+				"recipient":        topicAddress(log.Topics[2]),
+				"token_amount":     amounts[i].String(),
+			},
+		})
+	}
+	return records, nil
+}
@@ -0,0 +1,162 @@
+package evm
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+var transferTopic = eventTopic("Transfer(address,address,uint256)")
+
+// Verifier checks the transactions of records against their chain: the
+// transaction must have succeeded and transferred the reported amount of the
+// reported ERC20 currency. The receipts are cached until Reset, so that the
+// trades of a transaction fetch its receipt once.
+type Verifier struct {
+	clients  map[string]*Client
+	mutex    sync.Mutex
+	receipts map[string]*cachedReceipt
+}
+
+// cachedReceipt is a receipt fetched, or being fetched when done is open.
+type cachedReceipt struct {
+	done    chan struct{}
+	receipt *Receipt
+	err     error
+}
+
+// NewVerifier creates a verifier from the JSON-RPC endpoints of the chains,
+// keyed by chain id.
+func NewVerifier(urls map[string]string) *Verifier {
+	clients := make(map[string]*Client, len(urls))
+	for chainID, url := range urls {
+		clients[chainID] = NewClient(url)
+	}
+	return &Verifier{
+		clients:  clients,
+		receipts: make(map[string]*cachedReceipt),
+	}
+}
+
+// Reset empties the cache of the receipts, at the start of a run.
+func (v *Verifier) Reset() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.receipts = make(map[string]*cachedReceipt)
+}
+
+// receipt returns the receipt of the transaction of the chain, fetched once
+// by the records sharing it. Errors are not cached, the next record fetches
+// the receipt again.
+func (v *Verifier) receipt(client *Client, chainID, hash string) (*Receipt, error) {
+	key := chainID + "/" + strings.ToLower(hash)
+	v.mutex.Lock()
+	cached, ok := v.receipts[key]
+	if !ok {
+		cached = &cachedReceipt{done: make(chan struct{})}
+		v.receipts[key] = cached
+	}
+	v.mutex.Unlock()
+	if ok {
+		<-cached.done
+		return cached.receipt, cached.err
+	}
+
+	cached.receipt, cached.err = client.TransactionReceipt(context.Background(), hash)
+	if cached.err != nil {
+		v.mutex.Lock()
+		if v.receipts[key] == cached {
+			delete(v.receipts, key)
+		}
+		v.mutex.Unlock()
+	} else if cached.receipt != nil {
+		cached.receipt = transfersOnly(cached.receipt)
+	}
+	close(cached.done)
+	return cached.receipt, cached.err
+}
+
+// transfersOnly returns the receipt without the logs that are not ERC20
+// transfers, the only ones checked, to keep the cache small.
+func transfersOnly(receipt *Receipt) *Receipt {
+	trimmed := &Receipt{Status: receipt.Status}
+	for _, log := range receipt.Logs {
+		if len(log.Topics) == 3 && strings.EqualFold(log.Topics[0], transferTopic) {
+			trimmed.Logs = append(trimmed.Logs, log)
+		}
+	}
+	return trimmed
+}
+
+// Verify checks the transaction of a record. Records that cannot be checked,
+// for lack of a transaction hash, an endpoint or a receipt, are unverified.
+func (v *Verifier) Verify(record internal.Record) internal.Verification {
+	verification := internal.Verification{
+		ProjectID: record.ProjectID,
+		Status:    internal.VerificationUnverified,
+	}
+	verification.Date, _ = time.Parse(time.DateTime+".000", record.Timestamp)
+	verification.ChainID, _ = record.Field("props.chainId")
+	verification.TxnHash, _ = record.Field("props.txnHash")
+	verification.CurrencyAddress, _ = record.Field("props.currencyAddress")
+	verification.CurrencyValueRaw, _ = record.Field("nums.currencyValueRaw")
+
+	if verification.TxnHash == "" {
+		verification.Reason = "no transaction hash"
+		return verification
+	}
+	client, ok := v.clients[verification.ChainID]
+	if !ok {
+		verification.Reason = fmt.Sprintf("no endpoint for chain %q", verification.ChainID)
+		return verification
+	}
+	receipt, err := v.receipt(client, verification.ChainID, verification.TxnHash)
+	if err != nil {
+		verification.Reason = err.Error()
+		return verification
+	}
+	if receipt == nil {
+		verification.Reason = "transaction not found"
+		return verification
+	}
+
+	verification.Status, verification.Reason = checkReceipt(receipt, verification.CurrencyAddress, verification.CurrencyValueRaw)
+	return verification
+}
+
+// checkReceipt looks for the ERC20 transfer of a receipt matching the currency
+// and the raw amount of a record.
+func checkReceipt(receipt *Receipt, currencyAddress, valueRaw string) (string, string) {
+	if receipt.Status != 1 {
+		return internal.VerificationMismatched, "transaction failed"
+	}
+	expected, ok := new(big.Int).SetString(valueRaw, 10)
+	if !ok {
+		return internal.VerificationUnverified, fmt.Sprintf("invalid currency value %q", valueRaw)
+	}
+	currencyFound := false
+	for _, log := range receipt.Logs {
+		// ERC20 transfers have three topics, ERC721 ones a fourth one for the
+		// token id.
+		if len(log.Topics) != 3 || !strings.EqualFold(log.Topics[0], transferTopic) || !strings.EqualFold(log.Address, currencyAddress) {
+			continue
+		}
+		currencyFound = true
+		data, err := decodeHex(log.Data)
+		if err != nil || len(data) != 32 {
+			continue
+		}
+		if new(big.Int).SetBytes(data).Cmp(expected) == 0 {
+			return internal.VerificationVerified, ""
+		}
+	}
+	if currencyFound {
+		return internal.VerificationMismatched, "no transfer of the reported amount"
+	}
+	return internal.VerificationMismatched, "no transfer of the reported currency"
+}
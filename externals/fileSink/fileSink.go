@@ -0,0 +1,194 @@
+// Package fileSink writes market stats to files, for ad-hoc analysis and for
+// feeding other tools.
+package fileSink
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/parquet"
+)
+
+// Formats of the files written.
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+var columns = []parquet.Column{
+	{Name: "date", Type: parquet.Date},
+	{Name: "project_id", Type: parquet.Uint64},
+	{Name: "num_transactions", Type: parquet.Uint64},
+	{Name: "total_volume_usd", Type: parquet.Double},
+	{Name: "dimensions", Type: parquet.String},
+}
+
+// Sink writes every batch of stats it is given to a new file of a directory,
+// named after the time it was written.
+type Sink struct {
+	dir    string
+	format string
+	mutex  sync.Mutex
+	last   string
+	seq    int
+}
+
+// New creates a sink writing files of the given format to dir, created when
+// missing.
+func New(dir, format string) (*Sink, error) {
+	switch format {
+	case FormatCSV, FormatJSONL, FormatParquet:
+	default:
+		return nil, fmt.Errorf("unknown sink format %q", format)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create sink directory: %w", err)
+	}
+	return &Sink{
+		dir:    dir,
+		format: format,
+	}, nil
+}
+
+// InsertMarket writes the stats to a new file. The file is written under a
+// temporary name first, so that readers never see it partially written.
+func (s *Sink) InsertMarket(stats map[string]internal.MarketStat) error {
+	path := filepath.Join(s.dir, s.fileName())
+	file, err := os.CreateTemp(s.dir, ".market_stats-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if err := s.write(file, sortedStats(stats)); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// fileName returns a name sorting after the ones of the earlier files.
+func (s *Sink) fileName() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stamp := time.Now().UTC().Format("20060102T150405.000")
+	if stamp == s.last {
+		s.seq++
+	} else {
+		s.last, s.seq = stamp, 0
+	}
+	return fmt.Sprintf("market_stats-%s-%03d.%s", stamp, s.seq, s.format)
+}
+
+func sortedStats(stats map[string]internal.MarketStat) []internal.MarketStat {
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sorted := make([]internal.MarketStat, len(keys))
+	for i, key := range keys {
+		sorted[i] = stats[key]
+	}
+	return sorted
+}
+
+type jsonStat struct {
+	Date           string            `json:"date"`
+	ProjectID      uint64            `json:"project_id"`
+	NumTx          uint64            `json:"num_transactions"`
+	TotalVolumeUSD float64           `json:"total_volume_usd"`
+	Dimensions     map[string]string `json:"dimensions"`
+}
+
+func (s *Sink) write(w io.Writer, stats []internal.MarketStat) error {
+	switch s.format {
+	case FormatJSONL:
+		encoder := json.NewEncoder(w)
+		for _, stat := range stats {
+			dimensions := stat.Dimensions
+			if dimensions == nil {
+				dimensions = map[string]string{}
+			}
+			err := encoder.Encode(jsonStat{
+				Date:           stat.Date.Format(time.DateOnly),
+				ProjectID:      stat.ProjectID,
+				NumTx:          stat.NumTx,
+				TotalVolumeUSD: stat.TotalVolume,
+				Dimensions:     dimensions,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatParquet:
+		writer := parquet.NewWriter(w, columns)
+		for _, stat := range stats {
+			row, err := statRow(stat)
+			if err != nil {
+				return err
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+		return writer.Close()
+	}
+
+	writer := csv.NewWriter(w)
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.Name
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, stat := range stats {
+		row, err := statRow(stat)
+		if err != nil {
+			return err
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// statRow returns the values of a stat, its dimensions encoded as a JSON
+// object.
+func statRow(stat internal.MarketStat) ([]string, error) {
+	dimensions := stat.Dimensions
+	if dimensions == nil {
+		dimensions = map[string]string{}
+	}
+	encoded, err := json.Marshal(dimensions)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		stat.Date.Format(time.DateOnly),
+		strconv.FormatUint(stat.ProjectID, 10),
+		strconv.FormatUint(stat.NumTx, 10),
+		strconv.FormatFloat(stat.TotalVolume, 'f', -1, 64),
+		string(encoded),
+	}, nil
+}
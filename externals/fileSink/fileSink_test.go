@@ -0,0 +1,96 @@
+package fileSink
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/parquet"
+	"github.com/stretchr/testify/assert"
+)
+
+var testStats = map[string]internal.MarketStat{
+	"01-01-2024-1234-country=US": {Date: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), ProjectID: 1234, NumTx: 1, TotalVolume: 5, Dimensions: map[string]string{"country": "US"}},
+	"01-01-2024-1234-country=FR": {Date: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), ProjectID: 1234, NumTx: 2, TotalVolume: 10.5, Dimensions: map[string]string{"country": "FR"}},
+}
+
+var expectedRows = [][]string{
+	{"2024-01-01", "1234", "2", "10.5", `{"country":"FR"}`},
+	{"2024-01-01", "1234", "1", "5", `{"country":"US"}`},
+}
+
+// writeStats writes the test stats twice and returns the files written.
+func writeStats(t *testing.T, format string) []string {
+	dir := filepath.Join(t.TempDir(), "exports")
+	sink, err := New(dir, format)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.InsertMarket(testStats))
+	assert.NoError(t, sink.InsertMarket(testStats))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	for _, file := range files {
+		assert.Equal(t, "."+format, filepath.Ext(file))
+	}
+	return files
+}
+
+func TestSinkCSV(t *testing.T) {
+	for _, path := range writeStats(t, FormatCSV) {
+		file, err := os.Open(path)
+		assert.NoError(t, err)
+		rows, err := csv.NewReader(file).ReadAll()
+		file.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, append([][]string{{"date", "project_id", "num_transactions", "total_volume_usd", "dimensions"}}, expectedRows...), rows)
+	}
+}
+
+func TestSinkJSONL(t *testing.T) {
+	for _, path := range writeStats(t, FormatJSONL) {
+		file, err := os.Open(path)
+		assert.NoError(t, err)
+		var stats []jsonStat
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var stat jsonStat
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &stat))
+			stats = append(stats, stat)
+		}
+		file.Close()
+		assert.Equal(t, []jsonStat{
+			{Date: "2024-01-01", ProjectID: 1234, NumTx: 2, TotalVolumeUSD: 10.5, Dimensions: map[string]string{"country": "FR"}},
+			{Date: "2024-01-01", ProjectID: 1234, NumTx: 1, TotalVolumeUSD: 5, Dimensions: map[string]string{"country": "US"}},
+		}, stats)
+	}
+}
+
+func TestSinkParquet(t *testing.T) {
+	for _, path := range writeStats(t, FormatParquet) {
+		file, err := os.Open(path)
+		assert.NoError(t, err)
+		info, err := file.Stat()
+		assert.NoError(t, err)
+		reader, err := parquet.NewReader(file, info.Size())
+		assert.NoError(t, err)
+		var rows [][]string
+		for range expectedRows {
+			row, err := reader.Read()
+			assert.NoError(t, err)
+			rows = append(rows, row)
+		}
+		file.Close()
+		assert.Equal(t, expectedRows, rows)
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(t.TempDir(), "xml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,372 @@
+// Package httpReceiver receives the records pushed over HTTP by partner
+// backends.
+package httpReceiver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals/dataGetter"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+// Receiver is an HTTP handler accepting batches of records, as a JSON array
+// or as JSON Lines, keyed like the columns of the files. Every call to
+// ReadDataFromFiles reads one batch, bounded by a size and a timeout: the
+// requests received meanwhile send their records to the pipeline, and are
+// answered once the batch is committed, i.e. stored, or aborted. Requests
+// received between two batches wait for the next one.
+type Receiver struct {
+	ctx           context.Context
+	schema        dataGetter.Schema
+	keys          map[string]string
+	maxBodySize   int64
+	batchSize     int
+	batchTimeout  time.Duration
+	recordChannel chan internal.Record
+	endChannel    chan bool
+	goroutineNum  int
+
+	// mutex guards open, opened, closed when a batch opens, and batch.
+	mutex  sync.Mutex
+	open   bool
+	opened chan struct{}
+	// batch is the batch read last, until it is committed or aborted.
+	batch *batch
+	// aborted is closed once a batch is aborted, stopping the receiver.
+	aborted  chan struct{}
+	abort    sync.Once
+	inflight sync.WaitGroup
+	received atomic.Int64
+	full     chan struct{}
+}
+
+// request counts the records of a request rejected by the pipeline.
+type request struct {
+	rejected atomic.Int64
+}
+
+// batch is the outcome of a batch, awaited by its requests.
+type batch struct {
+	// done is closed once the batch is committed or aborted.
+	done chan struct{}
+	err  error
+}
+
+type Option func(*Receiver)
+
+// WithSchema sets the mapping from record fields to the keys of the records.
+func WithSchema(schema dataGetter.Schema) Option {
+	return func(r *Receiver) {
+		r.schema = schema
+	}
+}
+
+// WithBatch bounds the records read by a single batch.
+func WithBatch(size int, timeout time.Duration) Option {
+	return func(r *Receiver) {
+		if size > 0 {
+			r.batchSize = size
+		}
+		if timeout > 0 {
+			r.batchTimeout = timeout
+		}
+	}
+}
+
+// WithMaxBodySize bounds the size of a request body, in bytes.
+func WithMaxBodySize(size int64) Option {
+	return func(r *Receiver) {
+		if size > 0 {
+			r.maxBodySize = size
+		}
+	}
+}
+
+// WithContext stops reading when ctx is done. The batch read so far is ended
+// as usual, so that it can still be stored.
+func WithContext(ctx context.Context) Option {
+	return func(r *Receiver) {
+		r.ctx = ctx
+	}
+}
+
+// New creates a receiver accepting the requests authenticated by one of the
+// keys, a map from API key to client name.
+func New(keys map[string]string, gNum int, opts ...Option) *Receiver {
+	r := &Receiver{
+		ctx:           context.Background(),
+		schema:        dataGetter.DefaultSchema(),
+		keys:          keys,
+		maxBodySize:   10 << 20,
+		batchSize:     1000,
+		batchTimeout:  10 * time.Second,
+		recordChannel: make(chan internal.Record, gNum*2),
+		endChannel:    make(chan bool),
+		goroutineNum:  gNum,
+		opened:        make(chan struct{}),
+		aborted:       make(chan struct{}),
+		full:          make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// ReadDataFromFiles reads the next batch of records, until the context of the
+// receiver or ctx is done.
+func (r *Receiver) ReadDataFromFiles(ctx context.Context) error {
+	r.received.Store(0)
+	select {
+	case <-r.full:
+	default:
+	}
+	// A batch read again before being committed was not stored.
+	r.end(errors.New("batch not stored"))
+	r.mutex.Lock()
+	r.open = true
+	r.batch = &batch{done: make(chan struct{})}
+	close(r.opened)
+	r.mutex.Unlock()
+
+	timer := time.NewTimer(r.batchTimeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.full:
+	case <-r.ctx.Done():
+	case <-ctx.Done():
+	}
+
+	r.mutex.Lock()
+	r.open = false
+	r.opened = make(chan struct{})
+	r.mutex.Unlock()
+	// The records of the requests accepted in the batch are all sent before
+	// its end.
+	r.inflight.Wait()
+	for i := 0; i < r.goroutineNum; i++ {
+		r.endChannel <- true
+	}
+	return nil
+}
+
+// Commit answers the requests of the batch read last, once stored.
+func (r *Receiver) Commit() error {
+	r.end(nil)
+	return nil
+}
+
+// Abort answers the requests of the batch read last, whose store failed with
+// err, with a 503: their clients are to send them again. The receiver is
+// stopped, and the requests waiting for the next batch are answered with a
+// 503 as well.
+func (r *Receiver) Abort(err error) {
+	r.abort.Do(func() { close(r.aborted) })
+	r.end(err)
+}
+
+// end ends the batch read last with err, nil when it is stored.
+func (r *Receiver) end(err error) {
+	r.mutex.Lock()
+	b := r.batch
+	r.batch = nil
+	r.mutex.Unlock()
+	if b != nil {
+		b.err = err
+		close(b.done)
+	}
+}
+
+// Done reports whether the receiver context is done, or a batch was aborted,
+// i.e. no more batch should be read.
+func (r *Receiver) Done() bool {
+	select {
+	case <-r.aborted:
+		return true
+	default:
+		return r.ctx.Err() != nil
+	}
+}
+
+func (r *Receiver) Channel() chan internal.Record {
+	return r.recordChannel
+}
+
+func (r *Receiver) EndChannel() chan bool {
+	return r.endChannel
+}
+
+// ServeHTTP stores a batch of records. The records are all validated before
+// any is sent to the pipeline: a request is stored or rejected as a whole.
+// It is answered once its batch is stored, with a 200 and the number of its
+// records stored and dropped by the pipeline, e.g. when they cannot be priced,
+// or failed to be, with a 503.
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	client, ok := r.authenticate(req)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid api key"))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, r.maxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("body larger than %d bytes", r.maxBodySize))
+			return
+		}
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to read body: %w", err))
+		return
+	}
+	origin := &request{}
+	records, err := r.decode(body, "http://"+client, origin)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	b, err := r.enter(req.Context())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	for _, record := range records {
+		r.recordChannel <- record
+	}
+	if r.received.Add(int64(len(records))) >= int64(r.batchSize) {
+		select {
+		case r.full <- struct{}{}:
+		default:
+		}
+	}
+	r.inflight.Done()
+
+	select {
+	case <-b.done:
+	case <-req.Context().Done():
+		// The client is gone: its records are stored or not all the same.
+		return
+	}
+	if b.err != nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("failed to store the records: %w", b.err))
+		return
+	}
+	dropped := int(origin.rejected.Load())
+	slog.Info("records stored", "client", client, "records", len(records)-dropped, "dropped", dropped)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int{"stored": len(records) - dropped, "dropped": dropped})
+}
+
+// Reject counts a record the pipeline failed to aggregate against the request
+// it was received in.
+func (r *Receiver) Reject(record internal.Record, _ error) {
+	if origin, ok := record.Origin.(*request); ok {
+		origin.rejected.Add(1)
+	}
+}
+
+// authenticate returns the client of the API key of a request, given as a
+// bearer token.
+func (r *Receiver) authenticate(req *http.Request) (string, bool) {
+	key, ok := strings.CutPrefix(req.Header.Get("authorization"), "Bearer ")
+	if !ok || key == "" {
+		return "", false
+	}
+	for candidate, client := range r.keys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			return client, true
+		}
+	}
+	return "", false
+}
+
+// enter waits for a batch to be open, counts the request in it, and returns
+// it.
+func (r *Receiver) enter(ctx context.Context) (*batch, error) {
+	for {
+		r.mutex.Lock()
+		if r.open {
+			r.inflight.Add(1)
+			b := r.batch
+			r.mutex.Unlock()
+			return b, nil
+		}
+		opened := r.opened
+		r.mutex.Unlock()
+
+		select {
+		case <-opened:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-r.ctx.Done():
+			return nil, errors.New("receiver stopped")
+		case <-r.aborted:
+			return nil, errors.New("receiver stopped")
+		}
+	}
+}
+
+// decode reads the records of a body, a JSON array of objects or one object
+// per line, and validates them.
+func (r *Receiver) decode(body []byte, source string, origin *request) ([]internal.Record, error) {
+	var rows []json.RawMessage
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &rows); err != nil {
+			return nil, fmt.Errorf("invalid json array: %w", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(nil, len(body)+1)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				rows = append(rows, json.RawMessage(line))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read lines: %w", err)
+		}
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no records")
+	}
+
+	records := make([]internal.Record, len(rows))
+	for i, data := range rows {
+		row, err := dataGetter.DecodeJSONRow(data)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: invalid json object: %w", i+1, err)
+		}
+		record, err := r.schema.Record(row)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+		if _, err := internal.ParseRecord(record); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+		record.Source = source
+		record.Origin = origin
+		records[i] = record
+	}
+	return records, nil
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
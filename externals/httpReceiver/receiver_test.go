@@ -0,0 +1,182 @@
+package httpReceiver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+var testKeys = map[string]string{"secret-key": "partner"}
+
+func readBatch(t *testing.T, r *Receiver) []internal.Record {
+	var records []internal.Record
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case record := <-r.Channel():
+				records = append(records, record)
+			case <-r.EndChannel():
+				for len(r.Channel()) > 0 {
+					records = append(records, <-r.Channel())
+				}
+				return
+			}
+		}
+	}()
+	assert.NoError(t, r.ReadDataFromFiles(context.Background()))
+	<-done
+	return records
+}
+
+// post sends a request to the receiver in the background, and returns the
+// channel of its response.
+func post(r *Receiver, key, body string) chan *httptest.ResponseRecorder {
+	responses := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/records", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		responses <- rec
+	}()
+	return responses
+}
+
+func TestReceiver(t *testing.T) {
+	r := New(testKeys, 1, WithBatch(3, time.Second))
+
+	// Requests wait for a batch to be read.
+	array := post(r, "secret-key", `[
+		{"ts":"2024-04-15 02:15:07.167","event":"BUY_ITEMS","project_id":4974,"props":{"currencySymbol":"SFL"},"nums":{"currencyValueDecimal":"0.61"},"country":"DE"},
+		{"ts":"2024-04-15 02:26:37.134","event":"BUY_ITEMS","project_id":"4974","props":"{\"currencySymbol\":\"SFL\"}","nums":"{\"currencyValueDecimal\":\"2.36\"}"}
+	]`)
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, array)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		<-post(r, "secret-key", `{"ts":"2024-04-15 02:42:32.507","event":"BUY_ITEMS","project_id":1609,"props":{"currencySymbol":"MATIC"},"nums":{"currencyValueDecimal":"0.36"}}`+"\n")
+	}()
+
+	start := time.Now()
+	records := readBatch(t, r)
+	assert.Less(t, time.Since(start), time.Second, "the batch ends once full")
+	assert.Len(t, records, 3)
+	// The records of a request share its origin.
+	assert.Same(t, records[0].Origin, records[1].Origin)
+	assert.NotSame(t, records[0].Origin, records[2].Origin)
+	records[0].Origin = nil
+	assert.Equal(t, internal.Record{
+		Timestamp: "2024-04-15 02:15:07.167",
+		Event:     "BUY_ITEMS",
+		ProjectID: "4974",
+		Props:     `{"currencySymbol":"SFL"}`,
+		Nums:      `{"currencyValueDecimal":"0.61"}`,
+		Source:    "http://partner",
+		Columns: map[string]string{
+			"ts":         "2024-04-15 02:15:07.167",
+			"event":      "BUY_ITEMS",
+			"project_id": "4974",
+			"props":      `{"currencySymbol":"SFL"}`,
+			"nums":       `{"currencyValueDecimal":"0.61"}`,
+			"country":    "DE",
+		},
+	}, records[0])
+	assert.Equal(t, "1609", records[2].ProjectID)
+
+	// Requests are answered once their batch is stored, with the records the
+	// pipeline dropped.
+	r.Reject(records[1], errors.New("failed to get price"))
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, array)
+	assert.NoError(t, r.Commit())
+	rec := <-array
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"stored":1,"dropped":1}`, rec.Body.String())
+}
+
+func TestReceiverAbort(t *testing.T) {
+	r := New(testKeys, 1, WithBatch(1, 200*time.Millisecond))
+	body := `{"ts":"2024-04-15 02:15:07.167","project_id":1,"props":{"currencySymbol":"SFL"},"nums":{"currencyValueDecimal":"1"}}`
+
+	// A batch read again without being committed was not stored.
+	first := post(r, "secret-key", body)
+	assert.Len(t, readBatch(t, r), 1)
+	second := post(r, "secret-key", body)
+	assert.Len(t, readBatch(t, r), 1)
+	rec := <-first
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"error":"failed to store the records: batch not stored"}`, rec.Body.String())
+
+	r.Abort(errors.New("connection refused"))
+	rec = <-second
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"error":"failed to store the records: connection refused"}`, rec.Body.String())
+
+	// The receiver is stopped.
+	assert.True(t, r.Done())
+	rec = <-post(r, "secret-key", body)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"error":"receiver stopped"}`, rec.Body.String())
+}
+
+func TestReceiverRejects(t *testing.T) {
+	valid := `{"ts":"2024-04-15 02:15:07.167","project_id":4974,"props":{"currencySymbol":"SFL"},"nums":{"currencyValueDecimal":"0.61"}}`
+	testCases := []struct {
+		name       string
+		key        string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "missing key", body: valid, wantStatus: http.StatusUnauthorized, wantError: "invalid api key"},
+		{name: "unknown key", key: "other-key", body: valid, wantStatus: http.StatusUnauthorized, wantError: "invalid api key"},
+		{name: "too large", key: "secret-key", body: "[" + strings.Repeat(valid+",", 20) + valid + "]", wantStatus: http.StatusRequestEntityTooLarge, wantError: "body larger than 1024 bytes"},
+		{name: "empty", key: "secret-key", body: " \n", wantStatus: http.StatusBadRequest, wantError: "no records"},
+		{name: "invalid json", key: "secret-key", body: valid + "\n{", wantStatus: http.StatusBadRequest, wantError: "record 2: invalid json object: unexpected EOF"},
+		{name: "missing column", key: "secret-key", body: `[{"ts":"2024-04-15 02:15:07.167","project_id":4974,"props":{}}]`, wantStatus: http.StatusBadRequest, wantError: `record 1: missing column "nums" for field "nums"`},
+		{name: "invalid ts", key: "secret-key", body: strings.Replace(valid, "2024-04-15 02:15:07.167", "15-04-2024", 1), wantStatus: http.StatusBadRequest, wantError: `record 1: invalid ts "15-04-2024", expected YYYY-MM-DD hh:mm:ss.sss`},
+		{name: "invalid project", key: "secret-key", body: strings.Replace(valid, "4974", `"abc"`, 1), wantStatus: http.StatusBadRequest, wantError: `record 1: invalid project_id "abc"`},
+		{name: "missing currency", key: "secret-key", body: strings.Replace(valid, `"currencySymbol":"SFL"`, `"currency":"SFL"`, 1), wantStatus: http.StatusBadRequest, wantError: "record 1: missing props.currencySymbol"},
+		{name: "invalid amount", key: "secret-key", body: strings.Replace(valid, `"0.61"`, `"a lot"`, 1), wantStatus: http.StatusBadRequest, wantError: `record 1: invalid nums.currencyValueDecimal "a lot"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(testKeys, 1, WithMaxBodySize(1024))
+			rec := <-post(r, tc.key, tc.body)
+			assert.Equal(t, tc.wantStatus, rec.Code)
+			assert.JSONEq(t, `{"error":`+strconv.Quote(tc.wantError)+`}`, rec.Body.String())
+		})
+	}
+}
+
+func TestReceiverContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := New(testKeys, 1, WithBatch(10, time.Minute), WithContext(ctx))
+
+	first := post(r, "secret-key", `{"ts":"2024-04-15 02:15:07.167","project_id":1,"props":{"currencySymbol":"SFL"},"nums":{"currencyValueDecimal":"1"}}`)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	records := readBatch(t, r)
+	assert.Len(t, records, 1, "the batch read before cancellation is kept")
+	assert.True(t, r.Done())
+	assert.NoError(t, r.Commit())
+	assert.Equal(t, http.StatusOK, (<-first).Code)
+
+	rec := <-post(r, "secret-key", `{"ts":"2024-04-15 02:15:07.167","project_id":1,"props":{"currencySymbol":"SFL"},"nums":{"currencyValueDecimal":"1"}}`)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
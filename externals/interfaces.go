@@ -1,9 +1,16 @@
 package externals
 
-import "github.com/lat1992/blockchain-data-aggregator/internal"
+import (
+	"context"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
 
 type DataGetterService interface {
-	ReadDataFromFiles() error
+	// ReadDataFromFiles reads the records, traced as children of the span
+	// of ctx.
+	ReadDataFromFiles(ctx context.Context) error
 	Channel() chan internal.Record
 	EndChannel() chan bool
 }
@@ -11,9 +18,127 @@ type DataGetterService interface {
 type CoinGeckoAPI interface {
 	InitTokenIDs() error
 	GetTokenID(token string) string
-	GetPrice(symbol, date string) (float64, error)
+	GetPrice(ctx context.Context, symbol, date string) (float64, error)
 }
 
 type Database interface {
 	InsertMarket(stats map[string]internal.MarketStat) error
 }
+
+// TransactionDatabase is implemented by the databases that store every priced
+// record and derive the market stats from them.
+type TransactionDatabase interface {
+	InsertTransactions(transactions []internal.Transaction) error
+}
+
+// VerificationDatabase is implemented by the databases that store the
+// on-chain verification of the records.
+type VerificationDatabase interface {
+	InsertVerifications(verifications []internal.Verification) error
+}
+
+// RunDatabase is implemented by the databases that keep the history of the
+// pipeline runs.
+type RunDatabase interface {
+	InsertRun(run internal.PipelineRun) error
+}
+
+// BackfillDatabase is implemented by the databases whose stats of a range of
+// days can be recomputed and swapped in at once.
+type BackfillDatabase interface {
+	// Backfill returns the database staging the stats recomputed for the
+	// range, none of them visible until committed.
+	Backfill(r internal.BackfillRange) (Backfill, error)
+}
+
+// Backfill stages the stats recomputed for a range of days.
+type Backfill interface {
+	Database
+	// Commit replaces the stored stats of the range with the staged ones.
+	Commit() error
+	// Rollback drops the staged stats.
+	Rollback() error
+}
+
+// RepriceDatabase is implemented by the databases that keep the native amounts
+// of the trades, whose USD volumes can be recomputed at other prices.
+type RepriceDatabase interface {
+	// CurrencyVolumes returns the volumes of the currencies traded over the
+	// days from to to, by day and currency.
+	CurrencyVolumes(ctx context.Context, from, to time.Time) ([]internal.CurrencyVolume, error)
+	// Reprice rewrites the USD volumes of the currencies over the days of the
+	// prices, at these prices.
+	Reprice(ctx context.Context, prices []internal.Price) error
+}
+
+// Verifier checks a record against the chain of its transaction.
+type Verifier interface {
+	Verify(record internal.Record) internal.Verification
+}
+
+// Resetter is implemented by the services caching data for the duration of a
+// pipeline run, reset at its start.
+type Resetter interface {
+	Reset()
+}
+
+// SeenStore remembers the transactions aggregated by earlier runs, so that
+// they are not counted again. Seen returns the keys among keys of the
+// transactions already stored.
+type SeenStore interface {
+	Seen(keys []string) (map[string]bool, error)
+}
+
+// SeenMarker is implemented by the seen stores recording the transactions
+// apart from their stats, marked once the stats are stored.
+type SeenMarker interface {
+	MarkSeen(keys []string) error
+}
+
+// MarketSeenInserter is implemented by the seen stores that also store the
+// stats, and can mark their trades seen in the same transaction, so that a
+// failure in between does not count the trades again on the next read.
+type MarketSeenInserter interface {
+	InsertMarketSeen(stats map[string]internal.MarketStat, keys []string) error
+}
+
+// RunLocker is implemented by the databases whose stored stats are rewritten
+// by maintenance, e.g. a backfill, that must not overlap a pipeline run.
+// LockRun waits for the maintenance, and holds it off until the returned
+// function is called.
+type RunLocker interface {
+	LockRun(ctx context.Context) (func() error, error)
+}
+
+// Committer is implemented by the data getters that need to know when the
+// data they have read is durably stored, e.g. to track what was processed.
+type Committer interface {
+	Commit() error
+}
+
+// Rejecter is implemented by the data getters that report the records the
+// pipeline fails to price or aggregate, e.g. to the clients that sent them.
+// Reject is called before the records are committed.
+type Rejecter interface {
+	Reject(record internal.Record, err error)
+}
+
+// Publisher sends records to the services that index them.
+type Publisher interface {
+	Publish(records []internal.Record) error
+}
+
+// StatsReader reads the stored stats for the query API. Results are ordered,
+// and paginated by the limit and offset of the query.
+type StatsReader interface {
+	// ProjectStats returns the stats of a project by period.
+	ProjectStats(ctx context.Context, query internal.StatsQuery) ([]internal.PeriodStat, error)
+	// TopProjects returns the projects with the largest volume first.
+	TopProjects(ctx context.Context, query internal.StatsQuery) ([]internal.ProjectStat, error)
+	// CurrencyStats returns the stats of a project by currency, the largest
+	// volume first.
+	CurrencyStats(ctx context.Context, query internal.StatsQuery) ([]internal.CurrencyStat, error)
+	// MarketStats returns the stats by date, project and dimensions, of
+	// every project when the project id of the query is 0.
+	MarketStats(ctx context.Context, query internal.StatsQuery) ([]internal.MarketStat, error)
+}
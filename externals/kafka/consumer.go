@@ -0,0 +1,174 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals/dataGetter"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// messageReader is the part of kafka-go's Reader used by the consumer.
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafkago.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafkago.Message) error
+	Close() error
+}
+
+// Consumer reads transaction events from a Kafka topic. Every call to
+// ReadDataFromFiles reads one batch, bounded by a size and a timeout, and
+// the offsets of the batch are committed by Commit, once its stats are stored.
+type Consumer struct {
+	reader        messageReader
+	ctx           context.Context
+	schema        dataGetter.Schema
+	batchSize     int
+	batchTimeout  time.Duration
+	recordChannel chan internal.Record
+	endChannel    chan bool
+	goroutineNum  int
+	pending       []kafkago.Message
+}
+
+type Option func(*Consumer)
+
+// WithSchema sets the mapping from record fields to the keys of the events.
+func WithSchema(schema dataGetter.Schema) Option {
+	return func(c *Consumer) {
+		c.schema = schema
+	}
+}
+
+// WithBatch bounds the events read by a single batch.
+func WithBatch(size int, timeout time.Duration) Option {
+	return func(c *Consumer) {
+		if size > 0 {
+			c.batchSize = size
+		}
+		if timeout > 0 {
+			c.batchTimeout = timeout
+		}
+	}
+}
+
+// WithContext stops reading when ctx is done. The batch read so far is ended
+// as usual, so that it can still be stored and committed.
+func WithContext(ctx context.Context) Option {
+	return func(c *Consumer) {
+		c.ctx = ctx
+	}
+}
+
+func New(brokers []string, topic, groupID string, gNum int, opts ...Option) *Consumer {
+	reader := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers: brokers,
+		Topic:   topic,
+		GroupID: groupID,
+	})
+	return newConsumer(reader, gNum, opts...)
+}
+
+func newConsumer(reader messageReader, gNum int, opts ...Option) *Consumer {
+	c := &Consumer{
+		reader:        reader,
+		ctx:           context.Background(),
+		schema:        dataGetter.DefaultSchema(),
+		batchSize:     1000,
+		batchTimeout:  10 * time.Second,
+		recordChannel: make(chan internal.Record, gNum*2),
+		endChannel:    make(chan bool),
+		goroutineNum:  gNum,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ReadDataFromFiles reads the next batch of events, until the context of the
+// consumer or runCtx is done.
+func (c *Consumer) ReadDataFromFiles(runCtx context.Context) error {
+	defer func() {
+		for i := 0; i < c.goroutineNum; i++ {
+			c.endChannel <- true
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.batchTimeout)
+	defer cancel()
+	stop := context.AfterFunc(runCtx, cancel)
+	defer stop()
+
+	for len(c.pending) < c.batchSize {
+		msg, err := c.reader.FetchMessage(ctx)
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch message: %w", err)
+		}
+		c.pending = append(c.pending, msg)
+
+		record, err := c.decode(msg)
+		if err != nil {
+			// The event can never be read: it is committed with the batch
+			// rather than blocking the partition.
+			slog.Error("failed to decode message", "topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset, "err", err)
+			continue
+		}
+		c.recordChannel <- record
+	}
+	return nil
+}
+
+// decode reads the record of a message. Its source is the one published by
+// the reader, else the message itself.
+func (c *Consumer) decode(msg kafkago.Message) (internal.Record, error) {
+	row, err := dataGetter.DecodeJSONRow(msg.Value)
+	if err != nil {
+		return internal.Record{}, err
+	}
+	record, err := c.schema.Record(row)
+	if err != nil {
+		return internal.Record{}, err
+	}
+	record.Source = row["source"]
+	if record.Source == "" {
+		record.Source = fmt.Sprintf("kafka://%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+	}
+	return record, nil
+}
+
+// Commit commits the offsets of the batch read.
+func (c *Consumer) Commit() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+	if err := c.reader.CommitMessages(context.Background(), c.pending...); err != nil {
+		return fmt.Errorf("failed to commit messages: %w", err)
+	}
+	c.pending = nil
+	return nil
+}
+
+// Done reports whether the consumer context is done, i.e. no more batch
+// should be read.
+func (c *Consumer) Done() bool {
+	return c.ctx.Err() != nil
+}
+
+func (c *Consumer) Close() error {
+	return c.reader.Close()
+}
+
+func (c *Consumer) Channel() chan internal.Record {
+	return c.recordChannel
+}
+
+func (c *Consumer) EndChannel() chan bool {
+	return c.endChannel
+}
@@ -0,0 +1,202 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals/dataGetter"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeReader is an in-memory partition: fetching moves a read position and
+// committing moves the committed offset, as a consumer group does.
+type fakeReader struct {
+	mutex     sync.Mutex
+	messages  []kafkago.Message
+	position  int
+	committed int
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafkago.Message, error) {
+	r.mutex.Lock()
+	if r.position < len(r.messages) {
+		msg := r.messages[r.position]
+		r.position++
+		r.mutex.Unlock()
+		return msg, nil
+	}
+	r.mutex.Unlock()
+	<-ctx.Done()
+	return kafkago.Message{}, ctx.Err()
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafkago.Message) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, msg := range msgs {
+		if int(msg.Offset)+1 > r.committed {
+			r.committed = int(msg.Offset) + 1
+		}
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	return nil
+}
+
+func (r *fakeReader) produce(values ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, value := range values {
+		r.messages = append(r.messages, kafkago.Message{
+			Topic:  "transactions",
+			Offset: int64(len(r.messages)),
+			Value:  []byte(value),
+		})
+	}
+}
+
+func readBatch(t *testing.T, c *Consumer) []internal.Record {
+	var records []internal.Record
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case record := <-c.Channel():
+				records = append(records, record)
+			case <-c.EndChannel():
+				for len(c.Channel()) > 0 {
+					records = append(records, <-c.Channel())
+				}
+				return
+			}
+		}
+	}()
+	assert.NoError(t, c.ReadDataFromFiles(context.Background()))
+	<-done
+	return records
+}
+
+func TestConsumer(t *testing.T) {
+	reader := &fakeReader{}
+	reader.produce(
+		`{"ts":"2024-04-15 02:15:07.167","event":"BUY_ITEMS","project_id":4974,"props":{"currencySymbol":"SFL"},"nums":{"currencyValueDecimal":"0.61"},"country":"DE"}`,
+		`not json`,
+		`{"ts":"2024-04-15 02:26:37.134","event":"BUY_ITEMS","project_id":4974,"props":{"currencySymbol":"SFL"},"nums":{"currencyValueDecimal":"2.36"}}`,
+		`{"ts":"2024-04-15 02:42:32.507","event":"BUY_ITEMS","project_id":1609,"props":{"currencySymbol":"MATIC"},"nums":{"currencyValueDecimal":"0.36"}}`,
+	)
+	c := newConsumer(reader, 1, WithBatch(3, 50*time.Millisecond))
+
+	records := readBatch(t, c)
+	assert.Len(t, records, 2, "the invalid message is skipped")
+	assert.Equal(t, internal.Record{
+		Timestamp: "2024-04-15 02:15:07.167",
+		Event:     "BUY_ITEMS",
+		ProjectID: "4974",
+		Props:     `{"currencySymbol":"SFL"}`,
+		Nums:      `{"currencyValueDecimal":"0.61"}`,
+		Source:    "kafka://transactions/0/0",
+		Columns: map[string]string{
+			"ts":         "2024-04-15 02:15:07.167",
+			"event":      "BUY_ITEMS",
+			"project_id": "4974",
+			"props":      `{"currencySymbol":"SFL"}`,
+			"nums":       `{"currencyValueDecimal":"0.61"}`,
+			"country":    "DE",
+		},
+	}, records[0])
+	assert.Equal(t, 0, reader.committed, "offsets are committed only on Commit")
+
+	assert.NoError(t, c.Commit())
+	assert.Equal(t, 3, reader.committed)
+
+	records = readBatch(t, c)
+	assert.Len(t, records, 1, "the batch ends on timeout")
+	assert.Equal(t, "1609", records[0].ProjectID)
+	assert.NoError(t, c.Commit())
+	assert.Equal(t, 4, reader.committed)
+
+	assert.Empty(t, readBatch(t, c))
+	assert.NoError(t, c.Commit())
+	assert.Equal(t, 4, reader.committed)
+}
+
+func TestConsumerSchema(t *testing.T) {
+	reader := &fakeReader{}
+	reader.produce(`{"time":"2024-04-15 02:15:07.167","payload":{"project":{"id":"42"}},"props":{},"nums":{}}`)
+	c := newConsumer(reader, 1, WithBatch(1, time.Second), WithSchema(dataGetter.Schema{
+		dataGetter.FieldTimestamp: "time",
+		dataGetter.FieldProjectID: "payload.project.id",
+		dataGetter.FieldProps:     "props",
+		dataGetter.FieldNums:      "nums",
+	}))
+
+	records := readBatch(t, c)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "2024-04-15 02:15:07.167", records[0].Timestamp)
+	assert.Equal(t, "42", records[0].ProjectID)
+}
+
+func TestConsumerContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := &fakeReader{}
+	reader.produce(`{"ts":"2024-04-15 02:15:07.167","project_id":1,"props":{},"nums":{}}`)
+	c := newConsumer(reader, 1, WithBatch(10, time.Minute), WithContext(ctx))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	records := readBatch(t, c)
+	assert.Len(t, records, 1, "the batch read before cancellation is kept")
+	assert.True(t, c.Done())
+	assert.NoError(t, c.Commit())
+	assert.Equal(t, 1, reader.committed)
+}
+
+type fakeWriter struct {
+	reader *fakeReader
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafkago.Message) error {
+	for _, msg := range msgs {
+		w.reader.produce(string(msg.Value))
+	}
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	return nil
+}
+
+func TestProducer(t *testing.T) {
+	reader := &fakeReader{}
+	p := &Producer{writer: &fakeWriter{reader: reader}}
+	record := internal.Record{
+		Timestamp: "2024-04-15 02:15:07.167",
+		Event:     "BUY_ITEMS",
+		ProjectID: "4974",
+		Props:     `{"currencySymbol":"SFL"}`,
+		Nums:      `{"currencyValueDecimal":"0.61"}`,
+		Columns:   map[string]string{"country": "DE"},
+		Source:    "s3://exports/daily/2024-04-15.csv",
+	}
+	assert.NoError(t, p.Publish([]internal.Record{record}))
+	assert.JSONEq(t, `{"ts":"2024-04-15 02:15:07.167","event":"BUY_ITEMS","project_id":"4974","props":"{\"currencySymbol\":\"SFL\"}","nums":"{\"currencyValueDecimal\":\"0.61\"}","country":"DE","source":"s3://exports/daily/2024-04-15.csv"}`, string(reader.messages[0].Value))
+
+	c := newConsumer(reader, 1, WithBatch(1, time.Second))
+	records := readBatch(t, c)
+	assert.Len(t, records, 1)
+	assert.Equal(t, record.Timestamp, records[0].Timestamp)
+	assert.Equal(t, record.ProjectID, records[0].ProjectID)
+	assert.Equal(t, record.Props, records[0].Props)
+	assert.Equal(t, record.Nums, records[0].Nums)
+	assert.Equal(t, "DE", records[0].Columns["country"])
+	assert.Equal(t, record.Source, records[0].Source, "the source is kept across services")
+}
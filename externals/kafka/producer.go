@@ -0,0 +1,56 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// messageWriter is the part of kafka-go's Writer used by the producer.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafkago.Message) error
+	Close() error
+}
+
+// Producer publishes records to a Kafka topic, as flat JSON objects keyed by
+// column name, the form read by the Consumer. Records are keyed by project so
+// that the events of a project stay in order.
+type Producer struct {
+	writer messageWriter
+}
+
+func NewProducer(brokers []string, topic string) *Producer {
+	return &Producer{
+		writer: &kafkago.Writer{
+			Addr:         kafkago.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafkago.Hash{},
+			RequiredAcks: kafkago.RequireAll,
+		},
+	}
+}
+
+func (p *Producer) Publish(records []internal.Record) error {
+	msgs := make([]kafkago.Message, 0, len(records))
+	for _, record := range records {
+		value, err := json.Marshal(record.Row())
+		if err != nil {
+			return fmt.Errorf("failed to marshal record: %w", err)
+		}
+		msgs = append(msgs, kafkago.Message{
+			Key:   []byte(record.ProjectID),
+			Value: value,
+		})
+	}
+	if err := p.writer.WriteMessages(context.Background(), msgs...); err != nil {
+		return fmt.Errorf("failed to write messages: %w", err)
+	}
+	return nil
+}
+
+func (p *Producer) Close() error {
+	return p.writer.Close()
+}
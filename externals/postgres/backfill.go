@@ -0,0 +1,50 @@
+package postgres
+
+import (
+	"github.com/jackc/pgx/v5"
+	"github.com/lat1992/blockchain-data-aggregator/externals"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+// backfill stages the stats recomputed for a range of days in memory, and
+// replaces the stored stats of the range with them in a transaction on
+// commit.
+type backfill struct {
+	p     *Postgres
+	r     internal.BackfillRange
+	stats map[string]internal.MarketStat
+}
+
+func (p *Postgres) Backfill(r internal.BackfillRange) (externals.Backfill, error) {
+	return &backfill{p: p, r: r, stats: make(map[string]internal.MarketStat)}, nil
+}
+
+// InsertMarket adds the stats to the staged ones.
+func (b *backfill) InsertMarket(stats map[string]internal.MarketStat) error {
+	for key, stat := range stats {
+		if staged, ok := b.stats[key]; ok {
+			stat.NumTx += staged.NumTx
+			stat.TotalVolume += staged.TotalVolume
+		}
+		b.stats[key] = stat
+	}
+	return nil
+}
+
+// InsertRun records the run of the backfill with the others.
+func (b *backfill) InsertRun(run internal.PipelineRun) error {
+	return b.p.InsertRun(run)
+}
+
+func (b *backfill) Commit() error {
+	batch := &pgx.Batch{}
+	batch.Queue("DELETE FROM market_stats WHERE date BETWEEN $1 AND $2 AND ($3::BIGINT = 0 OR project_id = $3)",
+		b.r.From, b.r.To, b.r.ProjectID)
+	queueMarket(batch, b.stats)
+	return b.p.sendBatch(batch)
+}
+
+func (b *backfill) Rollback() error {
+	b.stats = nil
+	return nil
+}
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS market_stats (
+    date DATE NOT NULL,
+    project_id BIGINT NOT NULL,
+    num_transactions BIGINT NOT NULL,
+    total_volume_usd DOUBLE PRECISION NOT NULL,
+    dimensions JSONB NOT NULL DEFAULT '{}',
+    dimensions_key TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (date, project_id, dimensions_key)
+);
+
+CREATE INDEX IF NOT EXISTS market_stats_project_id_index ON market_stats (project_id);
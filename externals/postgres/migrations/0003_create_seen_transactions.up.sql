@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS seen_transactions (
+    key TEXT PRIMARY KEY,
+    seen_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS pipeline_runs (
+    run_id TEXT PRIMARY KEY,
+    started_at TIMESTAMPTZ NOT NULL,
+    ended_at TIMESTAMPTZ NOT NULL,
+    status TEXT NOT NULL,
+    config_hash TEXT NOT NULL,
+    sources JSONB NOT NULL DEFAULT '{}',
+    records_aggregated BIGINT NOT NULL,
+    records_failed BIGINT NOT NULL,
+    records_duplicated BIGINT NOT NULL,
+    rows_written BIGINT NOT NULL,
+    price_calls BIGINT NOT NULL,
+    errors TEXT[] NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS pipeline_runs_started_at_index ON pipeline_runs (started_at);
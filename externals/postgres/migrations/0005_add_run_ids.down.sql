@@ -0,0 +1 @@
+ALTER TABLE market_stats DROP COLUMN IF EXISTS run_ids;
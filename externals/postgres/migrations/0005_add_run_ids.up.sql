@@ -0,0 +1 @@
+ALTER TABLE market_stats ADD COLUMN IF NOT EXISTS run_ids TEXT[] NOT NULL DEFAULT '{}';
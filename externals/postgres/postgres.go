@@ -0,0 +1,302 @@
+// Package postgres stores the market stats in PostgreSQL, or TimescaleDB.
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Postgres struct {
+	pool *pgxpool.Pool
+	// conn is the connection holding the lock of the migrations, on which
+	// they run.
+	conn *pgxpool.Conn
+	// tx is the transaction of the migration running.
+	tx pgx.Tx
+}
+
+// New connects to the database of a postgres:// URL. Its schema is migrated
+// by the migrator of Migrator.
+func New(url string) (*Postgres, error) {
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to postgres: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("error pinging postgres: %w", err)
+	}
+	return &Postgres{
+		pool: pool,
+	}, nil
+}
+
+func (p *Postgres) Close() {
+	p.pool.Close()
+}
+
+// Ping checks that the database is reachable.
+func (p *Postgres) Ping(ctx context.Context) error {
+	if err := p.pool.Ping(ctx); err != nil {
+		return fmt.Errorf("error pinging postgres: %w", err)
+	}
+	return nil
+}
+
+// InsertMarket adds the stats to the stored ones. Like the summing table of
+// ClickHouse, partial stats of a project, date and dimensions add up, and
+// their run ids are gathered.
+func (p *Postgres) InsertMarket(stats map[string]internal.MarketStat) error {
+	batch := &pgx.Batch{}
+	queueMarket(batch, stats)
+	return p.sendBatch(batch)
+}
+
+func queueMarket(batch *pgx.Batch, stats map[string]internal.MarketStat) {
+	for _, stat := range stats {
+		dimensions := stat.Dimensions
+		if dimensions == nil {
+			dimensions = map[string]string{}
+		}
+		runIDs := []string{}
+		if stat.RunID != "" {
+			runIDs = append(runIDs, stat.RunID)
+		}
+		batch.Queue(`INSERT INTO market_stats (date, project_id, num_transactions, total_volume_usd, dimensions, dimensions_key, run_ids)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (date, project_id, dimensions_key) DO UPDATE SET
+    num_transactions = market_stats.num_transactions + EXCLUDED.num_transactions,
+    total_volume_usd = market_stats.total_volume_usd + EXCLUDED.total_volume_usd,
+    run_ids = ARRAY(SELECT DISTINCT unnest(market_stats.run_ids || EXCLUDED.run_ids))`,
+			stat.Date, stat.ProjectID, stat.NumTx, stat.TotalVolume, dimensions, internal.DimensionsKey(dimensions), runIDs)
+	}
+}
+
+// InsertVerifications stores the verifications, replacing the earlier ones of
+// the same transactions.
+func (p *Postgres) InsertVerifications(verifications []internal.Verification) error {
+	batch := &pgx.Batch{}
+	now := time.Now()
+	for _, v := range verifications {
+		projectID, _ := strconv.ParseUint(v.ProjectID, 10, 64)
+		batch.Queue(`INSERT INTO transaction_verifications (date, project_id, chain_id, txn_hash, currency_address, currency_value_raw, status, reason, verified_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (project_id, txn_hash, currency_value_raw) DO UPDATE SET
+    date = EXCLUDED.date,
+    chain_id = EXCLUDED.chain_id,
+    currency_address = EXCLUDED.currency_address,
+    status = EXCLUDED.status,
+    reason = EXCLUDED.reason,
+    verified_at = EXCLUDED.verified_at`,
+			v.Date, projectID, v.ChainID, v.TxnHash, v.CurrencyAddress, v.CurrencyValueRaw, v.Status, v.Reason, now)
+	}
+	return p.sendBatch(batch)
+}
+
+// InsertRun stores the record of a pipeline run in pipeline_runs.
+func (p *Postgres) InsertRun(run internal.PipelineRun) error {
+	sources, errors := run.Sources, run.Errors
+	if sources == nil {
+		sources = map[string]uint64{}
+	}
+	if errors == nil {
+		errors = []string{}
+	}
+	_, err := p.pool.Exec(context.Background(), `INSERT INTO pipeline_runs (run_id, started_at, ended_at, status, config_hash, sources,
+    records_aggregated, records_failed, records_duplicated, rows_written, price_calls, errors)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		run.ID, run.StartedAt, run.EndedAt, run.Status, run.ConfigHash, sources,
+		run.RecordsAggregated, run.RecordsFailed, run.RecordsDuplicated, run.RowsWritten, run.PriceCalls, errors)
+	if err != nil {
+		return fmt.Errorf("error inserting pipeline run: %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) Seen(keys []string) (map[string]bool, error) {
+	rows, err := p.pool.Query(context.Background(), "SELECT key FROM seen_transactions WHERE key = ANY($1)", keys)
+	if err != nil {
+		return nil, fmt.Errorf("error querying seen transactions: %w", err)
+	}
+	defer rows.Close()
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("error scanning seen transaction: %w", err)
+		}
+		seen[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying seen transactions: %w", err)
+	}
+	return seen, nil
+}
+
+// markSeenQuery records the trades of keys as seen.
+const markSeenQuery = "INSERT INTO seen_transactions (key, seen_at) SELECT unnest($1::text[]), now() ON CONFLICT (key) DO NOTHING"
+
+func (p *Postgres) MarkSeen(keys []string) error {
+	_, err := p.pool.Exec(context.Background(), markSeenQuery, keys)
+	if err != nil {
+		return fmt.Errorf("error inserting seen transactions: %w", err)
+	}
+	return nil
+}
+
+// InsertMarketSeen adds the stats to the stored ones, and marks their trades
+// seen, in a single transaction.
+func (p *Postgres) InsertMarketSeen(stats map[string]internal.MarketStat, keys []string) error {
+	batch := &pgx.Batch{}
+	queueMarket(batch, stats)
+	if len(keys) > 0 {
+		batch.Queue(markSeenQuery, keys)
+	}
+	return p.sendBatch(batch)
+}
+
+// sendBatch runs a batch in a transaction, so that it is stored entirely or
+// not at all.
+func (p *Postgres) sendBatch(batch *pgx.Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("error sending batch: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// Migrator returns the migrator of the schema of the database.
+func (p *Postgres) Migrator() (*migrate.Migrator, error) {
+	return migrate.FromFS(p, migrationFiles, "migrations")
+}
+
+// migrationLockKey is the key of the advisory lock of the migrations.
+const migrationLockKey = 4_218_395_101
+
+// Lock takes the advisory lock of the migrations, on a connection of its own
+// kept until Unlock.
+func (p *Postgres) Lock(ctx context.Context) error {
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		conn.Release()
+		return fmt.Errorf("error locking migrations: %w", err)
+	}
+	p.conn = conn
+	return nil
+}
+
+func (p *Postgres) Unlock(ctx context.Context) error {
+	conn := p.conn
+	p.conn = nil
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("error unlocking migrations: %w", err)
+	}
+	return nil
+}
+
+// Begin begins the transaction of a migration, on the connection holding
+// the lock of the migrations.
+func (p *Postgres) Begin(ctx context.Context) error {
+	tx, err := p.migrationConn().Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+	p.tx = tx
+	return nil
+}
+
+func (p *Postgres) Commit(ctx context.Context) error {
+	tx := p.tx
+	p.tx = nil
+	return tx.Commit(ctx)
+}
+
+func (p *Postgres) Rollback(ctx context.Context) error {
+	tx := p.tx
+	p.tx = nil
+	return tx.Rollback(ctx)
+}
+
+// migrationConn is the transaction of the migration running, else the
+// connection holding the lock of the migrations, else the pool.
+type migrationConn interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func (p *Postgres) migrationConn() migrationConn {
+	switch {
+	case p.tx != nil:
+		return p.tx
+	case p.conn != nil:
+		return p.conn
+	}
+	return p.pool
+}
+
+// Applied returns the versions of the migrations applied.
+func (p *Postgres) Applied(ctx context.Context) (map[uint64]bool, error) {
+	_, err := p.migrationConn().Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL
+)`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+	rows, err := p.migrationConn().Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error querying schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[uint64]bool)
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("error scanning schema_migrations: %w", err)
+		}
+		applied[uint64(version)] = true
+	}
+	return applied, rows.Err()
+}
+
+func (p *Postgres) Exec(ctx context.Context, statement string) error {
+	_, err := p.migrationConn().Exec(ctx, statement)
+	return err
+}
+
+func (p *Postgres) SetApplied(ctx context.Context, migration migrate.Migration, applied bool) error {
+	var err error
+	if applied {
+		_, err = p.migrationConn().Exec(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, now()) ON CONFLICT (version) DO NOTHING", int64(migration.Version), migration.Name)
+	} else {
+		_, err = p.migrationConn().Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", int64(migration.Version))
+	}
+	return err
+}
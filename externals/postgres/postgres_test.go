@@ -0,0 +1,216 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestPostgres connects to the PostgreSQL database at POSTGRES_TEST_URL,
+// in a schema of its own migrated up and dropped at the end of the test. The
+// test is skipped when no database is set.
+func newTestPostgres(t *testing.T) *Postgres {
+	t.Helper()
+	url := os.Getenv("POSTGRES_TEST_URL")
+	if url == "" {
+		t.Skip("POSTGRES_TEST_URL is not set")
+	}
+	admin, err := New(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(admin.Close)
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	ctx := context.Background()
+	if _, err := admin.pool.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.pool.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+	})
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	db, err := New(url + separator + "search_path=" + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	migrator, err := db.Migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigrations(t *testing.T) {
+	migrator, err := (&Postgres{}).Migrator()
+	assert.NoError(t, err)
+	migrations := migrator.Migrations()
+	assert.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		assert.Equal(t, uint64(i+1), migration.Version)
+		assert.NotEmpty(t, migration.Up, migration.Name)
+		assert.NotEmpty(t, migration.Down, migration.Name)
+	}
+}
+
+func TestMigrationsDownUp(t *testing.T) {
+	db := newTestPostgres(t)
+	migrator, err := db.Migrator()
+	assert.NoError(t, err)
+	ctx := context.Background()
+	for range migrator.Migrations() {
+		_, err := migrator.Down(ctx)
+		assert.NoError(t, err)
+	}
+	applied, err := migrator.Up(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, applied, len(migrator.Migrations()))
+}
+
+// marketStats returns the stored stats, by date, project and dimensions.
+func marketStats(t *testing.T, db *Postgres) []internal.MarketStat {
+	rows, err := db.pool.Query(context.Background(), `SELECT date, project_id, num_transactions, total_volume_usd, dimensions, run_ids[1]
+FROM market_stats ORDER BY date, project_id, dimensions_key`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var stats []internal.MarketStat
+	for rows.Next() {
+		var stat internal.MarketStat
+		var runID *string
+		if err := rows.Scan(&stat.Date, &stat.ProjectID, &stat.NumTx, &stat.TotalVolume, &stat.Dimensions, &runID); err != nil {
+			t.Fatal(err)
+		}
+		if runID != nil {
+			stat.RunID = *runID
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+func TestInsertMarket(t *testing.T) {
+	db := newTestPostgres(t)
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	stats := map[string]internal.MarketStat{
+		"01-01-2024-1234-country=FR": {Date: date, ProjectID: 1234, NumTx: 2, TotalVolume: 10, Dimensions: map[string]string{"country": "FR"}, RunID: "run-1"},
+		"01-01-2024-1234-country=US": {Date: date, ProjectID: 1234, NumTx: 1, TotalVolume: 5, Dimensions: map[string]string{"country": "US"}, RunID: "run-1"},
+	}
+
+	// Partial stats of a same key add up.
+	assert.NoError(t, db.InsertMarket(stats))
+	assert.NoError(t, db.InsertMarket(map[string]internal.MarketStat{"01-01-2024-1234-country=FR": stats["01-01-2024-1234-country=FR"]}))
+
+	assert.Equal(t, []internal.MarketStat{
+		{Date: date, ProjectID: 1234, NumTx: 4, TotalVolume: 20, Dimensions: map[string]string{"country": "FR"}, RunID: "run-1"},
+		{Date: date, ProjectID: 1234, NumTx: 1, TotalVolume: 5, Dimensions: map[string]string{"country": "US"}, RunID: "run-1"},
+	}, marketStats(t, db))
+}
+
+func TestSeen(t *testing.T) {
+	db := newTestPostgres(t)
+	seen, err := db.Seen([]string{"137-0xaa-1"})
+	assert.NoError(t, err)
+	assert.Empty(t, seen)
+
+	assert.NoError(t, db.MarkSeen([]string{"137-0xaa-1", "137-0xaa-2"}))
+	assert.NoError(t, db.MarkSeen([]string{"137-0xaa-1"}))
+	seen, err = db.Seen([]string{"137-0xaa-1", "137-0xaa-3"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"137-0xaa-1": true}, seen)
+}
+
+func TestInsertMarketSeen(t *testing.T) {
+	db := newTestPostgres(t)
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	stats := map[string]internal.MarketStat{
+		"01-01-2024-1234": {Date: date, ProjectID: 1234, NumTx: 2, TotalVolume: 10, Dimensions: map[string]string{}},
+	}
+	assert.NoError(t, db.InsertMarketSeen(stats, []string{"137-0xaa-1", "137-0xaa-2"}))
+	seen, err := db.Seen([]string{"137-0xaa-1", "137-0xaa-2"})
+	assert.NoError(t, err)
+	assert.Len(t, seen, 2)
+	assert.Len(t, marketStats(t, db), 1)
+
+	// The stats are not stored when their trades cannot be marked seen.
+	_, err = db.pool.Exec(context.Background(), "DROP TABLE seen_transactions")
+	assert.NoError(t, err)
+	assert.Error(t, db.InsertMarketSeen(stats, []string{"137-0xbb-1"}))
+	assert.Equal(t, uint64(2), marketStats(t, db)[0].NumTx)
+}
+
+func TestInsertVerifications(t *testing.T) {
+	db := newTestPostgres(t)
+	verification := internal.Verification{
+		Date:             time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		ProjectID:        "1234",
+		ChainID:          "137",
+		TxnHash:          "0x01",
+		CurrencyValueRaw: "1500",
+		Status:           internal.VerificationUnverified,
+		Reason:           "transaction not found",
+	}
+	assert.NoError(t, db.InsertVerifications([]internal.Verification{verification}))
+	verification.Status, verification.Reason = internal.VerificationVerified, ""
+	assert.NoError(t, db.InsertVerifications([]internal.Verification{verification}))
+
+	var count int
+	var status string
+	assert.NoError(t, db.pool.QueryRow(context.Background(), "SELECT count(*), max(status) FROM transaction_verifications").Scan(&count, &status))
+	assert.Equal(t, 1, count)
+	assert.Equal(t, internal.VerificationVerified, status)
+}
+
+func TestInsertRun(t *testing.T) {
+	db := newTestPostgres(t)
+	startedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	run := internal.PipelineRun{ID: "run-1", StartedAt: startedAt, EndedAt: startedAt.Add(time.Minute), Status: internal.RunFailed,
+		Sources: map[string]uint64{"data/2024-01-01.csv": 3}, RecordsAggregated: 2, Errors: []string{"connection refused"}}
+	assert.NoError(t, db.InsertRun(run))
+
+	var status string
+	var aggregated uint64
+	assert.NoError(t, db.pool.QueryRow(context.Background(), "SELECT status, records_aggregated FROM pipeline_runs WHERE run_id = 'run-1'").
+		Scan(&status, &aggregated))
+	assert.Equal(t, internal.RunFailed, status)
+	assert.Equal(t, uint64(2), aggregated)
+	assert.Error(t, db.InsertRun(run), "run ids are unique")
+}
+
+func TestBackfill(t *testing.T) {
+	db := newTestPostgres(t)
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	stat := func(d int, projectID uint64) internal.MarketStat {
+		return internal.MarketStat{Date: day(d), ProjectID: projectID, NumTx: 1, TotalVolume: 5, Dimensions: map[string]string{}, RunID: "run-1"}
+	}
+	assert.NoError(t, db.InsertMarket(map[string]internal.MarketStat{
+		"01-01-2024-1234": stat(1, 1234),
+		"02-01-2024-1234": stat(2, 1234),
+		"03-01-2024-1234": stat(3, 1234),
+		"02-01-2024-99":   stat(2, 99),
+	}))
+
+	staged, err := db.Backfill(internal.BackfillRange{From: day(1), To: day(2), ProjectID: 1234})
+	assert.NoError(t, err)
+	recomputed := internal.MarketStat{Date: day(1), ProjectID: 1234, NumTx: 2, TotalVolume: 20, Dimensions: map[string]string{}, RunID: "run-2"}
+	assert.NoError(t, staged.InsertMarket(map[string]internal.MarketStat{"01-01-2024-1234": recomputed}))
+	assert.Len(t, marketStats(t, db), 4, "nothing is visible before the commit")
+	assert.NoError(t, staged.Commit())
+
+	// The stats of the range are replaced, those of the other days and
+	// projects kept.
+	assert.Equal(t, []internal.MarketStat{recomputed, stat(2, 99), stat(3, 1234)}, marketStats(t, db))
+}
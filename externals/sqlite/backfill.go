@@ -0,0 +1,72 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+// backfill stages the stats recomputed for a range of days in memory, and
+// replaces the stored stats of the range with them in a transaction on
+// commit.
+type backfill struct {
+	c     *SQLite
+	r     internal.BackfillRange
+	stats map[string]internal.MarketStat
+}
+
+func (c *SQLite) Backfill(r internal.BackfillRange) (externals.Backfill, error) {
+	return &backfill{c: c, r: r, stats: make(map[string]internal.MarketStat)}, nil
+}
+
+// InsertMarket adds the stats to the staged ones.
+func (b *backfill) InsertMarket(stats map[string]internal.MarketStat) error {
+	for key, stat := range stats {
+		if staged, ok := b.stats[key]; ok {
+			stat.NumTx += staged.NumTx
+			stat.TotalVolume += staged.TotalVolume
+		}
+		b.stats[key] = stat
+	}
+	return nil
+}
+
+// InsertRun records the run of the backfill with the others.
+func (b *backfill) InsertRun(run internal.PipelineRun) error {
+	return b.c.InsertRun(run)
+}
+
+func (b *backfill) Commit() error {
+	tx, err := b.c.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			slog.Error("failed to roll back transaction", "err", err)
+		}
+	}()
+	_, err = tx.Exec("DELETE FROM market_stats WHERE date BETWEEN ? AND ? AND (? = 0 OR project_id = ?)",
+		b.r.From.Format(time.DateOnly), b.r.To.Format(time.DateOnly), b.r.ProjectID, b.r.ProjectID)
+	if err != nil {
+		return fmt.Errorf("error deleting market stats: %w", err)
+	}
+	stmt, err := tx.Prepare(insertMarketQuery)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+	defer stmt.Close()
+	if err := insertMarket(stmt, b.stats); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (b *backfill) Rollback() error {
+	b.stats = nil
+	return nil
+}
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS market_stats (
+    date TEXT NOT NULL,
+    project_id INTEGER NOT NULL,
+    num_transactions INTEGER NOT NULL,
+    total_volume_usd REAL NOT NULL,
+    dimensions TEXT NOT NULL DEFAULT '{}',
+    dimensions_key TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (date, project_id, dimensions_key)
+);
+
+CREATE INDEX IF NOT EXISTS market_stats_project_id_index ON market_stats (project_id);
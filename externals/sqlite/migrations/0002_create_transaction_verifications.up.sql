@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS transaction_verifications (
+    date TEXT NOT NULL,
+    project_id INTEGER NOT NULL,
+    chain_id TEXT NOT NULL,
+    txn_hash TEXT NOT NULL,
+    currency_address TEXT NOT NULL,
+    currency_value_raw TEXT NOT NULL,
+    status TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    verified_at TEXT NOT NULL,
+    PRIMARY KEY (project_id, txn_hash, currency_value_raw)
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS pipeline_runs (
+    run_id TEXT PRIMARY KEY,
+    started_at TEXT NOT NULL,
+    ended_at TEXT NOT NULL,
+    status TEXT NOT NULL,
+    config_hash TEXT NOT NULL,
+    sources TEXT NOT NULL DEFAULT '{}',
+    records_aggregated INTEGER NOT NULL,
+    records_failed INTEGER NOT NULL,
+    records_duplicated INTEGER NOT NULL,
+    rows_written INTEGER NOT NULL,
+    price_calls INTEGER NOT NULL,
+    errors TEXT NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX IF NOT EXISTS pipeline_runs_started_at_index ON pipeline_runs (started_at);
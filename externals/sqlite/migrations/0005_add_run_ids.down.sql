@@ -0,0 +1 @@
+ALTER TABLE market_stats DROP COLUMN run_ids;
@@ -0,0 +1 @@
+ALTER TABLE market_stats ADD COLUMN run_ids TEXT NOT NULL DEFAULT '[]';
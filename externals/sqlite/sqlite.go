@@ -0,0 +1,375 @@
+// Package sqlite stores the market stats in a SQLite file, for local use
+// without a database server.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/migrate"
+	"github.com/mattn/go-sqlite3"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type SQLite struct {
+	db *sql.DB
+	// conn is the connection holding the lock of the migrations, on which
+	// they run.
+	conn *sql.Conn
+}
+
+// New opens the database file at path, created when missing. Its schema is
+// migrated by the migrator of Migrator.
+func New(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite: %w", err)
+	}
+	// SQLite has a single writer, and every connection to an in-memory
+	// database has its own.
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error opening sqlite: %w", err)
+	}
+	return &SQLite{
+		db: db,
+	}, nil
+}
+
+func (c *SQLite) Close() error {
+	return c.db.Close()
+}
+
+// Ping checks that the database file can be read.
+func (c *SQLite) Ping(ctx context.Context) error {
+	if err := c.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("error pinging sqlite: %w", err)
+	}
+	return nil
+}
+
+// insertMarketQuery adds a stat to the stored ones. Like the summing table of
+// ClickHouse, partial stats of a project, date and dimensions add up, and
+// their run ids, a JSON array, are gathered.
+const insertMarketQuery = `INSERT INTO market_stats (date, project_id, num_transactions, total_volume_usd, dimensions, dimensions_key, run_ids)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (date, project_id, dimensions_key) DO UPDATE SET
+    num_transactions = num_transactions + excluded.num_transactions,
+    total_volume_usd = total_volume_usd + excluded.total_volume_usd,
+    run_ids = (
+        SELECT json_group_array(value) FROM (
+            SELECT value FROM json_each(market_stats.run_ids)
+            UNION
+            SELECT value FROM json_each(excluded.run_ids)
+        )
+    )`
+
+// InsertMarket adds the stats to the stored ones.
+func (c *SQLite) InsertMarket(stats map[string]internal.MarketStat) error {
+	return c.inTransaction(insertMarketQuery, func(stmt *sql.Stmt) error {
+		return insertMarket(stmt, stats)
+	})
+}
+
+func insertMarket(stmt *sql.Stmt, stats map[string]internal.MarketStat) error {
+	for _, stat := range stats {
+		dimensions := stat.Dimensions
+		if dimensions == nil {
+			dimensions = map[string]string{}
+		}
+		encoded, err := json.Marshal(dimensions)
+		if err != nil {
+			return err
+		}
+		runIDs := []string{}
+		if stat.RunID != "" {
+			runIDs = append(runIDs, stat.RunID)
+		}
+		encodedRunIDs, err := json.Marshal(runIDs)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(stat.Date.Format(time.DateOnly), stat.ProjectID, stat.NumTx, stat.TotalVolume, string(encoded), internal.DimensionsKey(dimensions), string(encodedRunIDs))
+		if err != nil {
+			return fmt.Errorf("error inserting market stat: %w", err)
+		}
+	}
+	return nil
+}
+
+// InsertVerifications stores the verifications, replacing the earlier ones of
+// the same transactions.
+func (c *SQLite) InsertVerifications(verifications []internal.Verification) error {
+	now := time.Now().UTC().Format(time.DateTime)
+	return c.inTransaction(`INSERT INTO transaction_verifications (date, project_id, chain_id, txn_hash, currency_address, currency_value_raw, status, reason, verified_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (project_id, txn_hash, currency_value_raw) DO UPDATE SET
+    date = excluded.date,
+    chain_id = excluded.chain_id,
+    currency_address = excluded.currency_address,
+    status = excluded.status,
+    reason = excluded.reason,
+    verified_at = excluded.verified_at`, func(stmt *sql.Stmt) error {
+		for _, v := range verifications {
+			projectID, _ := strconv.ParseUint(v.ProjectID, 10, 64)
+			_, err := stmt.Exec(v.Date.Format(time.DateOnly), projectID, v.ChainID, v.TxnHash, v.CurrencyAddress, v.CurrencyValueRaw, v.Status, v.Reason, now)
+			if err != nil {
+				return fmt.Errorf("error inserting verification: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// InsertRun stores the record of a pipeline run in pipeline_runs.
+func (c *SQLite) InsertRun(run internal.PipelineRun) error {
+	sources, errors := run.Sources, run.Errors
+	if sources == nil {
+		sources = map[string]uint64{}
+	}
+	if errors == nil {
+		errors = []string{}
+	}
+	encodedSources, err := json.Marshal(sources)
+	if err != nil {
+		return err
+	}
+	encodedErrors, err := json.Marshal(errors)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`INSERT INTO pipeline_runs (run_id, started_at, ended_at, status, config_hash, sources,
+    records_aggregated, records_failed, records_duplicated, rows_written, price_calls, errors)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.ID, run.StartedAt.UTC().Format(time.RFC3339Nano), run.EndedAt.UTC().Format(time.RFC3339Nano), run.Status, run.ConfigHash, string(encodedSources),
+		run.RecordsAggregated, run.RecordsFailed, run.RecordsDuplicated, run.RowsWritten, run.PriceCalls, string(encodedErrors))
+	if err != nil {
+		return fmt.Errorf("error inserting pipeline run: %w", err)
+	}
+	return nil
+}
+
+func (c *SQLite) Seen(keys []string) (map[string]bool, error) {
+	if len(keys) == 0 {
+		return map[string]bool{}, nil
+	}
+	args := make([]any, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	query := "SELECT key FROM seen_transactions WHERE key IN (?" + strings.Repeat(", ?", len(keys)-1) + ")"
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying seen transactions: %w", err)
+	}
+	defer rows.Close()
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("error scanning seen transaction: %w", err)
+		}
+		seen[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying seen transactions: %w", err)
+	}
+	return seen, nil
+}
+
+// markSeenQuery records the trade of a key as seen.
+const markSeenQuery = "INSERT INTO seen_transactions (key, seen_at) VALUES (?, ?) ON CONFLICT (key) DO NOTHING"
+
+func (c *SQLite) MarkSeen(keys []string) error {
+	return c.inTransaction(markSeenQuery, func(stmt *sql.Stmt) error {
+		return markSeen(stmt, keys)
+	})
+}
+
+func markSeen(stmt *sql.Stmt, keys []string) error {
+	now := time.Now().UTC().Format(time.DateTime)
+	for _, key := range keys {
+		if _, err := stmt.Exec(key, now); err != nil {
+			return fmt.Errorf("error inserting seen transaction: %w", err)
+		}
+	}
+	return nil
+}
+
+// InsertMarketSeen adds the stats to the stored ones, and marks their trades
+// seen, in a single transaction.
+func (c *SQLite) InsertMarketSeen(stats map[string]internal.MarketStat, keys []string) error {
+	return c.inTx(func(tx *sql.Tx) error {
+		err := prepared(tx, insertMarketQuery, func(stmt *sql.Stmt) error {
+			return insertMarket(stmt, stats)
+		})
+		if err != nil {
+			return err
+		}
+		return prepared(tx, markSeenQuery, func(stmt *sql.Stmt) error {
+			return markSeen(stmt, keys)
+		})
+	})
+}
+
+// inTransaction runs a statement as many times as exec does, in a
+// transaction, so that the rows are stored entirely or not at all.
+func (c *SQLite) inTransaction(query string, exec func(*sql.Stmt) error) error {
+	return c.inTx(func(tx *sql.Tx) error {
+		return prepared(tx, query, exec)
+	})
+}
+
+// inTx runs fn in a transaction, committed when it succeeds.
+func (c *SQLite) inTx(fn func(*sql.Tx) error) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			slog.Error("failed to roll back transaction", "err", err)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// prepared prepares a statement of tx, run as many times as exec does.
+func prepared(tx *sql.Tx, query string, exec func(*sql.Stmt) error) error {
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %w", err)
+	}
+	defer stmt.Close()
+	return exec(stmt)
+}
+
+// Migrator returns the migrator of the schema of the database.
+func (c *SQLite) Migrator() (*migrate.Migrator, error) {
+	return migrate.FromFS(c, migrationFiles, "migrations")
+}
+
+// Lock begins an immediate transaction, holding the write lock of the
+// database, on a connection of its own kept until Unlock. Other processes
+// migrating the database wait for it, beyond the busy timeout.
+func (c *SQLite) Lock(ctx context.Context) error {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %w", err)
+	}
+	for {
+		_, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+		var sqliteErr sqlite3.Error
+		if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrBusy {
+			break
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error locking migrations: %w", err)
+	}
+	c.conn = conn
+	return nil
+}
+
+// Unlock commits the transaction of Lock.
+func (c *SQLite) Unlock(ctx context.Context) error {
+	conn := c.conn
+	c.conn = nil
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return fmt.Errorf("error unlocking migrations: %w", err)
+	}
+	return nil
+}
+
+// Begin begins the transaction of a migration, a savepoint of the
+// transaction of Lock.
+func (c *SQLite) Begin(ctx context.Context) error {
+	_, err := c.migrationConn().ExecContext(ctx, "SAVEPOINT migration")
+	return err
+}
+
+func (c *SQLite) Commit(ctx context.Context) error {
+	_, err := c.migrationConn().ExecContext(ctx, "RELEASE migration")
+	return err
+}
+
+func (c *SQLite) Rollback(ctx context.Context) error {
+	if _, err := c.migrationConn().ExecContext(ctx, "ROLLBACK TO migration"); err != nil {
+		return err
+	}
+	_, err := c.migrationConn().ExecContext(ctx, "RELEASE migration")
+	return err
+}
+
+// migrationConn is the connection holding the lock of the migrations, or
+// the database when they are not locked.
+type migrationConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (c *SQLite) migrationConn() migrationConn {
+	if c.conn != nil {
+		return c.conn
+	}
+	return c.db
+}
+
+// Applied returns the versions of the migrations applied.
+func (c *SQLite) Applied(ctx context.Context) (map[uint64]bool, error) {
+	_, err := c.migrationConn().ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TEXT NOT NULL
+)`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+	rows, err := c.migrationConn().QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error querying schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[uint64]bool)
+	for rows.Next() {
+		var version uint64
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("error scanning schema_migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (c *SQLite) Exec(ctx context.Context, statement string) error {
+	_, err := c.migrationConn().ExecContext(ctx, statement)
+	return err
+}
+
+func (c *SQLite) SetApplied(ctx context.Context, migration migrate.Migration, applied bool) error {
+	var err error
+	if applied {
+		_, err = c.migrationConn().ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?) ON CONFLICT (version) DO NOTHING",
+			migration.Version, migration.Name, time.Now().UTC().Format(time.DateTime))
+	} else {
+		_, err = c.migrationConn().ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+	}
+	return err
+}
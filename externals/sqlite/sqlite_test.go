@@ -0,0 +1,292 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSQLite(t *testing.T) *SQLite {
+	db, err := New(filepath.Join(t.TempDir(), "stats.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	migrator, err := db.Migrator()
+	assert.NoError(t, err)
+	_, err = migrator.Up(context.Background())
+	assert.NoError(t, err)
+	return db
+}
+
+func TestInsertMarket(t *testing.T) {
+	db := newTestSQLite(t)
+	date := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	stats := map[string]internal.MarketStat{
+		"01-01-2024-1234-country=FR": {Date: date, ProjectID: 1234, NumTx: 2, TotalVolume: 10, Dimensions: map[string]string{"country": "FR"}},
+		"01-01-2024-1234-country=US": {Date: date, ProjectID: 1234, NumTx: 1, TotalVolume: 5, Dimensions: map[string]string{"country": "US"}},
+	}
+
+	// Partial stats of a same key add up.
+	assert.NoError(t, db.InsertMarket(stats))
+	assert.NoError(t, db.InsertMarket(map[string]internal.MarketStat{"01-01-2024-1234-country=FR": stats["01-01-2024-1234-country=FR"]}))
+
+	rows, err := db.db.Query("SELECT date, project_id, num_transactions, total_volume_usd, dimensions FROM market_stats ORDER BY dimensions_key")
+	assert.NoError(t, err)
+	defer rows.Close()
+	type row struct {
+		date       string
+		projectID  uint64
+		numTx      uint64
+		volume     float64
+		dimensions string
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		assert.NoError(t, rows.Scan(&r.date, &r.projectID, &r.numTx, &r.volume, &r.dimensions))
+		got = append(got, r)
+	}
+	assert.Equal(t, []row{
+		{"2024-01-01", 1234, 4, 20, `{"country":"FR"}`},
+		{"2024-01-01", 1234, 1, 5, `{"country":"US"}`},
+	}, got)
+}
+
+func TestInsertVerifications(t *testing.T) {
+	db := newTestSQLite(t)
+	verification := internal.Verification{
+		Date:             time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		ProjectID:        "1234",
+		ChainID:          "137",
+		TxnHash:          "0x01",
+		CurrencyValueRaw: "1500",
+		Status:           internal.VerificationUnverified,
+		Reason:           "transaction not found",
+	}
+	assert.NoError(t, db.InsertVerifications([]internal.Verification{verification}))
+	verification.Status, verification.Reason = internal.VerificationVerified, ""
+	assert.NoError(t, db.InsertVerifications([]internal.Verification{verification}))
+
+	var count int
+	var status string
+	assert.NoError(t, db.db.QueryRow("SELECT count(*), max(status) FROM transaction_verifications").Scan(&count, &status))
+	assert.Equal(t, 1, count)
+	assert.Equal(t, internal.VerificationVerified, status)
+}
+
+func TestSeen(t *testing.T) {
+	db := newTestSQLite(t)
+	seen, err := db.Seen([]string{"137-0xaa-1"})
+	assert.NoError(t, err)
+	assert.Empty(t, seen)
+
+	assert.NoError(t, db.MarkSeen([]string{"137-0xaa-1", "137-0xaa-2"}))
+	assert.NoError(t, db.MarkSeen([]string{"137-0xaa-1"}))
+	seen, err = db.Seen([]string{"137-0xaa-1", "137-0xaa-3"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"137-0xaa-1": true}, seen)
+}
+
+func TestInsertMarketSeen(t *testing.T) {
+	db := newTestSQLite(t)
+	stats := map[string]internal.MarketStat{
+		"01-01-2024-1234": {Date: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), ProjectID: 1234, NumTx: 2, TotalVolume: 10},
+	}
+	assert.NoError(t, db.InsertMarketSeen(stats, []string{"137-0xaa-1", "137-0xaa-2"}))
+	seen, err := db.Seen([]string{"137-0xaa-1", "137-0xaa-2"})
+	assert.NoError(t, err)
+	assert.Len(t, seen, 2)
+	var numTx uint64
+	assert.NoError(t, db.db.QueryRow("SELECT sum(num_transactions) FROM market_stats").Scan(&numTx))
+	assert.Equal(t, uint64(2), numTx)
+
+	// The stats are not stored when their trades cannot be marked seen.
+	_, err = db.db.Exec("DROP TABLE seen_transactions")
+	assert.NoError(t, err)
+	assert.Error(t, db.InsertMarketSeen(stats, []string{"137-0xbb-1"}))
+	assert.NoError(t, db.db.QueryRow("SELECT sum(num_transactions) FROM market_stats").Scan(&numTx))
+	assert.Equal(t, uint64(2), numTx)
+}
+
+func TestMigrations(t *testing.T) {
+	db := newTestSQLite(t)
+	migrator, err := db.Migrator()
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	statuses, err := migrator.Status(ctx)
+	assert.NoError(t, err)
+	for _, status := range statuses {
+		assert.True(t, status.Applied, status.Name)
+	}
+
+	for range statuses {
+		migration, err := migrator.Down(ctx)
+		assert.NoError(t, err)
+		assert.NotNil(t, migration)
+	}
+	var tables int
+	assert.NoError(t, db.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name != 'schema_migrations'").Scan(&tables))
+	assert.Equal(t, 0, tables)
+
+	applied, err := migrator.Up(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, applied, len(statuses))
+}
+
+func TestMigrationsConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			db, err := New(path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer db.Close()
+			migrator, err := db.Migrator()
+			if err == nil {
+				_, err = migrator.Up(context.Background())
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, <-errs)
+	}
+
+	db, err := New(path)
+	assert.NoError(t, err)
+	defer db.Close()
+	var applied int
+	assert.NoError(t, db.db.QueryRow("SELECT count(*) FROM schema_migrations").Scan(&applied))
+	migrator, err := db.Migrator()
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrator.Migrations()), applied)
+}
+
+func TestMigrationsRollback(t *testing.T) {
+	db := newTestSQLite(t)
+	migrator := migrate.New(db, []migrate.Migration{{
+		Version: 100,
+		Name:    "failing",
+		Up:      []string{"CREATE TABLE partial (id INTEGER)", "INSERT INTO missing VALUES (1)"},
+	}})
+	_, err := migrator.Up(context.Background())
+	assert.Error(t, err)
+
+	// The statements run before the failure are rolled back.
+	var tables int
+	assert.NoError(t, db.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = 'partial'").Scan(&tables))
+	assert.Equal(t, 0, tables)
+	statuses, err := migrator.Status(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, statuses[0].Applied)
+}
+
+func TestInsertMarketRunIDs(t *testing.T) {
+	db := newTestSQLite(t)
+	stat := internal.MarketStat{Date: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), ProjectID: 1234, NumTx: 1, TotalVolume: 5, Dimensions: map[string]string{}}
+
+	// The run ids of the partial stats of a same key are gathered once.
+	for _, runID := range []string{"run-1", "run-2", "run-1", ""} {
+		stat.RunID = runID
+		assert.NoError(t, db.InsertMarket(map[string]internal.MarketStat{"01-01-2024-1234": stat}))
+	}
+
+	var numTx uint64
+	var runIDs string
+	assert.NoError(t, db.db.QueryRow("SELECT num_transactions, run_ids FROM market_stats").Scan(&numTx, &runIDs))
+	assert.Equal(t, uint64(4), numTx)
+	assert.JSONEq(t, `["run-1","run-2"]`, runIDs)
+}
+
+func TestInsertRun(t *testing.T) {
+	db := newTestSQLite(t)
+	startedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, db.InsertRun(internal.PipelineRun{
+		ID:                "run-1",
+		StartedAt:         startedAt,
+		EndedAt:           startedAt.Add(time.Minute),
+		Status:            internal.RunFailed,
+		ConfigHash:        "abc",
+		Sources:           map[string]uint64{"data/2024-01-01.csv": 3},
+		RecordsAggregated: 2,
+		RecordsFailed:     1,
+		RowsWritten:       1,
+		PriceCalls:        2,
+		Errors:            []string{"connection refused"},
+	}))
+	assert.NoError(t, db.InsertRun(internal.PipelineRun{ID: "run-2", StartedAt: startedAt, EndedAt: startedAt, Status: internal.RunSucceeded}))
+
+	var status, sources, errors string
+	var aggregated, failed uint64
+	assert.NoError(t, db.db.QueryRow("SELECT status, sources, records_aggregated, records_failed, errors FROM pipeline_runs WHERE run_id = 'run-1'").
+		Scan(&status, &sources, &aggregated, &failed, &errors))
+	assert.Equal(t, internal.RunFailed, status)
+	assert.JSONEq(t, `{"data/2024-01-01.csv":3}`, sources)
+	assert.Equal(t, uint64(2), aggregated)
+	assert.Equal(t, uint64(1), failed)
+	assert.JSONEq(t, `["connection refused"]`, errors)
+
+	assert.NoError(t, db.db.QueryRow("SELECT sources, errors FROM pipeline_runs WHERE run_id = 'run-2'").Scan(&sources, &errors))
+	assert.Equal(t, "{}", sources)
+	assert.Equal(t, "[]", errors)
+
+	assert.Error(t, db.InsertRun(internal.PipelineRun{ID: "run-1"}), "run ids are unique")
+}
+
+func TestBackfill(t *testing.T) {
+	db := newTestSQLite(t)
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	stat := func(d int, projectID uint64) internal.MarketStat {
+		return internal.MarketStat{Date: day(d), ProjectID: projectID, NumTx: 1, TotalVolume: 5, Dimensions: map[string]string{}, RunID: "run-1"}
+	}
+	assert.NoError(t, db.InsertMarket(map[string]internal.MarketStat{
+		"01-01-2024-1234": stat(1, 1234),
+		"02-01-2024-1234": stat(2, 1234),
+		"03-01-2024-1234": stat(3, 1234),
+		"02-01-2024-99":   stat(2, 99),
+	}))
+
+	staged, err := db.Backfill(internal.BackfillRange{From: day(1), To: day(2), ProjectID: 1234})
+	assert.NoError(t, err)
+	recomputed := internal.MarketStat{Date: day(1), ProjectID: 1234, NumTx: 2, TotalVolume: 20, Dimensions: map[string]string{}, RunID: "run-2"}
+	assert.NoError(t, staged.InsertMarket(map[string]internal.MarketStat{"01-01-2024-1234": recomputed}))
+	assert.NoError(t, staged.InsertMarket(map[string]internal.MarketStat{"01-01-2024-1234": recomputed}))
+
+	// Nothing is visible before the commit.
+	var count int
+	assert.NoError(t, db.db.QueryRow("SELECT count(*) FROM market_stats").Scan(&count))
+	assert.Equal(t, 4, count)
+	assert.NoError(t, staged.Commit())
+
+	rows, err := db.db.Query("SELECT date, project_id, num_transactions, total_volume_usd, run_ids FROM market_stats ORDER BY date, project_id")
+	assert.NoError(t, err)
+	defer rows.Close()
+	type row struct {
+		date      string
+		projectID uint64
+		numTx     uint64
+		volume    float64
+		runIDs    string
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		assert.NoError(t, rows.Scan(&r.date, &r.projectID, &r.numTx, &r.volume, &r.runIDs))
+		got = append(got, r)
+	}
+	// The stats of the range are replaced, those of the other days and
+	// projects kept.
+	assert.Equal(t, []row{
+		{"2024-01-01", 1234, 4, 40, `["run-2"]`},
+		{"2024-01-02", 99, 1, 5, `["run-1"]`},
+		{"2024-01-03", 1234, 1, 5, `["run-1"]`},
+	}, got)
+}
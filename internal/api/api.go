@@ -0,0 +1,236 @@
+// Package api serves the stored stats over HTTP.
+package api
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Server is the HTTP handler of the query API.
+type Server struct {
+	stats externals.StatsReader
+	mux   *http.ServeMux
+}
+
+func NewServer(stats externals.StatsReader) *Server {
+	s := &Server{
+		stats: stats,
+		mux:   http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /projects/{id}/stats", s.projectStats)
+	s.mux.HandleFunc("GET /projects/{id}/currencies", s.currencyStats)
+	s.mux.HandleFunc("GET /stats/top-projects", s.topProjects)
+	s.mux.HandleFunc("GET /openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/yaml")
+		w.Write(openAPISpec)
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// badRequest is an error of the parameters of a request.
+type badRequest struct {
+	error
+}
+
+func (s *Server) projectStats(w http.ResponseWriter, r *http.Request) {
+	query, err := parseQuery(r, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	query.Granularity = r.URL.Query().Get("granularity")
+	switch query.Granularity {
+	case "":
+		query.Granularity = internal.GranularityDay
+	case internal.GranularityDay, internal.GranularityWeek, internal.GranularityMonth:
+	default:
+		writeError(w, badRequest{fmt.Errorf("invalid granularity %q, expected day, week or month", query.Granularity)})
+		return
+	}
+	stats, err := s.stats.ProjectStats(r.Context(), page(query))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResults(w, r, query, stats, []string{"period", "num_transactions", "total_volume_usd"}, func(stat internal.PeriodStat) []string {
+		return []string{stat.Period.Format(time.DateOnly), formatUint(stat.NumTx), formatFloat(stat.TotalVolume)}
+	})
+}
+
+func (s *Server) currencyStats(w http.ResponseWriter, r *http.Request) {
+	query, err := parseQuery(r, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	stats, err := s.stats.CurrencyStats(r.Context(), page(query))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResults(w, r, query, stats, []string{"currency_symbol", "num_transactions", "amount", "total_volume_usd"}, func(stat internal.CurrencyStat) []string {
+		return []string{stat.CurrencySymbol, formatUint(stat.NumTx), formatFloat(stat.Amount), formatFloat(stat.TotalVolume)}
+	})
+}
+
+func (s *Server) topProjects(w http.ResponseWriter, r *http.Request) {
+	query, err := parseQuery(r, false)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if date := r.URL.Query().Get("date"); date != "" {
+		if query.From, err = time.Parse(time.DateOnly, date); err != nil {
+			writeError(w, badRequest{fmt.Errorf("invalid date %q", date)})
+			return
+		}
+		query.To = query.From
+	}
+	stats, err := s.stats.TopProjects(r.Context(), page(query))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResults(w, r, query, stats, []string{"project_id", "num_transactions", "total_volume_usd"}, func(stat internal.ProjectStat) []string {
+		return []string{formatUint(stat.ProjectID), formatUint(stat.NumTx), formatFloat(stat.TotalVolume)}
+	})
+}
+
+// parseQuery reads the project id of the path when project is true, the
+// from and to dates, by default the last 30 days, and the pagination.
+func parseQuery(r *http.Request, project bool) (internal.StatsQuery, error) {
+	var query internal.StatsQuery
+	var err error
+	if project {
+		if query.ProjectID, err = strconv.ParseUint(r.PathValue("id"), 10, 64); err != nil {
+			return query, badRequest{fmt.Errorf("invalid project id %q", r.PathValue("id"))}
+		}
+	}
+
+	values := r.URL.Query()
+	query.To = time.Now().UTC().Truncate(24 * time.Hour)
+	if to := values.Get("to"); to != "" {
+		if query.To, err = time.Parse(time.DateOnly, to); err != nil {
+			return query, badRequest{fmt.Errorf("invalid to date %q", to)}
+		}
+	}
+	query.From = query.To.AddDate(0, 0, -29)
+	if from := values.Get("from"); from != "" {
+		if query.From, err = time.Parse(time.DateOnly, from); err != nil {
+			return query, badRequest{fmt.Errorf("invalid from date %q", from)}
+		}
+	}
+	if query.From.After(query.To) {
+		return query, badRequest{errors.New("from is after to")}
+	}
+
+	query.Limit = defaultLimit
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 1 || query.Limit > maxLimit {
+			return query, badRequest{fmt.Errorf("invalid limit %q, expected 1 to %d", limit, maxLimit)}
+		}
+	}
+	if offset := values.Get("offset"); offset != "" {
+		if query.Offset, err = strconv.Atoi(offset); err != nil || query.Offset < 0 {
+			return query, badRequest{fmt.Errorf("invalid offset %q", offset)}
+		}
+	}
+	return query, nil
+}
+
+// page returns the query reading one more result than the limit, telling
+// whether there is a next page.
+func page(query internal.StatsQuery) internal.StatsQuery {
+	query.Limit++
+	return query
+}
+
+type response[T any] struct {
+	Data       []T  `json:"data"`
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// writeResults writes a page of results as JSON or, when the format query
+// parameter or the Accept header asks for it, as CSV with the given header.
+// The offset of the next page is in the next_offset field, or the
+// X-Next-Offset header for CSV.
+func writeResults[T any](w http.ResponseWriter, r *http.Request, query internal.StatsQuery, results []T, header []string, row func(T) []string) {
+	var next *int
+	if len(results) > query.Limit {
+		results = results[:query.Limit]
+		offset := query.Offset + query.Limit
+		next = &offset
+	}
+	if results == nil {
+		results = []T{}
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" && strings.Contains(r.Header.Get("accept"), "text/csv") {
+		format = "csv"
+	}
+	if format != "csv" {
+		w.Header().Set("content-type", "application/json")
+		if err := json.NewEncoder(w).Encode(response[T]{Data: results, NextOffset: next}); err != nil {
+			slog.Error("failed to write response", "err", err)
+		}
+		return
+	}
+
+	w.Header().Set("content-type", "text/csv")
+	if next != nil {
+		w.Header().Set("x-next-offset", strconv.Itoa(*next))
+	}
+	writer := csv.NewWriter(w)
+	writer.Write(header)
+	for _, result := range results {
+		writer.Write(row(result))
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		slog.Error("failed to write response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if !errors.As(err, &badRequest{}) {
+		slog.Error("failed to query stats", "err", err)
+		status = http.StatusInternalServerError
+		err = errors.New("internal error")
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func formatUint(v uint64) string {
+	return strconv.FormatUint(v, 10)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
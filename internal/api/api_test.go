@@ -0,0 +1,166 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/test-go/testify/mock"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse(time.DateOnly, s)
+	return t
+}
+
+func TestServer_ProjectStats(t *testing.T) {
+	stats := []internal.PeriodStat{
+		{Period: date("2024-04-01"), NumTx: 3, TotalVolume: 1.5},
+		{Period: date("2024-04-08"), NumTx: 1, TotalVolume: 2},
+		{Period: date("2024-04-15"), NumTx: 2, TotalVolume: 0.25},
+	}
+	testCases := []struct {
+		name       string
+		url        string
+		accept     string
+		query      *internal.StatsQuery
+		results    []internal.PeriodStat
+		err        error
+		wantStatus int
+		wantBody   string
+		wantNext   string
+	}{
+		{
+			name:       "next page",
+			url:        "/projects/4974/stats?from=2024-04-01&to=2024-04-30&granularity=week&limit=2",
+			query:      &internal.StatsQuery{ProjectID: 4974, From: date("2024-04-01"), To: date("2024-04-30"), Granularity: "week", Limit: 3},
+			results:    stats,
+			wantStatus: http.StatusOK,
+			wantBody: `{"data":[{"period":"2024-04-01T00:00:00Z","num_transactions":3,"total_volume_usd":1.5},` +
+				`{"period":"2024-04-08T00:00:00Z","num_transactions":1,"total_volume_usd":2}],"next_offset":2}` + "\n",
+		},
+		{
+			name:       "last page",
+			url:        "/projects/4974/stats?from=2024-04-01&to=2024-04-30&offset=2",
+			query:      &internal.StatsQuery{ProjectID: 4974, From: date("2024-04-01"), To: date("2024-04-30"), Granularity: "day", Limit: 101, Offset: 2},
+			results:    stats[2:],
+			wantStatus: http.StatusOK,
+			wantBody:   `{"data":[{"period":"2024-04-15T00:00:00Z","num_transactions":2,"total_volume_usd":0.25}]}` + "\n",
+		},
+		{
+			name:       "csv",
+			url:        "/projects/4974/stats?from=2024-04-01&to=2024-04-30&limit=2",
+			accept:     "text/csv",
+			query:      &internal.StatsQuery{ProjectID: 4974, From: date("2024-04-01"), To: date("2024-04-30"), Granularity: "day", Limit: 3},
+			results:    stats,
+			wantStatus: http.StatusOK,
+			wantBody:   "period,num_transactions,total_volume_usd\n2024-04-01,3,1.5\n2024-04-08,1,2\n",
+			wantNext:   "2",
+		},
+		{
+			name:       "no stats",
+			url:        "/projects/4974/stats?from=2024-04-01&to=2024-04-30",
+			query:      &internal.StatsQuery{ProjectID: 4974, From: date("2024-04-01"), To: date("2024-04-30"), Granularity: "day", Limit: 101},
+			wantStatus: http.StatusOK,
+			wantBody:   `{"data":[]}` + "\n",
+		},
+		{
+			name:       "invalid project",
+			url:        "/projects/abc/stats",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"invalid project id \"abc\""}` + "\n",
+		},
+		{
+			name:       "invalid granularity",
+			url:        "/projects/4974/stats?granularity=year",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"invalid granularity \"year\", expected day, week or month"}` + "\n",
+		},
+		{
+			name:       "invalid dates",
+			url:        "/projects/4974/stats?from=2024-05-01&to=2024-04-30",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"from is after to"}` + "\n",
+		},
+		{
+			name:       "invalid limit",
+			url:        "/projects/4974/stats?limit=5000",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"invalid limit \"5000\", expected 1 to 1000"}` + "\n",
+		},
+		{
+			name:       "database error",
+			url:        "/projects/4974/stats?from=2024-04-01&to=2024-04-30",
+			query:      &internal.StatsQuery{ProjectID: 4974, From: date("2024-04-01"), To: date("2024-04-30"), Granularity: "day", Limit: 101},
+			err:        fmt.Errorf("connection refused"),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   `{"error":"internal error"}` + "\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockStats := new(mocks.StatsReader)
+			if tc.query != nil {
+				mockStats.On("ProjectStats", mock.Anything, *tc.query).Return(tc.results, tc.err)
+			}
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			req.Header.Set("accept", tc.accept)
+			rec := httptest.NewRecorder()
+
+			NewServer(mockStats).ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.wantStatus, rec.Code)
+			assert.Equal(t, tc.wantBody, rec.Body.String())
+			assert.Equal(t, tc.wantNext, rec.Header().Get("x-next-offset"))
+			mockStats.AssertExpectations(t)
+		})
+	}
+}
+
+func TestServer_TopProjects(t *testing.T) {
+	mockStats := new(mocks.StatsReader)
+	query := internal.StatsQuery{From: date("2024-04-15"), To: date("2024-04-15"), Limit: 101}
+	mockStats.On("TopProjects", mock.Anything, query).Return([]internal.ProjectStat{
+		{ProjectID: 4974, NumTx: 149, TotalVolume: 171.5},
+		{ProjectID: 1609, NumTx: 20, TotalVolume: 87},
+	}, nil)
+
+	rec := httptest.NewRecorder()
+	NewServer(mockStats).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/top-projects?date=2024-04-15&format=csv", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv", rec.Header().Get("content-type"))
+	assert.Equal(t, "project_id,num_transactions,total_volume_usd\n4974,149,171.5\n1609,20,87\n", rec.Body.String())
+}
+
+func TestServer_CurrencyStats(t *testing.T) {
+	mockStats := new(mocks.StatsReader)
+	query := internal.StatsQuery{ProjectID: 4974, From: date("2024-04-01"), To: date("2024-04-30"), Limit: 11}
+	mockStats.On("CurrencyStats", mock.Anything, query).Return([]internal.CurrencyStat{
+		{CurrencySymbol: "SFL", NumTx: 12, Amount: 30.5, TotalVolume: 15.25},
+	}, nil)
+
+	rec := httptest.NewRecorder()
+	NewServer(mockStats).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/projects/4974/currencies?from=2024-04-01&to=2024-04-30&limit=10", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"data":[{"currency_symbol":"SFL","num_transactions":12,"amount":30.5,"total_volume_usd":15.25}]}`+"\n", rec.Body.String())
+}
+
+func TestServer_OpenAPI(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewServer(new(mocks.StatsReader)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "/projects/{id}/stats:")
+
+	rec = httptest.NewRecorder()
+	NewServer(new(mocks.StatsReader)).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/projects/4974/stats", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
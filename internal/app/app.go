@@ -0,0 +1,748 @@
+// Package app builds the services of the binaries from the configuration.
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/config"
+	"github.com/lat1992/blockchain-data-aggregator/externals"
+	"github.com/lat1992/blockchain-data-aggregator/externals/clickhouse"
+	"github.com/lat1992/blockchain-data-aggregator/externals/coingecko"
+	"github.com/lat1992/blockchain-data-aggregator/externals/dataGetter"
+	"github.com/lat1992/blockchain-data-aggregator/externals/evm"
+	"github.com/lat1992/blockchain-data-aggregator/externals/fileSink"
+	"github.com/lat1992/blockchain-data-aggregator/externals/httpReceiver"
+	"github.com/lat1992/blockchain-data-aggregator/externals/kafka"
+	"github.com/lat1992/blockchain-data-aggregator/externals/postgres"
+	"github.com/lat1992/blockchain-data-aggregator/externals/sqlite"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/api"
+	"github.com/lat1992/blockchain-data-aggregator/internal/health"
+	"github.com/lat1992/blockchain-data-aggregator/internal/metrics"
+	"github.com/lat1992/blockchain-data-aggregator/internal/migrate"
+	"github.com/lat1992/blockchain-data-aggregator/internal/rpc"
+	"github.com/lat1992/blockchain-data-aggregator/internal/services"
+	"github.com/lat1992/blockchain-data-aggregator/internal/tracing"
+	"github.com/lat1992/blockchain-data-aggregator/proto/aggregatorpb"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+)
+
+// Schema returns the mapping from record fields to input columns.
+func Schema() dataGetter.Schema {
+	return dataGetter.Schema{
+		dataGetter.FieldTimestamp: viper.GetString("SCHEMA_TS"),
+		dataGetter.FieldEvent:     viper.GetString("SCHEMA_EVENT"),
+		dataGetter.FieldProjectID: viper.GetString("SCHEMA_PROJECT_ID"),
+		dataGetter.FieldProps:     viper.GetString("SCHEMA_PROPS"),
+		dataGetter.FieldNums:      viper.GetString("SCHEMA_NUMS"),
+	}
+}
+
+// NewDataGetter creates the record source: the marketplace contracts of an
+// EVM node when EVM_RPC_URL is set, else the files of DATA_PATH, local or S3.
+func NewDataGetter(gNum int) (externals.DataGetterService, error) {
+	if url := viper.GetString("EVM_RPC_URL"); url != "" {
+		markets, err := evm.LoadMarkets(viper.GetString("EVM_MARKETS_PATH"))
+		if err != nil {
+			return nil, err
+		}
+		return evm.New(evm.NewClient(url), markets, viper.GetString("EVM_STATE_PATH"), gNum,
+			evm.WithStartBlock(viper.GetUint64("EVM_START_BLOCK")), evm.WithConfirmations(viper.GetUint64("EVM_CONFIRMATIONS")), evm.WithBlockRange(viper.GetUint64("EVM_BLOCK_RANGE"))), nil
+	}
+	return newFileDataGetter(gNum, viper.GetString("PROCESSED_FILES_PATH"))
+}
+
+// newFileDataGetter creates the reader of the files of DATA_PATH, local or
+// S3, tracking the processed files in trackingPath when not empty.
+func newFileDataGetter(gNum int, trackingPath string) (externals.DataGetterService, error) {
+	opts := []dataGetter.Option{dataGetter.WithSchema(Schema()), dataGetter.WithFormat(viper.GetString("DATA_FORMAT"))}
+	if dataGetter.IsS3URI(viper.GetString("DATA_PATH")) {
+		source, err := dataGetter.NewS3Source(viper.GetString("DATA_PATH"), dataGetter.S3Config{
+			Endpoint:  viper.GetString("S3_ENDPOINT"),
+			AccessKey: viper.GetString("S3_ACCESS_KEY"),
+			SecretKey: viper.GetString("S3_SECRET_KEY"),
+			Region:    viper.GetString("S3_REGION"),
+			UseSSL:    viper.GetBool("S3_USE_SSL"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, dataGetter.WithSource(source))
+	}
+	if trackingPath != "" {
+		opts = append(opts, dataGetter.WithTracking(trackingPath))
+	}
+	return dataGetter.New(viper.GetString("DATA_PATH"), gNum, opts...), nil
+}
+
+// pipelineOptions returns the options of the pipelines: the dimensions, the
+// flushes, the deduplication of the trades against the seen set of the
+// database, the hash of the config recorded with each run, the file sinks of
+// SINKS, a list of "format:directory", and, when VERIFY_RPC_URLS lists
+// "chainId=url" endpoints, the on-chain verifier. With DRY_RUN, nothing is
+// stored.
+func pipelineOptions(db database) ([]services.Option, error) {
+	opts := []services.Option{
+		services.WithDimensions(config.GetList("DIMENSIONS")),
+		services.WithFlush(viper.GetInt("FLUSH_SIZE"), viper.GetDuration("FLUSH_INTERVAL")),
+		services.WithConfigHash(config.Hash()),
+	}
+	if viper.GetBool("DRY_RUN") {
+		opts = append(opts, services.WithDryRun())
+	}
+	if viper.GetBool("DEDUPLICATE") {
+		opts = append(opts, services.WithDeduplication(db))
+	}
+	if endpoints := config.GetList("VERIFY_RPC_URLS"); len(endpoints) > 0 {
+		urls := make(map[string]string, len(endpoints))
+		for _, endpoint := range endpoints {
+			chainID, url, _ := strings.Cut(endpoint, "=")
+			urls[chainID] = url
+		}
+		opts = append(opts, services.WithVerifier(evm.NewVerifier(urls)))
+	}
+	if targets := config.GetList("SINKS"); len(targets) > 0 {
+		sinks := make([]externals.Database, 0, len(targets))
+		for _, target := range targets {
+			format, dir, ok := strings.Cut(target, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid sink %q, expected format:directory", target)
+			}
+			sink, err := fileSink.New(dir, format)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		}
+		opts = append(opts, services.WithSinks(sinks...))
+	}
+	return opts, nil
+}
+
+// migratable is a database with a versioned schema.
+type migratable interface {
+	Migrator() (*migrate.Migrator, error)
+}
+
+// database is a store of the stats with a versioned schema.
+type database interface {
+	externals.Database
+	externals.VerificationDatabase
+	externals.SeenStore
+	externals.RunDatabase
+	externals.BackfillDatabase
+	migratable
+	Ping(ctx context.Context) error
+}
+
+// newDatabase connects to the database of DATABASE_DRIVER: "clickhouse", the
+// default, "postgres" or "sqlite" at DATABASE_URL. Its schema is migrated
+// unless migrations is false.
+func newDatabase(migrations bool) (database, error) {
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	if migrations {
+		if err := migrateDatabase(db); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+func openDatabase() (database, error) {
+	switch driver := viper.GetString("DATABASE_DRIVER"); driver {
+	case "clickhouse":
+		return newClickHouse()
+	case "postgres":
+		return postgres.New(viper.GetString("DATABASE_URL"))
+	case "sqlite":
+		return sqlite.New(viper.GetString("DATABASE_URL"))
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
+
+// migrateDatabase applies the pending migrations of the schema of db.
+func migrateDatabase(db migratable) error {
+	migrator, err := db.Migrator()
+	if err != nil {
+		return err
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		return fmt.Errorf("failed to migrate the database: %w", err)
+	}
+	return nil
+}
+
+func newClickHouse(opts ...clickhouse.Option) (*clickhouse.ClickHouse, error) {
+	if viper.GetBool("CLICKHOUSE_ASYNC_INSERT") {
+		opts = append(opts, clickhouse.WithAsyncInsert())
+	}
+	return clickhouse.New(viper.GetString("CLICKHOUSE_HOSTNAME"), viper.GetString("CLICKHOUSE_DATABASE"), viper.GetString("CLICKHOUSE_USERNAME"), viper.GetString("CLICKHOUSE_PASSWORD"), opts...)
+}
+
+func newCoinGecko() *coingecko.Client {
+	return coingecko.New(viper.GetString("COINGECKO_URL"), viper.GetString("COINGECKO_API_KEY"))
+}
+
+// serveMetrics serves the Prometheus metrics on METRICS_ADDRESS, when set,
+// until the returned function is called.
+func serveMetrics() func() {
+	address := viper.GetString("METRICS_ADDRESS")
+	if address == "" {
+		return func() {}
+	}
+	stop, err := metrics.Serve(address)
+	if err != nil {
+		// The service runs all the same, without metrics.
+		slog.Error("failed to serve metrics", "err", err)
+		return func() {}
+	}
+	return stop
+}
+
+// setupTracing exports the spans of the service to the OTLP collector at
+// OTLP_ENDPOINT, when set, until the returned function is called.
+func setupTracing(service string) func() {
+	endpoint := viper.GetString("OTLP_ENDPOINT")
+	if endpoint == "" {
+		return func() {}
+	}
+	opts := []tracing.Option{tracing.WithSampleRatio(viper.GetFloat64("TRACING_SAMPLE_RATIO"))}
+	if viper.GetBool("OTLP_INSECURE") {
+		opts = append(opts, tracing.WithInsecure())
+	}
+	shutdown, err := tracing.Setup(context.Background(), service, endpoint, opts...)
+	if err != nil {
+		// The service runs all the same, without traces.
+		slog.Error("failed to set up tracing", "err", err)
+		return func() {}
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Error("failed to shut down tracing", "err", err)
+		}
+	}
+}
+
+// serveHealth serves the health probes of a daemon on HEALTH_ADDRESS, when
+// set, until the returned function is called. The checks run every
+// HEALTH_CHECK_INTERVAL.
+func serveHealth(ctx context.Context, opts ...health.Option) func() {
+	address := viper.GetString("HEALTH_ADDRESS")
+	if address == "" {
+		return func() {}
+	}
+	opts = append(opts, health.WithInterval(viper.GetDuration("HEALTH_CHECK_INTERVAL"), 0))
+	checker := health.New(opts...)
+	ctx, cancel := context.WithCancel(ctx)
+	checker.Start(ctx)
+	stop, err := health.Serve(address, checker)
+	if err != nil {
+		// The service runs all the same, the orchestrator failing its probes.
+		slog.Error("failed to serve health probes", "err", err)
+		cancel()
+		return func() {}
+	}
+	return func() {
+		cancel()
+		stop()
+	}
+}
+
+// RunAggregator reads the records of the source once and stores their stats.
+func RunAggregator() error {
+	defer serveMetrics()()
+	defer setupTracing("aggregator")()
+	gNum := viper.GetInt("GOROUTINE_NUM")
+	dg, err := NewDataGetter(gNum)
+	if err != nil {
+		return fmt.Errorf("failed to create data getter: %w", err)
+	}
+	db, err := newDatabase(true)
+	if err != nil {
+		return err
+	}
+	opts, err := pipelineOptions(db)
+	if err != nil {
+		return err
+	}
+	pipeline := services.NewPipeline(newCoinGecko(), dg, db, gNum, opts...)
+	return pipeline.Run()
+}
+
+// RunBackfill recomputes the stats of the range from the files of DATA_PATH,
+// all read again, and replaces the stored stats of the range with them,
+// unless DRY_RUN is set. Trades are deduplicated within the backfill only.
+func RunBackfill(r internal.BackfillRange) error {
+	defer setupTracing("backfill")()
+	gNum := viper.GetInt("GOROUTINE_NUM")
+	dg, err := newFileDataGetter(gNum, "")
+	if err != nil {
+		return fmt.Errorf("failed to create data getter: %w", err)
+	}
+	db, err := newDatabase(true)
+	if err != nil {
+		return err
+	}
+	opts := []services.Option{
+		services.WithDimensions(config.GetList("DIMENSIONS")),
+		services.WithFlush(viper.GetInt("FLUSH_SIZE"), viper.GetDuration("FLUSH_INTERVAL")),
+		services.WithConfigHash(config.Hash()),
+	}
+	if viper.GetBool("DEDUPLICATE") {
+		opts = append(opts, services.WithDeduplication(nil))
+	}
+	if viper.GetBool("DRY_RUN") {
+		opts = append(opts, services.WithDryRun())
+	}
+	return services.NewBackfill(newCoinGecko(), dg, db, r, gNum, opts...).Run()
+}
+
+// RunReprice recomputes the USD volumes of the ClickHouse transactions and
+// stats of the days from to to at the current CoinGecko prices, unless
+// DRY_RUN is set, and reports the volumes before and after, by day and
+// currency, to w. The currencies without a price are reported as skipped.
+func RunReprice(ctx context.Context, from, to time.Time, w io.Writer) error {
+	defer setupTracing("reprice")()
+	db, err := newClickHouse()
+	if err != nil {
+		return err
+	}
+	if err := migrateDatabase(db); err != nil {
+		return err
+	}
+	repricer := services.NewRepricer(newCoinGecko(), db)
+	var repricings []internal.Repricing
+	if viper.GetBool("DRY_RUN") {
+		repricings, err = repricer.Plan(ctx, from, to)
+	} else {
+		repricings, err = repricer.Run(ctx, from, to)
+	}
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "date\tcurrency\tamount\tprice_usd\tbefore_usd\tafter_usd\tdiff_usd\t")
+	var before, after float64
+	for _, r := range repricings {
+		price := fmt.Sprintf("%.6f", r.PriceUSD)
+		if r.Skipped {
+			price = "skipped"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.6f\t%s\t%.2f\t%.2f\t%+.2f\t\n",
+			r.Date.Format(time.DateOnly), r.CurrencySymbol, r.Amount, price, r.Before, r.After, r.After-r.Before)
+		before += r.Before
+		after += r.After
+	}
+	fmt.Fprintf(tw, "total\t\t\t\t%.2f\t%.2f\t%+.2f\t\n", before, after, after-before)
+	return tw.Flush()
+}
+
+// RunWatch reads the new records of the source every WATCH_INTERVAL, and
+// stores their stats, until ctx is done. The files read are tracked in
+// PROCESSED_FILES_PATH, so that they are not read again. A failed run is
+// logged, and read again at the next interval.
+func RunWatch(ctx context.Context) error {
+	defer serveMetrics()()
+	defer setupTracing("watcher")()
+	if viper.GetString("EVM_RPC_URL") == "" && viper.GetString("PROCESSED_FILES_PATH") == "" {
+		return fmt.Errorf("watching the files of DATA_PATH requires PROCESSED_FILES_PATH")
+	}
+	gNum := viper.GetInt("GOROUTINE_NUM")
+	dg, err := NewDataGetter(gNum)
+	if err != nil {
+		return fmt.Errorf("failed to create data getter: %w", err)
+	}
+	db, err := newDatabase(true)
+	if err != nil {
+		return err
+	}
+	opts, err := pipelineOptions(db)
+	if err != nil {
+		return err
+	}
+	coingecko := newCoinGecko()
+	pipeline := services.NewPipeline(coingecko, dg, db, gNum, opts...)
+	interval := viper.GetDuration("WATCH_INTERVAL")
+	// Every run ends the progress, an idle watcher stalls only when it misses
+	// a run.
+	defer serveHealth(ctx,
+		health.WithCheck("database", db.Ping),
+		health.WithCheck("coingecko", coingecko.Ping),
+		health.WithProgress(pipeline.LastProgress, max(viper.GetDuration("HEALTH_STALL_TIMEOUT"), 2*interval)))()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := pipeline.Run(); err != nil {
+			slog.Error("failed to run pipeline", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// RunPrices writes the CoinGecko id of a token, and its USD price on each of
+// the dates, to w.
+func RunPrices(ctx context.Context, symbol string, dates []time.Time, w io.Writer) error {
+	coingecko := newCoinGecko()
+	if err := coingecko.InitTokenIDs(); err != nil {
+		return err
+	}
+	id := coingecko.GetTokenID(symbol)
+	if id == "" {
+		return fmt.Errorf("no coingecko id for token %q", symbol)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "symbol\tid\tdate\tprice_usd")
+	for _, date := range dates {
+		y, m, d := date.Date()
+		price, err := coingecko.GetPrice(ctx, symbol, fmt.Sprintf("%02d-%02d-%d", d, m, y))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%f\n", symbol, id, date.Format(time.DateOnly), price)
+	}
+	return tw.Flush()
+}
+
+// durationKeys are the config keys holding durations.
+var durationKeys = []string{"FLUSH_INTERVAL", "KAFKA_BATCH_TIMEOUT", "INGEST_BATCH_TIMEOUT", "GRPC_IDLE_TIMEOUT", "HEALTH_CHECK_INTERVAL", "HEALTH_STALL_TIMEOUT", "WATCH_INTERVAL"}
+
+// checkConfig returns the errors of the config, that would only show once a
+// service uses it.
+func checkConfig() []error {
+	var errs []error
+	switch driver := viper.GetString("DATABASE_DRIVER"); driver {
+	case "clickhouse", "postgres", "sqlite":
+	default:
+		errs = append(errs, fmt.Errorf("unknown database driver %q", driver))
+	}
+	if viper.GetString("EVM_RPC_URL") == "" && viper.GetString("DATA_PATH") == "" {
+		errs = append(errs, fmt.Errorf("DATA_PATH is not set"))
+	}
+	for _, key := range durationKeys {
+		if _, err := time.ParseDuration(viper.GetString(key)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", key, err))
+		}
+	}
+	if (viper.GetInt("FLUSH_SIZE") > 0 || viper.GetDuration("FLUSH_INTERVAL") > 0) && !viper.GetBool("DEDUPLICATE") {
+		errs = append(errs, fmt.Errorf("FLUSH_SIZE and FLUSH_INTERVAL need DEDUPLICATE"))
+	}
+	for _, target := range config.GetList("SINKS") {
+		format, _, ok := strings.Cut(target, ":")
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("invalid sink %q, expected format:directory", target))
+		case format != fileSink.FormatCSV && format != fileSink.FormatJSONL && format != fileSink.FormatParquet:
+			errs = append(errs, fmt.Errorf("unknown sink format %q", format))
+		}
+	}
+	return errs
+}
+
+// RunValidate checks the config, then reads the files of DATA_PATH and checks
+// that their records can be aggregated, without pricing nor storing them. It
+// writes its report to w, and fails when it found errors.
+func RunValidate(ctx context.Context, w io.Writer) error {
+	errs := checkConfig()
+	for _, err := range errs {
+		fmt.Fprintf(w, "config: %s\n", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d config errors", len(errs))
+	}
+	fmt.Fprintln(w, "config: ok")
+
+	dg, err := newFileDataGetter(1, "")
+	if err != nil {
+		return fmt.Errorf("failed to create data getter: %w", err)
+	}
+	v, err := services.Validate(ctx, dg)
+	if err != nil {
+		return err
+	}
+	var invalid uint64
+	for _, count := range v.Invalid {
+		invalid += count
+	}
+	fmt.Fprintf(w, "records: %d read, %d invalid\n", v.Records, invalid)
+	reasons := make([]string, 0, len(v.Invalid))
+	for reason := range v.Invalid {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "  %s: %d\n", reason, v.Invalid[reason])
+	}
+	for _, example := range v.Examples {
+		fmt.Fprintf(w, "  %s\n", example)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid records", invalid)
+	}
+	return nil
+}
+
+// RunIndexer consumes the records of the Kafka topic and stores their stats
+// batch after batch, until ctx is done.
+func RunIndexer(ctx context.Context) error {
+	defer serveMetrics()()
+	defer setupTracing("indexer")()
+	gNum := viper.GetInt("GOROUTINE_NUM")
+	db, err := newDatabase(true)
+	if err != nil {
+		return err
+	}
+	consumer := kafka.New(config.GetList("KAFKA_BROKERS"), viper.GetString("KAFKA_TOPIC"), viper.GetString("KAFKA_GROUP_ID"), gNum,
+		kafka.WithSchema(Schema()), kafka.WithBatch(viper.GetInt("KAFKA_BATCH_SIZE"), viper.GetDuration("KAFKA_BATCH_TIMEOUT")), kafka.WithContext(ctx))
+	defer func() {
+		if err := consumer.Close(); err != nil {
+			slog.Error("failed to close kafka consumer", "err", err)
+		}
+	}()
+
+	opts, err := pipelineOptions(db)
+	if err != nil {
+		return err
+	}
+	coingecko := newCoinGecko()
+	pipeline := services.NewPipeline(coingecko, consumer, db, gNum, opts...)
+	defer serveHealth(ctx,
+		health.WithCheck("database", db.Ping),
+		health.WithCheck("coingecko", coingecko.Ping),
+		health.WithProgress(pipeline.LastProgress, viper.GetDuration("HEALTH_STALL_TIMEOUT")))()
+	for !consumer.Done() {
+		// A batch that fails is not committed, and is read again on restart.
+		if err := pipeline.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunReceiver receives the records pushed to POST /records on INGEST_ADDRESS,
+// and stores their stats batch after batch, until ctx is done.
+// INGEST_API_KEYS lists the "client=key" API keys of the clients.
+func RunReceiver(ctx context.Context) error {
+	keys := make(map[string]string)
+	for _, entry := range config.GetList("INGEST_API_KEYS") {
+		client, key, ok := strings.Cut(entry, "=")
+		if !ok || client == "" || key == "" {
+			return fmt.Errorf("invalid api key %q, expected client=key", entry)
+		}
+		keys[key] = client
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no api key in INGEST_API_KEYS")
+	}
+	defer serveMetrics()()
+	defer setupTracing("receiver")()
+
+	gNum := viper.GetInt("GOROUTINE_NUM")
+	db, err := newDatabase(true)
+	if err != nil {
+		return err
+	}
+	receiver := httpReceiver.New(keys, gNum, httpReceiver.WithSchema(Schema()), httpReceiver.WithContext(ctx),
+		httpReceiver.WithBatch(viper.GetInt("INGEST_BATCH_SIZE"), viper.GetDuration("INGEST_BATCH_TIMEOUT")), httpReceiver.WithMaxBodySize(viper.GetInt64("INGEST_MAX_BODY_SIZE")))
+	mux := http.NewServeMux()
+	mux.Handle("POST /records", receiver)
+	server := &http.Server{
+		Addr:              viper.GetString("INGEST_ADDRESS"),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	go func() {
+		slog.Info("receiving records", "address", listener.Addr().String())
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("failed to serve receiver", "err", err)
+		}
+	}()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to shut down receiver", "err", err)
+		}
+	}()
+
+	opts, err := pipelineOptions(db)
+	if err != nil {
+		return err
+	}
+	coingecko := newCoinGecko()
+	pipeline := services.NewPipeline(coingecko, receiver, db, gNum, opts...)
+	defer serveHealth(ctx,
+		health.WithCheck("database", db.Ping),
+		health.WithCheck("coingecko", coingecko.Ping),
+		health.WithProgress(pipeline.LastProgress, viper.GetDuration("HEALTH_STALL_TIMEOUT")))()
+	for !receiver.Done() {
+		if err := pipeline.Run(); err != nil {
+			receiver.Abort(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// RunReader reads the records of the source once and publishes them to the
+// Kafka topic.
+func RunReader() error {
+	defer serveMetrics()()
+	defer setupTracing("reader")()
+	dg, err := NewDataGetter(1)
+	if err != nil {
+		return fmt.Errorf("failed to create data getter: %w", err)
+	}
+	producer := kafka.NewProducer(config.GetList("KAFKA_BROKERS"), viper.GetString("KAFKA_TOPIC"))
+	defer func() {
+		if err := producer.Close(); err != nil {
+			slog.Error("failed to close kafka producer", "err", err)
+		}
+	}()
+	return services.NewRelay(dg, producer, viper.GetInt("KAFKA_BATCH_SIZE")).Run()
+}
+
+// RunAPI serves the stats of ClickHouse over HTTP on API_ADDRESS, until ctx
+// is done.
+func RunAPI(ctx context.Context) error {
+	db, err := newClickHouse()
+	if err != nil {
+		return err
+	}
+	defer serveHealth(ctx, health.WithCheck("database", db.Ping))()
+	server := &http.Server{
+		Addr:              viper.GetString("API_ADDRESS"),
+		Handler:           api.NewServer(db),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to shut down api server", "err", err)
+		}
+	}()
+
+	slog.Info("serving api", "address", server.Addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve api: %w", err)
+	}
+	return nil
+}
+
+// RunGRPC serves the gRPC API on GRPC_ADDRESS, until ctx is done: the
+// ingested records are stored in the database, and the stats are queried
+// from it when it is ClickHouse.
+func RunGRPC(ctx context.Context) error {
+	defer serveMetrics()()
+	defer setupTracing("grpc")()
+	gNum := viper.GetInt("GOROUTINE_NUM")
+	db, err := newDatabase(true)
+	if err != nil {
+		return err
+	}
+	opts, err := pipelineOptions(db)
+	if err != nil {
+		return err
+	}
+	coingecko := newCoinGecko()
+	// The pipeline only runs while records are ingested: it is not checked
+	// for progress.
+	defer serveHealth(ctx,
+		health.WithCheck("database", db.Ping),
+		health.WithCheck("coingecko", coingecko.Ping))()
+	stats, _ := db.(externals.StatsReader)
+	server := grpc.NewServer()
+	aggregatorpb.RegisterAggregatorServer(server, rpc.NewServer(stats, func(dg externals.DataGetterService) *services.Pipeline {
+		return services.NewPipeline(coingecko, dg, db, gNum, opts...)
+	}, gNum, rpc.WithStreamLimits(viper.GetInt("GRPC_MAX_RECORDS"), viper.GetDuration("GRPC_IDLE_TIMEOUT"))))
+
+	listener, err := net.Listen("tcp", viper.GetString("GRPC_ADDRESS"))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	slog.Info("serving grpc", "address", listener.Addr().String())
+	if err := server.Serve(listener); err != nil {
+		return fmt.Errorf("failed to serve grpc: %w", err)
+	}
+	return nil
+}
+
+// RunMigrate runs a migrate command on the database schema: "up" applies
+// the pending migrations, "down" reverts the last one applied, and "status"
+// prints the state of every migration to w.
+func RunMigrate(ctx context.Context, command string, w io.Writer) error {
+	db, err := newDatabase(false)
+	if err != nil {
+		return err
+	}
+	migrator, err := db.Migrator()
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		migrations, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d migrations applied\n", len(migrations))
+	case "down":
+		migration, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		if migration == nil {
+			fmt.Fprintln(w, "no migration to revert")
+			return nil
+		}
+		fmt.Fprintf(w, "migration %04d_%s reverted\n", migration.Version, migration.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied"
+			}
+			fmt.Fprintf(w, "%04d_%s\t%s\n", status.Version, status.Name, state)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", command)
+	}
+	return nil
+}
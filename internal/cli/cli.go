@@ -0,0 +1,306 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/config"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/app"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// configKey is the annotation of the flags overriding a config key.
+const configKey = "config_key"
+
+// bindFlag makes the flag name override the config key, when set.
+func bindFlag(flags *pflag.FlagSet, name, key string) {
+	if err := flags.SetAnnotation(name, configKey, []string{key}); err != nil {
+		panic(err)
+	}
+}
+
+// bindFlags binds the flags of cmd set on the command line to their config
+// keys. The flags left unset do not override the environment, the config
+// file nor the defaults.
+func bindFlags(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		if keys, ok := flag.Annotations[configKey]; ok && err == nil {
+			err = viper.BindPFlag(keys[0], flag)
+		}
+	})
+	return err
+}
+
+// addSourceFlags adds the flags of the source of the records.
+func addSourceFlags(flags *pflag.FlagSet) {
+	flags.String("data-path", "", "directory or s3:// URI of the input files (DATA_PATH)")
+	bindFlag(flags, "data-path", "DATA_PATH")
+	flags.String("data-format", "", "format of the input files: csv, jsonl or parquet (DATA_FORMAT)")
+	bindFlag(flags, "data-format", "DATA_FORMAT")
+}
+
+// addPipelineFlags adds the flags of the source and of the aggregation of the
+// records.
+func addPipelineFlags(flags *pflag.FlagSet) {
+	addSourceFlags(flags)
+	flags.String("dimensions", "", "comma separated fields the stats are grouped by (DIMENSIONS)")
+	bindFlag(flags, "dimensions", "DIMENSIONS")
+	flags.Int("flush-size", 0, "records aggregated between two stores, 0 to store at the end (FLUSH_SIZE)")
+	bindFlag(flags, "flush-size", "FLUSH_SIZE")
+}
+
+// signalContext returns the context of a daemon, done on SIGINT or SIGTERM.
+func signalContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+}
+
+// NewRootCommand returns the command line of the aggregator. Without a
+// subcommand, it runs the aggregator once, like run.
+func NewRootCommand() *cobra.Command {
+	var configPath string
+	run := newRunCommand()
+	root := &cobra.Command{
+		Use:   "blockchain-data-aggregator",
+		Short: "Aggregate marketplace transactions into daily market stats",
+		Long: `Aggregate marketplace transactions into daily market stats, priced in USD with CoinGecko.
+
+The config is read from the environment and the .env file of the working
+directory, or the file of --config. The flags override both.`,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// The command line is valid, the errors from now on are not
+			// usage errors.
+			cmd.SilenceUsage = true
+			if err := config.LoadConfigFile(configPath); err != nil {
+				return err
+			}
+			return bindFlags(cmd)
+		},
+		RunE: run.RunE,
+	}
+	root.CompletionOptions.DisableDefaultCmd = true
+	flags := root.PersistentFlags()
+	flags.StringVar(&configPath, "config", "", "env file of the config, .env of the working directory when present by default")
+	flags.Bool("dry-run", false, "read and aggregate the records without storing anything (DRY_RUN)")
+	bindFlag(flags, "dry-run", "DRY_RUN")
+	flags.Int("goroutines", 0, "number of goroutines processing the records (GOROUTINE_NUM)")
+	bindFlag(flags, "goroutines", "GOROUTINE_NUM")
+	flags.String("database-driver", "", "database of the stats: clickhouse, postgres or sqlite (DATABASE_DRIVER)")
+	bindFlag(flags, "database-driver", "DATABASE_DRIVER")
+	flags.String("database-url", "", "PostgreSQL or SQLite database (DATABASE_URL)")
+	bindFlag(flags, "database-url", "DATABASE_URL")
+	addPipelineFlags(root.Flags())
+
+	root.AddCommand(
+		run,
+		newWatchCommand(),
+		newBackfillCommand(),
+		newRepriceCommand(),
+		newMigrateCommand(),
+		newPricesCommand(),
+		newValidateCommand(),
+		newServeCommand(),
+	)
+	return root
+}
+
+func newRunCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Aggregate the new records of the source once",
+		Long: `Read the new records of the source, the files of DATA_PATH or the logs of
+EVM_RPC_URL, and store their stats.`,
+		Example: "  blockchain-data-aggregator run --data-path datas --dimensions country",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return app.RunAggregator()
+		},
+	}
+	addPipelineFlags(cmd.Flags())
+	return cmd
+}
+
+func newWatchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Aggregate the new records of the source at every interval",
+		Long: `Read the new records of the source and store their stats at every interval,
+until interrupted. The files of DATA_PATH are tracked in PROCESSED_FILES_PATH.`,
+		Example: "  blockchain-data-aggregator watch --interval 5m",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signalContext(cmd)
+			defer stop()
+			return app.RunWatch(ctx)
+		},
+	}
+	addPipelineFlags(cmd.Flags())
+	cmd.Flags().Duration("interval", 0, "interval between two runs (WATCH_INTERVAL)")
+	bindFlag(cmd.Flags(), "interval", "WATCH_INTERVAL")
+	return cmd
+}
+
+// parseRange returns the range of days from to to, both included, of the
+// project, every project when 0.
+func parseRange(from, to string, projectID uint64) (internal.BackfillRange, error) {
+	fromDate, err := time.Parse(time.DateOnly, from)
+	if err != nil {
+		return internal.BackfillRange{}, fmt.Errorf("invalid --from: %w", err)
+	}
+	toDate, err := time.Parse(time.DateOnly, to)
+	if err != nil {
+		return internal.BackfillRange{}, fmt.Errorf("invalid --to: %w", err)
+	}
+	if toDate.Before(fromDate) {
+		return internal.BackfillRange{}, fmt.Errorf("--to %s is before --from %s", to, from)
+	}
+	return internal.BackfillRange{From: fromDate, To: toDate, ProjectID: projectID}, nil
+}
+
+// addRangeFlags adds the required --from and --to flags.
+func addRangeFlags(cmd *cobra.Command, from, to *string) {
+	cmd.Flags().StringVar(from, "from", "", "first day, YYYY-MM-DD")
+	cmd.Flags().StringVar(to, "to", "", "last day, YYYY-MM-DD, included")
+	cmd.MarkFlagRequired("from")
+	cmd.MarkFlagRequired("to")
+}
+
+func newBackfillCommand() *cobra.Command {
+	var from, to string
+	var projectID uint64
+	cmd := &cobra.Command{
+		Use:   "backfill",
+		Short: "Recompute the stats of a range of days from the files",
+		Long: `Read all the files of DATA_PATH again, and replace the stored stats of the
+range of days, of every project or of one, with the recomputed ones.`,
+		Example: "  blockchain-data-aggregator backfill --from 2024-01-01 --to 2024-01-31 --project 1234",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r, err := parseRange(from, to, projectID)
+			if err != nil {
+				return err
+			}
+			return app.RunBackfill(r)
+		},
+	}
+	addRangeFlags(cmd, &from, &to)
+	cmd.Flags().Uint64Var(&projectID, "project", 0, "project to recompute, every project when 0")
+	addPipelineFlags(cmd.Flags())
+	return cmd
+}
+
+func newRepriceCommand() *cobra.Command {
+	var from, to string
+	cmd := &cobra.Command{
+		Use:   "reprice",
+		Short: "Recompute the USD volumes of a range of days at the current prices",
+		Long: `Price the native amounts of the ClickHouse transactions of the range of days
+again, replace their USD volumes and stats, and report the volumes before and
+after. With --dry-run, only the report is written.`,
+		Example: "  blockchain-data-aggregator reprice --from 2024-01-01 --to 2024-01-31 --dry-run",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r, err := parseRange(from, to, 0)
+			if err != nil {
+				return err
+			}
+			return app.RunReprice(cmd.Context(), r.From, r.To, cmd.OutOrStdout())
+		},
+	}
+	addRangeFlags(cmd, &from, &to)
+	return cmd
+}
+
+func newMigrateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate up|down|status",
+		Short: "Migrate the database schema",
+		Long: `Apply the pending migrations (up), roll back the last one (down), or list
+the applied and pending ones (status).`,
+		Example:   "  blockchain-data-aggregator migrate status --database-driver sqlite --database-url stats.db",
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"up", "down", "status"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return app.RunMigrate(cmd.Context(), args[0], cmd.OutOrStdout())
+		},
+	}
+}
+
+func newPricesCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "prices SYMBOL DATE...",
+		Short:   "Show the CoinGecko prices of a token",
+		Long:    "Show the CoinGecko id of the token, and its USD price on each of the days.",
+		Example: "  blockchain-data-aggregator prices SFL 2024-01-01 2024-01-02",
+		Args:    cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dates := make([]time.Time, 0, len(args)-1)
+			for _, arg := range args[1:] {
+				date, err := time.Parse(time.DateOnly, arg)
+				if err != nil {
+					return fmt.Errorf("invalid date: %w", err)
+				}
+				dates = append(dates, date)
+			}
+			return app.RunPrices(cmd.Context(), args[0], dates, cmd.OutOrStdout())
+		},
+	}
+}
+
+func newValidateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the config and the input files",
+		Long: `Check the config, then read the files of DATA_PATH and report the records
+that cannot be aggregated, without pricing nor storing anything.`,
+		Example: "  blockchain-data-aggregator validate --data-path datas",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return app.RunValidate(cmd.Context(), cmd.OutOrStdout())
+		},
+	}
+	addSourceFlags(cmd.Flags())
+	return cmd
+}
+
+func newServeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run a service until interrupted",
+	}
+	cmd.AddCommand(
+		newServiceCommand("api", "Serve the stats over HTTP", "API_ADDRESS", app.RunAPI),
+		newServiceCommand("grpc", "Serve the stats over gRPC", "GRPC_ADDRESS", app.RunGRPC),
+		newServiceCommand("receiver", "Receive the records pushed over HTTP", "INGEST_ADDRESS", app.RunReceiver),
+		newServiceCommand("indexer", "Aggregate the records of the Kafka topic", "", app.RunIndexer),
+	)
+	return cmd
+}
+
+// newServiceCommand returns the serve subcommand running the service, with an
+// --address flag overriding the config key addressKey, when set.
+func newServiceCommand(use, short, addressKey string, run func(context.Context) error) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signalContext(cmd)
+			defer stop()
+			return run(ctx)
+		},
+	}
+	if addressKey != "" {
+		cmd.Flags().String("address", "", fmt.Sprintf("address to listen on (%s)", addressKey))
+		bindFlag(cmd.Flags(), "address", addressKey)
+	}
+	return cmd
+}
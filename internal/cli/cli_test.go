@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// execute runs the root command with args and a probe subcommand, and
+// returns the config seen by the probe.
+func execute(t *testing.T, args ...string) (map[string]any, error) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	var settings map[string]any
+	root := NewRootCommand()
+	probe := &cobra.Command{
+		Use: "probe",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			settings = map[string]any{
+				"GOROUTINE_NUM": viper.GetInt("GOROUTINE_NUM"),
+				"DRY_RUN":       viper.GetBool("DRY_RUN"),
+				"DATA_PATH":     viper.GetString("DATA_PATH"),
+			}
+			return nil
+		},
+	}
+	addPipelineFlags(probe.Flags())
+	root.AddCommand(probe)
+	root.SetArgs(args)
+	return settings, root.Execute()
+}
+
+func TestRootCommand_Flags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.env")
+	assert.NoError(t, os.WriteFile(path, []byte("GOROUTINE_NUM=3\nDATA_PATH=files\n"), 0o644))
+
+	settings, err := execute(t, "probe", "--config", path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"GOROUTINE_NUM": 3, "DRY_RUN": false, "DATA_PATH": "files"}, settings)
+
+	settings, err = execute(t, "probe", "--config", path, "--goroutines", "8", "--dry-run", "--data-path", "other")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"GOROUTINE_NUM": 8, "DRY_RUN": true, "DATA_PATH": "other"}, settings)
+
+	_, err = execute(t, "probe", "--config", filepath.Join(t.TempDir(), "missing.env"))
+	assert.Error(t, err)
+}
+
+func TestParseRange(t *testing.T) {
+	r, err := parseRange("2024-01-01", "2024-01-31", 1234)
+	assert.NoError(t, err)
+	assert.Equal(t, internal.BackfillRange{
+		From:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:        time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+		ProjectID: 1234,
+	}, r)
+
+	_, err = parseRange("2024-01-31", "2024-01-01", 0)
+	assert.Error(t, err)
+	_, err = parseRange("01-01-2024", "2024-01-31", 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,200 @@
+// Package health serves the liveness and readiness probes of the daemons.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports whether a dependency of the service is available.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the checks of a service in the background, so that probes
+// neither wait for nor add load to the dependencies, and serves their last
+// results: /readyz is ready once every check passed, and /healthz is live
+// while the pipeline makes progress.
+type Checker struct {
+	checks       []namedCheck
+	interval     time.Duration
+	timeout      time.Duration
+	lastProgress func() time.Time
+	stallTimeout time.Duration
+
+	mutex   sync.RWMutex
+	results map[string]error
+}
+
+type Option func(*Checker)
+
+// WithCheck adds a readiness check, reported under name.
+func WithCheck(name string, check Check) Option {
+	return func(c *Checker) {
+		c.checks = append(c.checks, namedCheck{name, check})
+	}
+}
+
+// WithProgress fails the liveness probe when lastProgress, e.g. the last
+// progress of a pipeline, is older than stallTimeout.
+func WithProgress(lastProgress func() time.Time, stallTimeout time.Duration) Option {
+	return func(c *Checker) {
+		c.lastProgress = lastProgress
+		c.stallTimeout = stallTimeout
+	}
+}
+
+// WithInterval sets the interval between two runs of the checks, each run
+// bounded by timeout.
+func WithInterval(interval, timeout time.Duration) Option {
+	return func(c *Checker) {
+		if interval > 0 {
+			c.interval = interval
+		}
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+func New(opts ...Option) *Checker {
+	c := &Checker{
+		interval: 30 * time.Second,
+		timeout:  5 * time.Second,
+		results:  make(map[string]error),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Start runs the checks now, then every interval until ctx is done.
+func (c *Checker) Start(ctx context.Context) {
+	c.runChecks(ctx)
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.runChecks(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (c *Checker) runChecks(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check namedCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+			err := check.check(ctx)
+			if err != nil {
+				slog.Error("health check failed", "check", check.name, "err", err)
+			}
+			c.mutex.Lock()
+			c.results[check.name] = err
+			c.mutex.Unlock()
+		}(check)
+	}
+	wg.Wait()
+}
+
+type response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Handler returns the handler of GET /healthz and GET /readyz.
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", c.healthz)
+	mux.HandleFunc("GET /readyz", c.readyz)
+	return mux
+}
+
+func (c *Checker) healthz(w http.ResponseWriter, r *http.Request) {
+	if c.lastProgress != nil && c.stallTimeout > 0 {
+		if since := time.Since(c.lastProgress()); since > c.stallTimeout {
+			writeResponse(w, http.StatusServiceUnavailable, response{
+				Status: "stalled",
+				Checks: map[string]string{"pipeline": fmt.Sprintf("no progress for %s", since.Truncate(time.Second))},
+			})
+			return
+		}
+	}
+	writeResponse(w, http.StatusOK, response{Status: "ok"})
+}
+
+func (c *Checker) readyz(w http.ResponseWriter, r *http.Request) {
+	resp := response{Status: "ok", Checks: make(map[string]string, len(c.checks))}
+	c.mutex.RLock()
+	for _, check := range c.checks {
+		err, ran := c.results[check.name]
+		switch {
+		case !ran:
+			resp.Checks[check.name] = "pending"
+			resp.Status = "unavailable"
+		case err != nil:
+			resp.Checks[check.name] = err.Error()
+			resp.Status = "unavailable"
+		default:
+			resp.Checks[check.name] = "ok"
+		}
+	}
+	c.mutex.RUnlock()
+
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeResponse(w, status, resp)
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp response) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Serve serves the probes of the checker at address in the background, until
+// the returned function is called.
+func Serve(address string, c *Checker) (func(), error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	server := &http.Server{
+		Handler:           c.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("failed to serve health probes", "err", err)
+		}
+	}()
+	slog.Info("serving health probes", "address", listener.Addr().String())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("failed to shut down health server", "err", err)
+		}
+	}, nil
+}
@@ -0,0 +1,71 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func get(c *Checker, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestReadyz(t *testing.T) {
+	priceErr := errors.New("connection refused")
+	c := New(
+		WithCheck("database", func(ctx context.Context) error { return nil }),
+		WithCheck("prices", func(ctx context.Context) error { return priceErr }),
+		WithInterval(time.Hour, time.Second))
+
+	rec := get(c, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code, "not ready before the first checks")
+	assert.JSONEq(t, `{"status":"unavailable","checks":{"database":"pending","prices":"pending"}}`, rec.Body.String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+	rec = get(c, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"unavailable","checks":{"database":"ok","prices":"connection refused"}}`, rec.Body.String())
+
+	priceErr = nil
+	c.runChecks(ctx)
+	rec = get(c, "/readyz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok","checks":{"database":"ok","prices":"ok"}}`, rec.Body.String())
+}
+
+func TestReadyzTimeout(t *testing.T) {
+	c := New(
+		WithCheck("database", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+		WithInterval(time.Hour, 10*time.Millisecond))
+	c.runChecks(context.Background())
+
+	rec := get(c, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"unavailable","checks":{"database":"context deadline exceeded"}}`, rec.Body.String())
+}
+
+func TestHealthz(t *testing.T) {
+	progress := time.Now()
+	c := New(WithProgress(func() time.Time { return progress }, time.Minute))
+
+	rec := get(c, "/healthz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+
+	progress = time.Now().Add(-2 * time.Minute)
+	rec = get(c, "/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"stalled","checks":{"pipeline":"no progress for 2m0s"}}`, rec.Body.String())
+}
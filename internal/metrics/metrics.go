@@ -0,0 +1,107 @@
+// Package metrics defines the Prometheus metrics of the services, and serves
+// them on /metrics.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	RecordsRead = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_records_read_total",
+		Help: "Records read from the input files, by file.",
+	}, []string{"file"})
+	RecordsAggregated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "aggregator_records_aggregated_total",
+		Help: "Records priced and aggregated.",
+	})
+	RecordsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_records_failed_total",
+		Help: "Records that could not be aggregated, by reason.",
+	}, []string{"reason"})
+	RecordsDuplicated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "aggregator_records_duplicated_total",
+		Help: "Records dropped as duplicates of trades already aggregated.",
+	})
+	RecordChannelDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "aggregator_record_channel_depth",
+		Help: "Records waiting in the record channel of the pipeline.",
+	})
+	RunDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aggregator_run_duration_seconds",
+		Help:    "Duration of the pipeline runs, by status.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 16),
+	}, []string{"status"})
+
+	CoinGeckoRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "coingecko_requests_total",
+		Help: "Requests to CoinGecko, by endpoint and status code, \"error\" when no response was received.",
+	}, []string{"endpoint", "code"})
+	CoinGeckoRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "coingecko_request_duration_seconds",
+		Help:    "Latency of the requests to CoinGecko, by endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+	CoinGeckoCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "coingecko_price_cache_hits_total",
+		Help: "Prices read from the cache.",
+	})
+	CoinGeckoCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "coingecko_price_cache_misses_total",
+		Help: "Prices requested to CoinGecko.",
+	})
+
+	ClickHouseBatchRows = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "clickhouse_batch_rows",
+		Help:    "Rows of the batches inserted into ClickHouse, by table.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"table"})
+	ClickHouseInsertDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "clickhouse_insert_duration_seconds",
+		Help:    "Latency of the batch inserts into ClickHouse, by table.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"table"})
+)
+
+// Serve serves the metrics on /metrics at address in the background, until
+// the returned function is called.
+func Serve(address string) (func(), error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	server := &http.Server{
+		Handler:           handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("failed to serve metrics", "err", err)
+		}
+	}()
+	slog.Info("serving metrics", "address", listener.Addr().String())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("failed to shut down metrics server", "err", err)
+		}
+	}, nil
+}
+
+// handler serves the metrics on /metrics.
+func handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	return mux
+}
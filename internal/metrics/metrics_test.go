@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServe(t *testing.T) {
+	stop, err := Serve("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer stop()
+
+	_, err = Serve("invalid address")
+	assert.Error(t, err)
+}
+
+func TestServeMetrics(t *testing.T) {
+	server := httptest.NewServer(handler())
+	defer server.Close()
+
+	RecordsFailed.Reset()
+	RecordsRead.Reset()
+	RecordsFailed.WithLabelValues("timestamp").Inc()
+	RecordsRead.WithLabelValues("gs://bucket/sample.csv").Add(2)
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `aggregator_records_failed_total{reason="timestamp"} 1`)
+	assert.Contains(t, string(body), `aggregator_records_read_total{file="gs://bucket/sample.csv"} 2`)
+}
@@ -0,0 +1,271 @@
+// Package migrate applies versioned SQL migrations to a database.
+//
+// Migrations are pairs of files named "<version>_<name>.up.sql" and
+// "<version>_<name>.down.sql", e.g. "0001_create_market_stats.up.sql". A file
+// may hold several statements, each ended by a semicolon at the end of a line.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migration is a versioned change of the schema.
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Store runs the statements of migrations and records the ones applied, in
+// the schema_migrations table of the database.
+type Store interface {
+	// Lock waits for the migrations run by other processes on the database
+	// to end, and holds them until Unlock.
+	Lock(ctx context.Context) error
+	// Unlock releases the lock taken by Lock.
+	Unlock(ctx context.Context) error
+	// Applied returns the versions of the migrations applied.
+	Applied(ctx context.Context) (map[uint64]bool, error)
+	// Exec runs a statement.
+	Exec(ctx context.Context, statement string) error
+	// SetApplied records a migration as applied or reverted.
+	SetApplied(ctx context.Context, migration Migration, applied bool) error
+}
+
+// TxStore is a Store running each migration, with its record, in a
+// transaction, so that a migration failing halfway leaves nothing behind.
+type TxStore interface {
+	Store
+	// Begin begins the transaction of a migration.
+	Begin(ctx context.Context) error
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+// FromFS returns the migrator of store, applying the migrations of the
+// directory dir of fsys, e.g. the migrations embedded in a backend.
+func FromFS(store Store, fsys fs.FS, dir string) (*Migrator, error) {
+	files, err := fs.Sub(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	migrations, err := Load(files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	return New(store, migrations), nil
+}
+
+// Load reads the migrations of the root directory of fsys, ordered by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	migrations := make(map[uint64]*Migration)
+	for _, entry := range entries {
+		name, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" || !ok {
+			continue
+		}
+		versionString, name, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseUint(versionString, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q: %w", entry.Name(), err)
+		}
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", entry.Name(), err)
+		}
+
+		migration, exist := migrations[version]
+		if !exist {
+			migration = &Migration{Version: version, Name: name}
+			migrations[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migrations %q and %q share version %d", migration.Name, name, version)
+		}
+		switch direction {
+		case "up":
+			migration.Up = Statements(string(data))
+		case "down":
+			migration.Down = Statements(string(data))
+		default:
+			return nil, fmt.Errorf("invalid migration direction %q", entry.Name())
+		}
+	}
+
+	list := make([]Migration, 0, len(migrations))
+	for _, migration := range migrations {
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		list = append(list, *migration)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Statements splits a SQL file into its statements.
+func Statements(sql string) []string {
+	statements := []string{}
+	var statement strings.Builder
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		statement.WriteString(line)
+		statement.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(statement.String()), ";"))
+			statement.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(statement.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// Status is the state of a migration in a database.
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Migrator applies migrations to the database of a store.
+type Migrator struct {
+	store      Store
+	migrations []Migration
+}
+
+func New(store Store, migrations []Migration) *Migrator {
+	return &Migrator{
+		store:      store,
+		migrations: migrations,
+	}
+}
+
+// Migrations returns the migrations of the migrator, ordered by version.
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up applies the pending migrations in order, and returns the ones applied.
+// The migrations are locked meanwhile, so that concurrent startups apply
+// each migration once.
+func (m *Migrator) Up(ctx context.Context) (done []Migration, err error) {
+	if err := m.store.Lock(ctx); err != nil {
+		return nil, fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer m.unlock(ctx, &err)
+	applied, err := m.store.Applied(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for _, migration := range m.migrations {
+		if applied[migration.Version] {
+			continue
+		}
+		if err := m.apply(ctx, migration, migration.Up, true); err != nil {
+			return done, err
+		}
+		slog.Info("migration applied", "version", migration.Version, "name", migration.Name)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down reverts the last migration applied, and returns it, or nil when no
+// migration is applied.
+func (m *Migrator) Down(ctx context.Context) (reverted *Migration, err error) {
+	if err := m.store.Lock(ctx); err != nil {
+		return nil, fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer m.unlock(ctx, &err)
+	applied, err := m.store.Applied(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if !applied[migration.Version] {
+			continue
+		}
+		if migration.Down == nil {
+			return nil, fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+		if err := m.apply(ctx, migration, migration.Down, false); err != nil {
+			return nil, err
+		}
+		slog.Info("migration reverted", "version", migration.Version, "name", migration.Name)
+		return &migration, nil
+	}
+	return nil, nil
+}
+
+// Status returns the state of every migration.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.store.Applied(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		statuses = append(statuses, Status{Migration: migration, Applied: applied[migration.Version]})
+	}
+	return statuses, nil
+}
+
+// unlock releases the lock of the migrations, failing *err when it cannot.
+func (m *Migrator) unlock(ctx context.Context, err *error) {
+	if unlockErr := m.store.Unlock(ctx); unlockErr != nil && *err == nil {
+		*err = fmt.Errorf("failed to unlock migrations: %w", unlockErr)
+	}
+}
+
+// apply runs the statements of a migration, and records it as applied or
+// reverted, in a transaction when the store supports it.
+func (m *Migrator) apply(ctx context.Context, migration Migration, statements []string, applied bool) (err error) {
+	if tx, ok := m.store.(TxStore); ok {
+		if err := tx.Begin(ctx); err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", migration.Version, err)
+		}
+		defer func() {
+			if err != nil {
+				if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+					slog.Error("failed to roll back migration", "version", migration.Version, "err", rollbackErr)
+				}
+				return
+			}
+			if err = tx.Commit(ctx); err != nil {
+				err = fmt.Errorf("failed to commit migration %d: %w", migration.Version, err)
+			}
+		}()
+	}
+	if err := m.run(ctx, migration, statements); err != nil {
+		return err
+	}
+	if err := m.store.SetApplied(ctx, migration, applied); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+	}
+	return nil
+}
+
+func (m *Migrator) run(ctx context.Context, migration Migration, statements []string) error {
+	for _, statement := range statements {
+		if err := m.store.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("failed to run migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStore records the statements run.
+type fakeStore struct {
+	applied    map[uint64]bool
+	statements []string
+	execErr    error
+	locked     bool
+	locks      int
+}
+
+func (s *fakeStore) Lock(ctx context.Context) error {
+	if s.locked {
+		return fmt.Errorf("already locked")
+	}
+	s.locked = true
+	s.locks++
+	return nil
+}
+
+func (s *fakeStore) Unlock(ctx context.Context) error {
+	if !s.locked {
+		return fmt.Errorf("not locked")
+	}
+	s.locked = false
+	return nil
+}
+
+func (s *fakeStore) Applied(ctx context.Context) (map[uint64]bool, error) {
+	applied := make(map[uint64]bool)
+	for version, ok := range s.applied {
+		applied[version] = ok
+	}
+	return applied, nil
+}
+
+func (s *fakeStore) Exec(ctx context.Context, statement string) error {
+	if s.execErr != nil {
+		return s.execErr
+	}
+	s.statements = append(s.statements, statement)
+	return nil
+}
+
+func (s *fakeStore) SetApplied(ctx context.Context, migration Migration, applied bool) error {
+	s.applied[migration.Version] = applied
+	return nil
+}
+
+var testFiles = fstest.MapFS{
+	"0002_add_b.up.sql":   {Data: []byte("ALTER TABLE a ADD COLUMN b String;\n")},
+	"0002_add_b.down.sql": {Data: []byte("ALTER TABLE a DROP COLUMN b;\n")},
+	"0001_create_a.up.sql": {Data: []byte(`-- the first table
+CREATE TABLE a (
+    id UInt64
+) ENGINE = MergeTree ()
+ORDER BY id;
+
+CREATE TABLE c (id UInt64) ENGINE = Memory;
+`)},
+	"0001_create_a.down.sql": {Data: []byte("DROP TABLE c;\nDROP TABLE a;\n")},
+	"README.md":              {Data: []byte("not a migration")},
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testFiles)
+	assert.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{
+			Version: 1,
+			Name:    "create_a",
+			Up: []string{
+				"CREATE TABLE a (\n    id UInt64\n) ENGINE = MergeTree ()\nORDER BY id",
+				"CREATE TABLE c (id UInt64) ENGINE = Memory",
+			},
+			Down: []string{"DROP TABLE c", "DROP TABLE a"},
+		},
+		{
+			Version: 2,
+			Name:    "add_b",
+			Up:      []string{"ALTER TABLE a ADD COLUMN b String"},
+			Down:    []string{"ALTER TABLE a DROP COLUMN b"},
+		},
+	}, migrations)
+
+	_, err = Load(fstest.MapFS{"0001_create_a.down.sql": {Data: []byte("DROP TABLE a;")}})
+	assert.Error(t, err)
+	_, err = Load(fstest.MapFS{"first.up.sql": {Data: []byte("SELECT 1;")}})
+	assert.Error(t, err)
+}
+
+func TestMigrator(t *testing.T) {
+	migrations, err := Load(testFiles)
+	assert.NoError(t, err)
+	store := &fakeStore{applied: map[uint64]bool{1: true}}
+	migrator := New(store, migrations)
+	ctx := context.Background()
+
+	statuses, err := migrator.Status(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, statuses, 2)
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[1].Applied)
+
+	applied, err := migrator.Up(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, applied, 1)
+	assert.Equal(t, uint64(2), applied[0].Version)
+	assert.Equal(t, []string{"ALTER TABLE a ADD COLUMN b String"}, store.statements)
+
+	applied, err = migrator.Up(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+
+	reverted, err := migrator.Down(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), reverted.Version)
+	reverted, err = migrator.Down(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), reverted.Version)
+	reverted, err = migrator.Down(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, reverted)
+	assert.Equal(t, map[uint64]bool{1: false, 2: false}, store.applied)
+
+	store.execErr = fmt.Errorf("syntax error")
+	applied, err = migrator.Up(ctx)
+	assert.Error(t, err)
+	assert.Empty(t, applied)
+	assert.Equal(t, map[uint64]bool{1: false, 2: false}, store.applied)
+	// Every Up and Down ran locked, and released the lock, even on failure.
+	assert.Equal(t, 6, store.locks)
+	assert.False(t, store.locked)
+}
+
+// fakeTxStore records the transactions of the migrations.
+type fakeTxStore struct {
+	*fakeStore
+	setAppliedErr error
+	transactions  []string
+}
+
+func (s *fakeTxStore) SetApplied(ctx context.Context, migration Migration, applied bool) error {
+	if s.setAppliedErr != nil {
+		return s.setAppliedErr
+	}
+	return s.fakeStore.SetApplied(ctx, migration, applied)
+}
+
+func (s *fakeTxStore) Begin(ctx context.Context) error {
+	s.transactions = append(s.transactions, "begin")
+	return nil
+}
+
+func (s *fakeTxStore) Commit(ctx context.Context) error {
+	s.transactions = append(s.transactions, "commit")
+	return nil
+}
+
+func (s *fakeTxStore) Rollback(ctx context.Context) error {
+	s.transactions = append(s.transactions, "rollback")
+	return nil
+}
+
+func TestMigrator_Transactions(t *testing.T) {
+	migrator, err := FromFS(nil, fstest.MapFS{
+		"migrations/0001_create_a.up.sql":   testFiles["0001_create_a.up.sql"],
+		"migrations/0001_create_a.down.sql": testFiles["0001_create_a.down.sql"],
+		"migrations/0002_add_b.up.sql":      testFiles["0002_add_b.up.sql"],
+		"migrations/0002_add_b.down.sql":    testFiles["0002_add_b.down.sql"],
+	}, "migrations")
+	assert.NoError(t, err)
+	assert.Len(t, migrator.Migrations(), 2)
+	store := &fakeTxStore{fakeStore: &fakeStore{applied: map[uint64]bool{}}}
+	migrator = New(store, migrator.Migrations())
+	ctx := context.Background()
+
+	applied, err := migrator.Up(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, applied, 2)
+	assert.Equal(t, []string{"begin", "commit", "begin", "commit"}, store.transactions)
+
+	// A migration failing to be recorded is rolled back.
+	store.transactions = nil
+	store.setAppliedErr = fmt.Errorf("connection lost")
+	reverted, err := migrator.Down(ctx)
+	assert.Error(t, err)
+	assert.Nil(t, reverted)
+	assert.Equal(t, []string{"begin", "rollback"}, store.transactions)
+	assert.Equal(t, map[uint64]bool{1: true, 2: true}, store.applied)
+}
@@ -0,0 +1,365 @@
+// Package parquet reads and writes flat parquet files, where every column is
+// a required or optional primitive value, with the parquet-go library. Values
+// are handled as strings, the form the records of the aggregator use.
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/types"
+)
+
+const magic = "PAR1"
+
+// TimestampLayout is the layout timestamp columns are rendered with.
+const TimestampLayout = time.DateTime + ".000"
+
+// batchSize is the number of rows read from every column at once.
+const batchSize = 1024
+
+// Time units of the timestamp columns.
+const (
+	unitNone = iota
+	unitMillis
+	unitMicros
+	unitNanos
+)
+
+type column struct {
+	name          string
+	typ           parquet.Type
+	decimal       bool
+	scale         int32
+	date          bool
+	timestampUnit int
+	unsigned      bool
+}
+
+// Reader reads the rows of a parquet file. The library panicking on some
+// corrupted files, its panics are returned as errors.
+type Reader struct {
+	reader  *reader.ParquetReader
+	columns []*column
+	numRows int64
+	read    int64
+	rows    [][]string
+	row     int
+}
+
+// NewReader reads the metadata of the parquet file of the given size.
+func NewReader(r io.ReaderAt, size int64) (_ *Reader, err error) {
+	defer recoverError(&err)
+	if size < int64(2*len(magic)+4) {
+		return nil, fmt.Errorf("file too small to be parquet")
+	}
+	tail := make([]byte, 4+len(magic))
+	// A ReaderAt may return io.EOF along with the last bytes.
+	if n, err := r.ReadAt(tail, size-int64(len(tail))); n < len(tail) {
+		return nil, fmt.Errorf("failed to read footer: %w", err)
+	}
+	if string(tail[4:]) != magic {
+		return nil, fmt.Errorf("missing parquet magic number")
+	}
+	footerSize := int64(binary.LittleEndian.Uint32(tail))
+	if footerSize > size-int64(len(tail)+len(magic)) {
+		return nil, fmt.Errorf("invalid footer size %d", footerSize)
+	}
+	pr, err := reader.NewParquetColumnReader(newFile(r, size), 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read footer: %w", err)
+	}
+	columns, err := flatColumns(pr.Footer.GetSchema())
+	if err != nil {
+		return nil, err
+	}
+	// The library renames the columns of the footer to exported Go names.
+	for i, c := range columns {
+		c.name = pr.SchemaHandler.Infos[i+1].ExName
+	}
+	if err := checkRowGroups(pr.Footer, columns, size-int64(len(tail))-footerSize); err != nil {
+		return nil, err
+	}
+	return &Reader{
+		reader:  pr,
+		columns: columns,
+		numRows: pr.GetNumRows(),
+	}, nil
+}
+
+func flatColumns(schema []*parquet.SchemaElement) ([]*column, error) {
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("empty schema")
+	}
+	columns := make([]*column, 0, len(schema)-1)
+	for _, e := range schema[1:] {
+		if e.GetNumChildren() > 0 || !e.IsSetType() || e.GetRepetitionType() == parquet.FieldRepetitionType_REPEATED {
+			return nil, fmt.Errorf("nested or repeated column %q is not supported", e.GetName())
+		}
+		c := &column{name: e.GetName(), typ: e.GetType()}
+		if logical := e.GetLogicalType(); logical != nil {
+			switch {
+			case logical.IsSetDECIMAL():
+				c.decimal = true
+				c.scale = logical.DECIMAL.GetScale()
+			case logical.IsSetDATE():
+				c.date = true
+			case logical.IsSetTIMESTAMP():
+				c.timestampUnit = timeUnit(logical.TIMESTAMP.GetUnit())
+			case logical.IsSetINTEGER():
+				c.unsigned = !logical.INTEGER.GetIsSigned()
+			}
+		}
+		if e.IsSetConvertedType() {
+			switch e.GetConvertedType() {
+			case parquet.ConvertedType_DECIMAL:
+				c.decimal = true
+				c.scale = e.GetScale()
+			case parquet.ConvertedType_DATE:
+				c.date = true
+			case parquet.ConvertedType_TIMESTAMP_MILLIS:
+				c.timestampUnit = unitMillis
+			case parquet.ConvertedType_TIMESTAMP_MICROS:
+				c.timestampUnit = unitMicros
+			case parquet.ConvertedType_UINT_8, parquet.ConvertedType_UINT_16, parquet.ConvertedType_UINT_32, parquet.ConvertedType_UINT_64:
+				c.unsigned = true
+			}
+		}
+		if c.decimal && c.scale < 0 {
+			return nil, fmt.Errorf("invalid decimal scale %d of column %q", c.scale, c.name)
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// checkRowGroups checks the row counts and the column chunks of the footer
+// against the file, of which the column chunks take the first end bytes, for
+// the library not to read nor allocate past them.
+func checkRowGroups(footer *parquet.FileMetaData, columns []*column, end int64) error {
+	var numRows int64
+	for i, g := range footer.GetRowGroups() {
+		if g.GetNumRows() < 0 || g.GetNumRows() > footer.GetNumRows()-numRows {
+			return fmt.Errorf("invalid number of rows %d of row group %d", g.GetNumRows(), i)
+		}
+		numRows += g.GetNumRows()
+		if len(g.GetColumns()) != len(columns) {
+			return fmt.Errorf("row group %d has %d columns, schema has %d", i, len(g.GetColumns()), len(columns))
+		}
+		for j, chunk := range g.GetColumns() {
+			meta := chunk.GetMetaData()
+			if meta == nil {
+				return fmt.Errorf("missing column chunk metadata in row group %d", i)
+			}
+			if meta.GetType() != columns[j].typ {
+				return fmt.Errorf("column chunk of type %s, column %q is %s", meta.GetType(), columns[j].name, columns[j].typ)
+			}
+			offset := meta.GetDataPageOffset()
+			if meta.IsSetDictionaryPageOffset() {
+				offset = meta.GetDictionaryPageOffset()
+			}
+			if offset < int64(len(magic)) || meta.GetTotalCompressedSize() < 0 || meta.GetTotalCompressedSize() > end-offset {
+				return fmt.Errorf("column chunk of %d bytes at %d is out of the file", meta.GetTotalCompressedSize(), offset)
+			}
+			if meta.GetNumValues() < 0 {
+				return fmt.Errorf("invalid number of values %d", meta.GetNumValues())
+			}
+		}
+	}
+	if numRows != footer.GetNumRows() {
+		return fmt.Errorf("row groups have %d rows, file has %d", numRows, footer.GetNumRows())
+	}
+	return nil
+}
+
+func timeUnit(unit *parquet.TimeUnit) int {
+	switch {
+	case unit == nil:
+		return unitNone
+	case unit.IsSetMILLIS():
+		return unitMillis
+	case unit.IsSetMICROS():
+		return unitMicros
+	case unit.IsSetNANOS():
+		return unitNanos
+	}
+	return unitNone
+}
+
+// Columns returns the column names of the file.
+func (r *Reader) Columns() []string {
+	names := make([]string, len(r.columns))
+	for i, c := range r.columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// NumRows returns the number of rows of the file.
+func (r *Reader) NumRows() int64 {
+	return r.numRows
+}
+
+// Read returns the next row, with a value per column. Null values are empty
+// strings. It returns io.EOF after the last row.
+func (r *Reader) Read() ([]string, error) {
+	for r.row >= len(r.rows) {
+		if r.read >= r.numRows {
+			return nil, io.EOF
+		}
+		rows, err := r.readBatch(min(batchSize, r.numRows-r.read))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rows from %d: %w", r.read, err)
+		}
+		r.read += int64(len(rows))
+		r.rows = rows
+		r.row = 0
+	}
+	row := r.rows[r.row]
+	r.row++
+	return row, nil
+}
+
+// readBatch reads the next n rows. The library dropping the errors of the
+// pages it reads, a column that reads fewer values fails.
+func (r *Reader) readBatch(n int64) (_ [][]string, err error) {
+	defer recoverError(&err)
+	rows := make([][]string, n)
+	for j := range rows {
+		rows[j] = make([]string, len(r.columns))
+	}
+	for i, c := range r.columns {
+		values, _, _, err := r.reader.ReadColumnByIndex(int64(i), n)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", c.name, err)
+		}
+		if int64(len(values)) != n {
+			return nil, fmt.Errorf("column %q: read %d values, expected %d", c.name, len(values), n)
+		}
+		for j, v := range values {
+			rows[j][i], err = c.format(v)
+			if err != nil {
+				return nil, fmt.Errorf("column %q: %w", c.name, err)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// format renders a value read by the library, nil for null.
+func (c *column) format(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int32:
+		switch {
+		case c.decimal:
+			return formatDecimal(big.NewInt(int64(v)), c.scale), nil
+		case c.date:
+			return time.Unix(int64(v)*86400, 0).UTC().Format(time.DateOnly), nil
+		case c.unsigned:
+			return strconv.FormatUint(uint64(uint32(v)), 10), nil
+		}
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		switch {
+		case c.decimal:
+			return formatDecimal(big.NewInt(v), c.scale), nil
+		case c.timestampUnit == unitMillis:
+			return time.UnixMilli(v).UTC().Format(TimestampLayout), nil
+		case c.timestampUnit == unitMicros:
+			return time.UnixMicro(v).UTC().Format(TimestampLayout), nil
+		case c.timestampUnit == unitNanos:
+			return time.Unix(0, v).UTC().Format(TimestampLayout), nil
+		case c.unsigned:
+			return strconv.FormatUint(uint64(v), 10), nil
+		}
+		return strconv.FormatInt(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case string:
+		switch {
+		case c.typ == parquet.Type_INT96:
+			// Legacy timestamps: nanoseconds of the day and a julian day.
+			if len(v) != 12 {
+				return "", fmt.Errorf("invalid int96 of %d bytes", len(v))
+			}
+			return types.INT96ToTime(v).UTC().Format(TimestampLayout), nil
+		case c.decimal:
+			d := new(big.Int).SetBytes([]byte(v))
+			if len(v) > 0 && v[0]&0x80 != 0 {
+				d.Sub(d, new(big.Int).Lsh(big.NewInt(1), uint(len(v)*8)))
+			}
+			return formatDecimal(d, c.scale), nil
+		}
+		return v, nil
+	}
+	return "", fmt.Errorf("unexpected value of type %T", v)
+}
+
+func formatDecimal(v *big.Int, scale int32) string {
+	if scale <= 0 {
+		return v.String()
+	}
+	digits := new(big.Int).Abs(v).String()
+	for len(digits) <= int(scale) {
+		digits = "0" + digits
+	}
+	point := len(digits) - int(scale)
+	s := digits[:point] + "." + digits[point:]
+	if v.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// recoverError turns a panic of the library into the error returned.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("invalid parquet file: %v", r)
+	}
+}
+
+// file is the source of the library over a ReaderAt: every file opened reads
+// the same content at its own offset.
+type file struct {
+	*io.SectionReader
+	r    io.ReaderAt
+	size int64
+}
+
+func newFile(r io.ReaderAt, size int64) *file {
+	return &file{
+		SectionReader: io.NewSectionReader(r, 0, size),
+		r:             r,
+		size:          size,
+	}
+}
+
+func (f *file) Open(string) (source.ParquetFile, error) {
+	return newFile(f.r, f.size), nil
+}
+
+func (f *file) Create(string) (source.ParquetFile, error) {
+	return nil, errors.New("parquet file is read only")
+}
+
+func (f *file) Write([]byte) (int, error) {
+	return 0, errors.New("parquet file is read only")
+}
+
+func (f *file) Close() error {
+	return nil
+}
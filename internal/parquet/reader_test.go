@@ -0,0 +1,164 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xitongsys/parquet-go/parquet"
+)
+
+func TestReader(t *testing.T) {
+	file, err := os.Open("testdata/records.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewReader(file, info.Size())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ts", "event", "project_id", "props", "nums", "country"}, reader.Columns())
+	assert.Equal(t, int64(3), reader.NumRows())
+
+	expected := [][]string{
+		{"2024-04-15 02:15:07.167", "BUY_ITEMS", "4974", `{"currencySymbol":"SFL","chainId":"137"}`, `{"currencyValueDecimal":"0.61"}`, "DE"},
+		{"2024-04-15 02:36:37.134", "BUY_ITEMS", "", `{"currencySymbol":"SFL","chainId":"137"}`, `{"currencyValueDecimal":"2.36"}`, "DE"},
+		{"2024-04-15 02:52:32.507", "SELL_ITEMS", "1609", `{"currencySymbol":"MATIC","chainId":"137"}`, `{"currencyValueDecimal":"1.5"}`, "FR"},
+	}
+	for _, row := range expected {
+		actual, err := reader.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, row, actual)
+	}
+	_, err = reader.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReaderNotParquet(t *testing.T) {
+	file, err := os.Open("reader.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewReader(file, info.Size())
+	assert.ErrorContains(t, err, "missing parquet magic number")
+}
+
+func TestFormat(t *testing.T) {
+	testCases := []struct {
+		name   string
+		column column
+		value  any
+		result string
+	}{
+		{
+			name:   "decimal",
+			column: column{typ: parquet.Type_INT64, decimal: true, scale: 3},
+			value:  int64(12345),
+			result: "12.345",
+		},
+		{
+			name:   "negative fixed length decimal",
+			column: column{typ: parquet.Type_FIXED_LEN_BYTE_ARRAY, decimal: true, scale: 4},
+			value:  "\xff\xfb",
+			result: "-0.0005",
+		},
+		{
+			name:   "date",
+			column: column{typ: parquet.Type_INT32, date: true},
+			value:  int32(19828),
+			result: "2024-04-15",
+		},
+		{
+			name:   "unsigned",
+			column: column{typ: parquet.Type_INT32, unsigned: true},
+			value:  int32(-1),
+			result: "4294967295",
+		},
+		{
+			name:   "timestamp",
+			column: column{typ: parquet.Type_INT64, timestampUnit: unitMicros},
+			value:  int64(1713147307167000),
+			result: "2024-04-15 02:15:07.167",
+		},
+		{
+			name:   "double",
+			column: column{typ: parquet.Type_DOUBLE},
+			value:  1.5,
+			result: "1.5",
+		},
+		{
+			name:   "null",
+			column: column{typ: parquet.Type_BYTE_ARRAY},
+			value:  nil,
+			result: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := tc.column.format(tc.value)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.result, result)
+		})
+	}
+}
+
+// readAll reads every row of the file, and returns the first error.
+func readAll(data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	reader, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := reader.Read(); err != nil {
+			return err
+		}
+	}
+}
+
+func TestReaderCorrupted(t *testing.T) {
+	data, err := os.ReadFile("testdata/records.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The metadata is checked against the file before the library reads the
+	// pages, whose content it decodes unchecked.
+	footer := len(data) - 8 - int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	corrupted := make([]byte, len(data))
+	for i := footer; i < len(data); i++ {
+		for _, mask := range []byte{0x01, 0x02, 0x04, 0x08, 0x10, 0x20, 0x40, 0x80, 0xff} {
+			copy(corrupted, data)
+			corrupted[i] ^= mask
+			if err := readAll(corrupted); err != nil && strings.HasPrefix(err.Error(), "panic") {
+				t.Errorf("byte %d ^ %#x: %s", i, mask, err)
+			}
+		}
+	}
+	for size := range data {
+		if err := readAll(data[:size]); err == io.EOF {
+			t.Errorf("truncated at %d: no error", size)
+		} else if strings.HasPrefix(err.Error(), "panic") {
+			t.Errorf("truncated at %d: %s", size, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package parquet
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// ColumnType is the type of a column written.
+type ColumnType int
+
+const (
+	// String columns hold UTF-8 strings.
+	String ColumnType = iota
+	// Int64 columns hold signed integers.
+	Int64
+	// Uint64 columns hold unsigned integers.
+	Uint64
+	// Double columns hold floating point numbers.
+	Double
+	// Date columns hold dates, written "2006-01-02".
+	Date
+)
+
+// Column is a required column of a file written.
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// Writer writes rows to a parquet file, in snappy compressed pages. The file
+// is complete once Close returns.
+type Writer struct {
+	w       io.Writer
+	columns []Column
+	writer  *writer.CSVWriter
+}
+
+func NewWriter(w io.Writer, columns []Column) *Writer {
+	return &Writer{
+		w:       w,
+		columns: columns,
+	}
+}
+
+// Write adds a row, with a value per column in the form Reader returns them.
+func (w *Writer) Write(row []string) error {
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(w.columns))
+	}
+	values := make([]any, len(row))
+	for i, c := range w.columns {
+		v, err := c.value(row[i])
+		if err != nil {
+			return fmt.Errorf("column %q: %w", c.Name, err)
+		}
+		values[i] = v
+	}
+	if w.writer == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	return w.writer.Write(values)
+}
+
+// open writes the header of the file, once the first row is valid.
+func (w *Writer) open() error {
+	metadata := make([]string, len(w.columns))
+	for i, c := range w.columns {
+		metadata[i] = fmt.Sprintf("name=%s, %s, repetitiontype=REQUIRED", c.Name, c.schema())
+	}
+	pw, err := writer.NewCSVWriterFromWriter(metadata, w.w, 1)
+	if err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	w.writer = pw
+	return nil
+}
+
+// value converts a value to the type the library writes for the column.
+func (c Column) value(value string) (any, error) {
+	switch c.Type {
+	case Int64:
+		return strconv.ParseInt(value, 10, 64)
+	case Uint64:
+		v, err := strconv.ParseUint(value, 10, 64)
+		return int64(v), err
+	case Double:
+		return strconv.ParseFloat(value, 64)
+	case Date:
+		v, err := time.Parse(time.DateOnly, value)
+		return int32(v.Unix() / 86400), err
+	}
+	return value, nil
+}
+
+// schema returns the physical and the converted type of the column.
+func (c Column) schema() string {
+	switch c.Type {
+	case Int64:
+		return "type=INT64"
+	case Uint64:
+		return "type=INT64, convertedtype=UINT_64"
+	case Double:
+		return "type=DOUBLE"
+	case Date:
+		return "type=INT32, convertedtype=DATE"
+	}
+	return "type=BYTE_ARRAY, convertedtype=UTF8"
+}
+
+// Close writes the rest of the file. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.writer == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	return w.writer.WriteStop()
+}
@@ -0,0 +1,43 @@
+package parquet
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriter(t *testing.T) {
+	rows := [][]string{
+		{"2024-04-15", "4974", "-3", "42", "31.03050136904114", `{"country":"FR"}`},
+		{"2024-04-16", "1609", "7", "18446744073709551615", "0.5", "{}"},
+	}
+	var buf bytes.Buffer
+	writer := NewWriter(&buf, []Column{
+		{Name: "date", Type: Date},
+		{Name: "project_id", Type: String},
+		{Name: "delta", Type: Int64},
+		{Name: "num_transactions", Type: Uint64},
+		{Name: "total_volume_usd", Type: Double},
+		{Name: "dimensions", Type: String},
+	})
+	for _, row := range rows {
+		assert.NoError(t, writer.Write(row))
+	}
+	assert.Error(t, writer.Write([]string{"2024-04-16"}))
+	assert.Error(t, writer.Write([]string{"2024-04-16", "1609", "seven", "0", "0", "{}"}))
+	assert.NoError(t, writer.Close())
+
+	reader, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"date", "project_id", "delta", "num_transactions", "total_volume_usd", "dimensions"}, reader.Columns())
+	assert.Equal(t, int64(2), reader.NumRows())
+	for _, row := range rows {
+		actual, err := reader.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, row, actual)
+	}
+	_, err = reader.Read()
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParsedRecord is the fields of a record its stats are made of.
+type ParsedRecord struct {
+	Date      time.Time
+	ProjectID uint64
+	Symbol    string
+	Amount    float64
+}
+
+// RecordError is the failure to aggregate a record, for a reason reported in
+// the metrics, e.g. "timestamp".
+type RecordError struct {
+	Reason string
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// ParseRecord parses the fields a record is priced and aggregated with. It
+// is shared by the pipeline and the services validating the records they
+// accept, for both to agree.
+func ParseRecord(r Record) (ParsedRecord, error) {
+	date, err := time.Parse(time.DateTime+".000", r.Timestamp)
+	if err != nil {
+		return ParsedRecord{}, &RecordError{"timestamp", fmt.Errorf("invalid ts %q, expected YYYY-MM-DD hh:mm:ss.sss", r.Timestamp)}
+	}
+	projectID, err := strconv.ParseUint(r.ProjectID, 10, 64)
+	if err != nil {
+		return ParsedRecord{}, &RecordError{"project_id", fmt.Errorf("invalid project_id %q", r.ProjectID)}
+	}
+	var props struct {
+		CurrencySymbol string `json:"currencySymbol"`
+	}
+	if err := json.Unmarshal([]byte(r.Props), &props); err != nil {
+		return ParsedRecord{}, &RecordError{"props", fmt.Errorf("invalid props: %w", err)}
+	}
+	if props.CurrencySymbol == "" {
+		return ParsedRecord{}, &RecordError{"props", errors.New("missing props.currencySymbol")}
+	}
+	var nums struct {
+		CurrencyValueDecimal string `json:"currencyValueDecimal"`
+	}
+	if err := json.Unmarshal([]byte(r.Nums), &nums); err != nil {
+		return ParsedRecord{}, &RecordError{"nums", fmt.Errorf("invalid nums: %w", err)}
+	}
+	amount, err := strconv.ParseFloat(nums.CurrencyValueDecimal, 64)
+	if err != nil {
+		return ParsedRecord{}, &RecordError{"amount", fmt.Errorf("invalid nums.currencyValueDecimal %q", nums.CurrencyValueDecimal)}
+	}
+	return ParsedRecord{Date: date, ProjectID: projectID, Symbol: props.CurrencySymbol, Amount: amount}, nil
+}
+
+// Field returns the value of a source field of the record. The name is either
+// a column name, or a column holding a JSON object followed by a dotted path
+// into that object, e.g. "props.chainId".
+func (r Record) Field(name string) (string, bool) {
+	if value, ok := r.column(name); ok {
+		return value, true
+	}
+	column, path, found := strings.Cut(name, ".")
+	if !found {
+		return "", false
+	}
+	raw, ok := r.column(column)
+	if !ok {
+		return "", false
+	}
+	return JSONPath(raw, path)
+}
+
+func (r Record) column(name string) (string, bool) {
+	if value, ok := r.Columns[name]; ok {
+		return value, true
+	}
+	switch name {
+	case "ts":
+		return r.Timestamp, true
+	case "event":
+		return r.Event, true
+	case "project_id":
+		return r.ProjectID, true
+	case "props":
+		return r.Props, true
+	case "nums":
+		return r.Nums, true
+	}
+	return "", false
+}
+
+// JSONPath walks a dotted path into a JSON document and returns the value
+// found there. Strings are returned as is, other values in their JSON form.
+func JSONPath(raw, path string) (string, bool) {
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return "", false
+	}
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if value, ok = object[key]; !ok {
+			return "", false
+		}
+	}
+	return JSONValue(value)
+}
+
+// JSONValue renders a decoded JSON value as a field value: strings as is,
+// other values in their JSON form and null as an empty string.
+func JSONValue(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case nil:
+		return "", true
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+// Row returns the columns of the record with its fields under their default
+// names. It is the form records are exchanged in between services.
+func (r Record) Row() map[string]string {
+	row := make(map[string]string, len(r.Columns)+5)
+	for column, value := range r.Columns {
+		row[column] = value
+	}
+	row["ts"] = r.Timestamp
+	row["event"] = r.Event
+	row["project_id"] = r.ProjectID
+	row["props"] = r.Props
+	row["nums"] = r.Nums
+	if r.Source != "" {
+		row["source"] = r.Source
+	}
+	return row
+}
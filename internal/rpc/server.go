@@ -0,0 +1,265 @@
+// Package rpc serves the gRPC API of the aggregator: the ingestion of records
+// into the pipeline, and the queries of the stored stats.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/services"
+	"github.com/lat1992/blockchain-data-aggregator/proto/aggregatorpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Option configures the ingestion of the records.
+type Option func(*Server)
+
+// WithStreamLimits bounds the streams ingested to maxRecords records, and
+// the wait for their next record to idleTimeout.
+func WithStreamLimits(maxRecords int, idleTimeout time.Duration) Option {
+	return func(s *Server) {
+		s.maxRecords = maxRecords
+		s.idleTimeout = idleTimeout
+	}
+}
+
+// Server implements the Aggregator service.
+type Server struct {
+	aggregatorpb.UnimplementedAggregatorServer
+	stats    externals.StatsReader
+	records  *recordStream
+	pipeline *services.Pipeline
+	// mutex serializes the runs of the pipeline, and so the checks of the
+	// trades of a stream against the ones stored by the others.
+	mutex       sync.Mutex
+	maxRecords  int
+	idleTimeout time.Duration
+}
+
+// NewServer creates the server of the API. newPipeline creates the pipeline
+// of the ingested records, read by gNum goroutines. Queries are unimplemented
+// when stats is nil.
+func NewServer(stats externals.StatsReader, newPipeline func(externals.DataGetterService) *services.Pipeline, gNum int, opts ...Option) *Server {
+	records := &recordStream{
+		recordChannel: make(chan internal.Record, gNum*2),
+		endChannel:    make(chan bool),
+		goroutineNum:  gNum,
+	}
+	s := &Server{
+		stats:    stats,
+		records:  records,
+		pipeline: newPipeline(records),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// IngestRecords receives the records of the stream, then runs the pipeline
+// on them, and responds once their stats are stored. The streams are
+// received concurrently, and stored one after another. A stream that fails,
+// holds too many records, or waits too long for its next one, stores
+// nothing.
+func (s *Server) IngestRecords(stream aggregatorpb.Aggregator_IngestRecordsServer) error {
+	records, err := s.receive(stream)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.records.records = records
+	err = s.pipeline.Run()
+	s.records.records = nil
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to store the records: %v", err)
+	}
+	return stream.SendAndClose(&aggregatorpb.IngestRecordsResponse{Records: uint64(len(records))})
+}
+
+// receive reads the records of the stream until the client closes it.
+func (s *Server) receive(stream aggregatorpb.Aggregator_IngestRecordsServer) ([]internal.Record, error) {
+	type received struct {
+		record *aggregatorpb.Record
+		err    error
+	}
+	ctx := stream.Context()
+	next := make(chan received)
+	go func() {
+		// Returning from the handler cancels ctx, and so the pending Recv.
+		for {
+			record, err := stream.Recv()
+			select {
+			case next <- received{record, err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var records []internal.Record
+	var idle <-chan time.Time
+	var timer *time.Timer
+	if s.idleTimeout > 0 {
+		timer = time.NewTimer(s.idleTimeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+	for {
+		var r received
+		select {
+		case r = <-next:
+		case <-idle:
+			return nil, status.Errorf(codes.DeadlineExceeded, "no record received for %s", s.idleTimeout)
+		}
+		if timer != nil {
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(s.idleTimeout)
+		}
+		if errors.Is(r.err, io.EOF) {
+			return records, nil
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		if s.maxRecords > 0 && len(records) == s.maxRecords {
+			return nil, status.Errorf(codes.ResourceExhausted, "stream of more than %d records, split it", s.maxRecords)
+		}
+		source := r.record.GetSource()
+		if source == "" {
+			source = "grpc"
+		}
+		records = append(records, internal.Record{
+			Timestamp: r.record.GetTimestamp(),
+			Event:     r.record.GetEvent(),
+			ProjectID: r.record.GetProjectId(),
+			Props:     r.record.GetProps(),
+			Nums:      r.record.GetNums(),
+			Columns:   r.record.GetColumns(),
+			Source:    source,
+		})
+	}
+}
+
+func (s *Server) QueryStats(ctx context.Context, req *aggregatorpb.QueryStatsRequest) (*aggregatorpb.QueryStatsResponse, error) {
+	if s.stats == nil {
+		return nil, status.Error(codes.Unimplemented, "stats queries need the clickhouse database")
+	}
+	query, err := statsQuery(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// One more stat than the limit tells whether there is a next page.
+	query.Limit++
+	stats, err := s.stats.MarketStats(ctx, query)
+	query.Limit--
+	if err != nil {
+		slog.Error("failed to query stats", "err", err)
+		return nil, status.Error(codes.Internal, "failed to query stats")
+	}
+
+	resp := &aggregatorpb.QueryStatsResponse{}
+	if len(stats) > query.Limit {
+		stats = stats[:query.Limit]
+		resp.NextOffset = int32(query.Offset + query.Limit)
+	}
+	for _, stat := range stats {
+		resp.Stats = append(resp.Stats, &aggregatorpb.MarketStat{
+			Date:            stat.Date.Format(time.DateOnly),
+			ProjectId:       stat.ProjectID,
+			NumTransactions: stat.NumTx,
+			TotalVolumeUsd:  stat.TotalVolume,
+			Dimensions:      stat.Dimensions,
+		})
+	}
+	return resp, nil
+}
+
+// statsQuery reads a request, the dates defaulting to the last 30 days.
+func statsQuery(req *aggregatorpb.QueryStatsRequest) (internal.StatsQuery, error) {
+	query := internal.StatsQuery{
+		ProjectID: req.GetProjectId(),
+		To:        time.Now().UTC().Truncate(24 * time.Hour),
+		Limit:     defaultLimit,
+		Offset:    int(req.GetOffset()),
+	}
+	var err error
+	if req.GetTo() != "" {
+		if query.To, err = time.Parse(time.DateOnly, req.GetTo()); err != nil {
+			return query, fmt.Errorf("invalid to date %q", req.GetTo())
+		}
+	}
+	query.From = query.To.AddDate(0, 0, -29)
+	if req.GetFrom() != "" {
+		if query.From, err = time.Parse(time.DateOnly, req.GetFrom()); err != nil {
+			return query, fmt.Errorf("invalid from date %q", req.GetFrom())
+		}
+	}
+	if query.From.After(query.To) {
+		return query, errors.New("from is after to")
+	}
+	if req.GetLimit() != 0 {
+		query.Limit = int(req.GetLimit())
+	}
+	if query.Limit < 1 || query.Limit > maxLimit {
+		return query, fmt.Errorf("invalid limit %d, expected 1 to %d", query.Limit, maxLimit)
+	}
+	if query.Offset < 0 {
+		return query, fmt.Errorf("invalid offset %d", query.Offset)
+	}
+	return query, nil
+}
+
+// recordStream is the data getter of the pipeline, sending the records of
+// the stream being stored.
+type recordStream struct {
+	records       []internal.Record
+	recordChannel chan internal.Record
+	endChannel    chan bool
+	goroutineNum  int
+}
+
+// ReadDataFromFiles sends the records of the stream, until ctx is done.
+func (r *recordStream) ReadDataFromFiles(ctx context.Context) error {
+	defer func() {
+		for i := 0; i < r.goroutineNum; i++ {
+			r.endChannel <- true
+		}
+	}()
+
+	for _, record := range r.records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.recordChannel <- record
+	}
+	return nil
+}
+
+func (r *recordStream) Channel() chan internal.Record {
+	return r.recordChannel
+}
+
+func (r *recordStream) EndChannel() chan bool {
+	return r.endChannel
+}
@@ -0,0 +1,249 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/services"
+	"github.com/lat1992/blockchain-data-aggregator/mocks"
+	"github.com/lat1992/blockchain-data-aggregator/proto/aggregatorpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/test-go/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// newClient serves the server in memory and returns a client of it.
+func newClient(t *testing.T, server *Server) aggregatorpb.AggregatorClient {
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	aggregatorpb.RegisterAggregatorServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return aggregatorpb.NewAggregatorClient(conn)
+}
+
+func TestServer_IngestRecords(t *testing.T) {
+	mockCG := new(mocks.CoinGeckoAPI)
+	mockDB := new(mocks.Database)
+	mockCG.On("InitTokenIDs").Return(nil)
+	mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+	// Each run tags its stats with its own id.
+	runIDs := make(map[string]bool)
+	mockDB.On("InsertMarket", mock.MatchedBy(func(stats map[string]internal.MarketStat) bool {
+		stat := stats["01-01-2024-1234"]
+		if len(stats) != 1 || stat.RunID == "" || runIDs[stat.RunID] {
+			return false
+		}
+		runIDs[stat.RunID] = true
+		stat.RunID = ""
+		return assert.ObjectsAreEqual(internal.MarketStat{Date: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), ProjectID: 1234, NumTx: 2, TotalVolume: 100000, Dimensions: map[string]string{}}, stat)
+	})).Return(nil).Twice()
+
+	server := NewServer(nil, func(dg externals.DataGetterService) *services.Pipeline {
+		return services.NewPipeline(mockCG, dg, mockDB, 2)
+	}, 2)
+	client := newClient(t, server)
+
+	// The pipeline is run again for every stream.
+	for i := 0; i < 2; i++ {
+		stream, err := client.IngestRecords(context.Background())
+		assert.NoError(t, err)
+		for _, value := range []string{"1.5", "0.5"} {
+			assert.NoError(t, stream.Send(&aggregatorpb.Record{
+				Timestamp: "2024-01-01 12:00:00.000",
+				Event:     "BUY_ITEMS",
+				ProjectId: "1234",
+				Props:     `{"currencySymbol":"BTC"}`,
+				Nums:      fmt.Sprintf(`{"currencyValueDecimal":"%s"}`, value),
+			}))
+		}
+		resp, err := stream.CloseAndRecv()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(2), resp.GetRecords())
+	}
+
+	mockCG.AssertExpectations(t)
+	mockDB.AssertExpectations(t)
+}
+
+func TestServer_IngestRecordsError(t *testing.T) {
+	mockCG := new(mocks.CoinGeckoAPI)
+	mockDB := new(mocks.Database)
+	mockCG.On("InitTokenIDs").Return(nil)
+	mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+	mockDB.On("InsertMarket", mock.Anything).Return(fmt.Errorf("connection refused"))
+
+	server := NewServer(nil, func(dg externals.DataGetterService) *services.Pipeline {
+		return services.NewPipeline(mockCG, dg, mockDB, 1)
+	}, 1)
+	stream, err := newClient(t, server).IngestRecords(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(&aggregatorpb.Record{
+		Timestamp: "2024-01-01 12:00:00.000",
+		ProjectId: "1234",
+		Props:     `{"currencySymbol":"BTC"}`,
+		Nums:      `{"currencyValueDecimal":"1.5"}`,
+	}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestServer_IngestRecordsStreamFailure(t *testing.T) {
+	testCases := []struct {
+		name     string
+		records  int
+		cancel   bool
+		wantCode codes.Code
+	}{
+		{name: "too many records", records: 3, wantCode: codes.ResourceExhausted},
+		{name: "idle stream", records: 1, wantCode: codes.DeadlineExceeded},
+		{name: "canceled stream", records: 1, cancel: true, wantCode: codes.Canceled},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCG := new(mocks.CoinGeckoAPI)
+			mockDB := new(mocks.Database)
+			mockCG.On("InitTokenIDs").Return(nil)
+
+			server := NewServer(nil, func(dg externals.DataGetterService) *services.Pipeline {
+				return services.NewPipeline(mockCG, dg, mockDB, 1)
+			}, 1, WithStreamLimits(2, 50*time.Millisecond))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream, err := newClient(t, server).IngestRecords(ctx)
+			assert.NoError(t, err)
+			for i := 0; i < tc.records; i++ {
+				assert.NoError(t, stream.Send(&aggregatorpb.Record{
+					Timestamp: "2024-01-01 12:00:00.000",
+					ProjectId: "1234",
+					Props:     `{"currencySymbol":"BTC"}`,
+					Nums:      `{"currencyValueDecimal":"1.5"}`,
+				}))
+			}
+			if tc.cancel {
+				cancel()
+			}
+			// The stream is left open, the server ending it.
+			err = stream.RecvMsg(new(aggregatorpb.IngestRecordsResponse))
+			assert.Equal(t, tc.wantCode, status.Code(err))
+
+			// The records received before the failure are not stored.
+			mockDB.AssertNotCalled(t, "InsertMarket", mock.Anything)
+		})
+	}
+}
+
+func TestServer_QueryStats(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d
+	}
+	stats := []internal.MarketStat{
+		{Date: day("2024-04-01"), ProjectID: 4974, NumTx: 3, TotalVolume: 1.5, Dimensions: map[string]string{"country": "DE"}},
+		{Date: day("2024-04-01"), ProjectID: 4974, NumTx: 1, TotalVolume: 2, Dimensions: map[string]string{"country": "FR"}},
+		{Date: day("2024-04-02"), ProjectID: 4974, NumTx: 2, TotalVolume: 0.5},
+	}
+	testCases := []struct {
+		name     string
+		req      *aggregatorpb.QueryStatsRequest
+		query    *internal.StatsQuery
+		results  []internal.MarketStat
+		err      error
+		wantCode codes.Code
+		want     *aggregatorpb.QueryStatsResponse
+	}{
+		{
+			name:    "next page",
+			req:     &aggregatorpb.QueryStatsRequest{ProjectId: 4974, From: "2024-04-01", To: "2024-04-30", Limit: 2},
+			query:   &internal.StatsQuery{ProjectID: 4974, From: day("2024-04-01"), To: day("2024-04-30"), Limit: 3},
+			results: stats,
+			want: &aggregatorpb.QueryStatsResponse{
+				Stats: []*aggregatorpb.MarketStat{
+					{Date: "2024-04-01", ProjectId: 4974, NumTransactions: 3, TotalVolumeUsd: 1.5, Dimensions: map[string]string{"country": "DE"}},
+					{Date: "2024-04-01", ProjectId: 4974, NumTransactions: 1, TotalVolumeUsd: 2, Dimensions: map[string]string{"country": "FR"}},
+				},
+				NextOffset: 2,
+			},
+		},
+		{
+			name:    "last page",
+			req:     &aggregatorpb.QueryStatsRequest{From: "2024-04-01", To: "2024-04-30", Offset: 2},
+			query:   &internal.StatsQuery{From: day("2024-04-01"), To: day("2024-04-30"), Limit: 101, Offset: 2},
+			results: stats[2:],
+			want: &aggregatorpb.QueryStatsResponse{
+				Stats: []*aggregatorpb.MarketStat{
+					{Date: "2024-04-02", ProjectId: 4974, NumTransactions: 2, TotalVolumeUsd: 0.5},
+				},
+			},
+		},
+		{
+			name:     "invalid date",
+			req:      &aggregatorpb.QueryStatsRequest{From: "01-04-2024"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "invalid limit",
+			req:      &aggregatorpb.QueryStatsRequest{Limit: 5000},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "database error",
+			req:      &aggregatorpb.QueryStatsRequest{From: "2024-04-01", To: "2024-04-30"},
+			query:    &internal.StatsQuery{From: day("2024-04-01"), To: day("2024-04-30"), Limit: 101},
+			err:      fmt.Errorf("connection refused"),
+			wantCode: codes.Internal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockStats := new(mocks.StatsReader)
+			if tc.query != nil {
+				mockStats.On("MarketStats", mock.Anything, *tc.query).Return(tc.results, tc.err)
+			}
+			mockCG := new(mocks.CoinGeckoAPI)
+			mockCG.On("InitTokenIDs").Return(nil)
+			server := NewServer(mockStats, func(dg externals.DataGetterService) *services.Pipeline {
+				return services.NewPipeline(mockCG, dg, new(mocks.Database), 1)
+			}, 1)
+
+			resp, err := newClient(t, server).QueryStats(context.Background(), tc.req)
+
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			if tc.want != nil {
+				assert.True(t, proto.Equal(tc.want, resp), "unexpected response %v", resp)
+			}
+			mockStats.AssertExpectations(t)
+		})
+	}
+}
+
+func TestServer_QueryStatsUnimplemented(t *testing.T) {
+	mockCG := new(mocks.CoinGeckoAPI)
+	mockCG.On("InitTokenIDs").Return(nil)
+	server := NewServer(nil, func(dg externals.DataGetterService) *services.Pipeline {
+		return services.NewPipeline(mockCG, dg, new(mocks.Database), 1)
+	}, 1)
+
+	_, err := newClient(t, server).QueryStats(context.Background(), &aggregatorpb.QueryStatsRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+// commitAttempts is the number of times a backfill is committed before it is
+// given up, its staging kept in between.
+const commitAttempts = 3
+
+// Backfill recomputes the stats of a range of days from their records, read
+// again, e.g. once a price mapping is fixed, and replaces the stored stats of
+// the range with them.
+type Backfill struct {
+	coingecko    externals.CoinGeckoAPI
+	dataGetter   externals.DataGetterService
+	database     externals.BackfillDatabase
+	r            internal.BackfillRange
+	goroutineNum int
+	opts         []Option
+	retryDelay   time.Duration
+}
+
+// NewBackfill creates the backfill of the range r. The options are those of
+// its pipeline; deduplication must not check the trades against the seen set
+// of the database, which holds the trades being recomputed.
+func NewBackfill(cg externals.CoinGeckoAPI, dg externals.DataGetterService, db externals.BackfillDatabase, r internal.BackfillRange, gNum int, opts ...Option) *Backfill {
+	return &Backfill{
+		coingecko:    cg,
+		dataGetter:   dg,
+		database:     db,
+		r:            r,
+		goroutineNum: gNum,
+		opts:         opts,
+		retryDelay:   5 * time.Second,
+	}
+}
+
+// Run aggregates the records of the range into the staging database of the
+// backfill, and commits it once the pipeline succeeded. The stored stats are
+// left as they are when it failed, or was a dry run. A failed commit is
+// retried; once given up, the range may be partly replaced, and is to be
+// backfilled again.
+func (b *Backfill) Run() error {
+	staged, err := b.database.Backfill(b.r)
+	if err != nil {
+		return fmt.Errorf("failed to stage backfill: %w", err)
+	}
+	opts := append([]Option{WithRange(b.r)}, b.opts...)
+	pipeline := NewPipeline(b.coingecko, b.dataGetter, staged, b.goroutineNum, opts...)
+	if err := pipeline.Run(); err != nil || pipeline.dryRun {
+		if err := staged.Rollback(); err != nil {
+			slog.Error("failed to roll back backfill", "err", err)
+		}
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err := staged.Commit()
+		if err == nil {
+			break
+		}
+		slog.Error("failed to commit backfill", "attempt", attempt, "err", err)
+		if attempt == commitAttempts {
+			if err := staged.Rollback(); err != nil {
+				slog.Error("failed to roll back backfill", "err", err)
+			}
+			return fmt.Errorf("failed to commit backfill, its range may be partly replaced: %w", err)
+		}
+		time.Sleep(b.retryDelay)
+	}
+	slog.Info("backfill committed", "from", b.r.From.Format(time.DateOnly), "to", b.r.To.Format(time.DateOnly), "project_id", b.r.ProjectID)
+	return nil
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/test-go/testify/mock"
+)
+
+func TestBackfill_Run(t *testing.T) {
+	testCases := []struct {
+		name       string
+		insertErr  error
+		commitErrs []error
+		wantErr    bool
+		commits    int
+		rolledBack bool
+	}{
+		{name: "committed", commits: 1},
+		{name: "rolled back", insertErr: fmt.Errorf("connection refused"), wantErr: true, rolledBack: true},
+		{name: "commit retried", commitErrs: []error{fmt.Errorf("connection refused")}, commits: 2},
+		{name: "commit failed", commitErrs: []error{fmt.Errorf("connection refused"), fmt.Errorf("connection refused"), fmt.Errorf("connection refused")},
+			wantErr: true, commits: 3, rolledBack: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCG := new(mocks.CoinGeckoAPI)
+			mockDG := new(mocks.DataGetterService)
+			mockDB := new(mocks.BackfillDatabase)
+			mockStaged := new(mocks.Backfill)
+
+			record := func(timestamp, projectID string) internal.Record {
+				return internal.Record{
+					Timestamp: timestamp,
+					ProjectID: projectID,
+					Props:     `{"currencySymbol":"BTC"}`,
+					Nums:      `{"currencyValueDecimal":"1.5"}`,
+				}
+			}
+			records := []internal.Record{
+				record("2024-01-01 12:00:00.000", "1234"),
+				record("2024-01-02 23:59:59.999", "1234"),
+				record("2024-01-03 00:00:00.000", "1234"), // after the range
+				record("2024-01-01 12:00:00.000", "99"),   // another project
+			}
+			recordChan := make(chan internal.Record, len(records))
+			endChan := make(chan bool, 1)
+			for _, record := range records {
+				recordChan <- record
+			}
+			endChan <- true
+
+			r := internal.BackfillRange{From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), ProjectID: 1234}
+			mockCG.On("InitTokenIDs").Return(nil)
+			mockCG.On("GetPrice", mock.Anything, "BTC", mock.Anything).Return(50000.0, nil)
+			mockDG.On("ReadDataFromFiles", mock.Anything).Return(nil)
+			mockDG.On("Channel").Return(recordChan)
+			mockDG.On("EndChannel").Return(endChan)
+			mockDB.On("Backfill", r).Return(mockStaged, nil)
+			mockStaged.On("InsertMarket", mock.Anything).Return(tc.insertErr)
+			mockStaged.On("InsertRun", mock.Anything).Return(nil)
+			for _, err := range tc.commitErrs {
+				mockStaged.On("Commit").Return(err).Once()
+			}
+			mockStaged.On("Commit").Return(nil)
+			mockStaged.On("Rollback").Return(nil)
+
+			backfill := NewBackfill(mockCG, mockDG, mockDB, r, 1)
+			backfill.retryDelay = 0
+			err := backfill.Run()
+			assert.Equal(t, tc.wantErr, err != nil)
+
+			mockCG.AssertNumberOfCalls(t, "GetPrice", 2)
+			stats := mockStaged.Calls[0].Arguments.Get(0).(map[string]internal.MarketStat)
+			assert.Len(t, stats, 2)
+			assert.Equal(t, uint64(1), stats["01-01-2024-1234"].NumTx)
+			assert.Equal(t, uint64(1), stats["02-01-2024-1234"].NumTx)
+			mockStaged.AssertNumberOfCalls(t, "Commit", tc.commits)
+			if tc.rolledBack {
+				mockStaged.AssertCalled(t, "Rollback")
+			} else {
+				mockStaged.AssertNotCalled(t, "Rollback")
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+// dedupKey returns the key identifying the trade of a record, "chainId-
+// txnHash-tokenId" from its props, or "" when the record has no transaction
+// hash.
+func dedupKey(record internal.Record) string {
+	txnHash, _ := record.Field("props.txnHash")
+	if txnHash == "" {
+		return ""
+	}
+	chainID, _ := record.Field("props.chainId")
+	tokenID, _ := record.Field("props.tokenId")
+	return chainID + "-" + strings.ToLower(txnHash) + "-" + tokenID
+}
+
+// dedupBatchSize is the number of trades checked against the store at once.
+const dedupBatchSize = 500
+
+// queuedRecord is a record waiting for its trade to be checked against the
+// store.
+type queuedRecord struct {
+	record internal.Record
+	key    string
+}
+
+// dedupSet holds the trades of a run, and queues the new ones to be checked
+// against the store, for the ones of earlier runs, in batches.
+type dedupSet struct {
+	mutex   sync.Mutex
+	store   externals.SeenStore
+	keys    map[string]bool
+	pending []string
+	queue   []queuedRecord
+}
+
+func newDedupSet(store externals.SeenStore) *dedupSet {
+	return &dedupSet{
+		store: store,
+		keys:  make(map[string]bool),
+	}
+}
+
+// claim reports whether the trade is new to the run and reserves it.
+func (d *dedupSet) claim(key string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.keys[key] {
+		return false
+	}
+	d.keys[key] = true
+	return true
+}
+
+// enqueue queues a record claimed to be checked against the store, and
+// returns the queue once it holds a batch.
+func (d *dedupSet) enqueue(record internal.Record, key string) []queuedRecord {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.queue = append(d.queue, queuedRecord{record: record, key: key})
+	if len(d.queue) < dedupBatchSize {
+		return nil
+	}
+	queue := d.queue
+	d.queue = nil
+	return queue
+}
+
+// dequeue returns the records queued so far.
+func (d *dedupSet) dequeue() []queuedRecord {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	queue := d.queue
+	d.queue = nil
+	return queue
+}
+
+// unseen returns the queued records whose trades are not in the store.
+func (d *dedupSet) unseen(queue []queuedRecord) ([]queuedRecord, error) {
+	keys := make([]string, len(queue))
+	for i, q := range queue {
+		keys[i] = q.key
+	}
+	seen, err := d.store.Seen(keys)
+	if err != nil {
+		return nil, err
+	}
+	unseen := queue[:0]
+	for _, q := range queue {
+		if !seen[q.key] {
+			unseen = append(unseen, q)
+		}
+	}
+	return unseen, nil
+}
+
+// release forgets a trade claimed by a record that was not aggregated.
+func (d *dedupSet) release(key string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.keys, key)
+}
+
+// accept records a trade aggregated by the run.
+func (d *dedupSet) accept(key string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.pending = append(d.pending, key)
+}
+
+// take returns the trades aggregated since the last call, keeping the ones of
+// the run claimed.
+func (d *dedupSet) take() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	pending := d.pending
+	d.pending = nil
+	return pending
+}
+
+// reset starts a new run and returns the trades aggregated by the last one.
+func (d *dedupSet) reset() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	pending := d.pending
+	d.keys = make(map[string]bool)
+	d.pending = nil
+	d.queue = nil
+	return pending
+}
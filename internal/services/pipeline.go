@@ -1,28 +1,143 @@
 package services
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lat1992/blockchain-data-aggregator/externals"
 	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/metrics"
+	"github.com/lat1992/blockchain-data-aggregator/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/lat1992/blockchain-data-aggregator/internal/services")
+
 type Pipeline struct {
 	coingecko        externals.CoinGeckoAPI
 	dataGetter       externals.DataGetterService
 	clickhosue       externals.Database
+	sinks            []externals.Database
 	goroutineNum     int
+	dimensions       []string
+	verifier         externals.Verifier
+	dedup            *dedupSet
+	duplicates       atomic.Uint64
 	marketStatsCache *marketStatCache
+	transactions     *transactionCache
+	verifications    *verificationCache
+	flushSize        int
+	flushInterval    time.Duration
+	// flushLock is held by the workers while they cache a record, and by a
+	// flush while it takes the caches, so that a flush never takes half of
+	// a record.
+	flushLock sync.RWMutex
+	// storeLock serializes the stores, and guards flushErr.
+	storeLock sync.Mutex
+	flushErr  error
+	buffered  atomic.Int64
+	// progress is the unix time in nanoseconds of the last record processed
+	// or run ended.
+	progress   atomic.Int64
+	run        *runLog
+	configHash string
+	dates      *internal.BackfillRange
+	dryRun     bool
+	// cancel stops the current run, with the failure to store its records or
+	// to check its trades as cause.
+	cancel context.CancelCauseFunc
+}
+
+type Option func(*Pipeline)
+
+// WithDimensions groups the market stats by the given source fields on top of
+// project and date. A dimension is a column name or a dotted path into a JSON
+// column, e.g. "country" or "props.chainId".
+func WithDimensions(dimensions []string) Option {
+	return func(p *Pipeline) {
+		p.dimensions = dimensions
+	}
+}
+
+// WithVerifier checks every record aggregated against its chain. The results
+// are stored when the database supports it. A verifier caching its lookups
+// is reset at the start of every run.
+func WithVerifier(verifier externals.Verifier) Option {
+	return func(p *Pipeline) {
+		p.verifier = verifier
+	}
+}
+
+// WithDeduplication counts a trade, identified by the chainId, txnHash and
+// tokenId of its props, only once. Trades are deduplicated within a run and,
+// when store is not nil, across runs: the new trades are checked against the
+// store in batches, and aggregated once checked.
+func WithDeduplication(store externals.SeenStore) Option {
+	return func(p *Pipeline) {
+		p.dedup = newDedupSet(store)
+	}
+}
+
+// WithFlush stores the records aggregated so far every size records and every
+// interval, rather than once at the end of a run, so that memory does not
+// grow with the run. Partial stats of a project and date are summed by the
+// market_stats table engine. The data getter is still committed at the end
+// of the run only: a failed run is read again, and deduplication keeps the
+// trades already flushed from being counted twice. A pipeline flushing
+// without deduplication fails to run. A failed flush stops the run.
+func WithFlush(size int, interval time.Duration) Option {
+	return func(p *Pipeline) {
+		p.flushSize = size
+		p.flushInterval = interval
+	}
+}
+
+// WithSinks writes the stats to the sinks as well as to the database, e.g. to
+// archive them to files. The sinks are written first, so that a failing sink
+// fails the store before the database is written: a sink may then receive the
+// stats of a run read again twice, never the database.
+func WithSinks(sinks ...externals.Database) Option {
+	return func(p *Pipeline) {
+		p.sinks = sinks
+	}
+}
+
+// WithConfigHash records hash, identifying the configuration of the pipeline,
+// in the history of its runs.
+func WithConfigHash(hash string) Option {
+	return func(p *Pipeline) {
+		p.configHash = hash
+	}
+}
+
+// WithRange aggregates only the records of the days and project of r, the
+// others being skipped before they are priced.
+func WithRange(r internal.BackfillRange) Option {
+	return func(p *Pipeline) {
+		p.dates = &r
+	}
 }
 
-func NewPipeline(cg externals.CoinGeckoAPI, dg externals.DataGetterService, ch externals.Database, gNum int) *Pipeline {
+// WithDryRun aggregates and prices the records without storing anything: no
+// stats, seen trades, verifications nor run record are written, and the data
+// getter is not committed.
+func WithDryRun() Option {
+	return func(p *Pipeline) {
+		p.dryRun = true
+	}
+}
+
+func NewPipeline(cg externals.CoinGeckoAPI, dg externals.DataGetterService, ch externals.Database, gNum int, opts ...Option) *Pipeline {
 	cg.InitTokenIDs()
-	return &Pipeline{
+	p := &Pipeline{
 		coingecko:    cg,
 		dataGetter:   dg,
 		clickhosue:   ch,
@@ -30,19 +145,81 @@ func NewPipeline(cg externals.CoinGeckoAPI, dg externals.DataGetterService, ch e
 		marketStatsCache: &marketStatCache{
 			stats: make(map[string]internal.MarketStat),
 		},
+		verifications: &verificationCache{},
+		run:           newRunLog(),
 	}
+	if _, ok := ch.(externals.TransactionDatabase); ok {
+		p.transactions = &transactionCache{}
+	}
+	p.progress.Store(time.Now().UnixNano())
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
-func (p *Pipeline) Run() {
-	slog.Info("pipeline started")
+// Run reads the records of the data getter until its end, then stores their
+// stats, along the way as well when flushes are enabled. The data getter is
+// committed only once all the stats are stored. When the data getter fails,
+// the stats not stored yet are dropped and the run fails, so that its
+// records are read again. A run is traced by a span,
+// parent of the spans of its reads, records and stores. Its stats are tagged
+// with its id and, when the database keeps the history of the runs, its
+// record is stored at its end, whether it succeeded or not. When the database
+// rewrites its stats in maintenance, the run waits for it, and holds it off.
+func (p *Pipeline) Run() (err error) {
+	p.run = newRunLog()
+	slog.Info("pipeline started", "run_id", p.run.id)
+	start := time.Now()
+	if (p.flushSize > 0 || p.flushInterval > 0) && p.dedup == nil {
+		return errors.New("flushes need deduplication, for a failed run read again not to count the flushed trades twice")
+	}
+	ctx, span := tracer.Start(context.Background(), "Pipeline.Run")
+	ctx, p.cancel = context.WithCancelCause(ctx)
+	defer func() {
+		p.cancel(nil)
+		status := "ok"
+		if err != nil {
+			status = "error"
+		}
+		metrics.RunDuration.WithLabelValues(status).Observe(time.Since(start).Seconds())
+		tracing.End(span, err)
+		p.progress.Store(time.Now().UnixNano())
+		p.storeRun(err)
+	}()
+	if locker, ok := p.clickhosue.(externals.RunLocker); ok && !p.dryRun {
+		unlock, err := locker.LockRun(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to lock run: %w", err)
+		}
+		defer func() {
+			if err := unlock(); err != nil {
+				slog.Error("failed to unlock run", "run_id", p.run.id, "err", err)
+			}
+		}()
+	}
+	if p.dedup != nil {
+		// Forget the trades of a run that failed before being stored.
+		p.dedup.reset()
+	}
+	if resetter, ok := p.verifier.(externals.Resetter); ok {
+		resetter.Reset()
+	}
 	var wg sync.WaitGroup
 	wg.Add(p.goroutineNum + 1)
 
+	var readErr error
 	go func() {
 		defer wg.Done()
-		if err := p.dataGetter.ReadDataFromFiles(); err != nil {
+		ctx, span := tracer.Start(ctx, "ReadDataFromFiles")
+		err := p.dataGetter.ReadDataFromFiles(ctx)
+		// A stopped run fails with the cause of its stop.
+		if err != nil && ctx.Err() == nil {
 			slog.Error("failed to read data from files", "err", err)
+			readErr = fmt.Errorf("failed to read data from files: %w", err)
+			p.run.error(readErr)
 		}
+		tracing.End(span, err)
 	}()
 
 	for i := 0; i < p.goroutineNum; i++ {
@@ -54,19 +231,378 @@ func (p *Pipeline) Run() {
 					if !ok {
 						return
 					}
-					if err := p.GetMarketStats(record); err != nil {
-						slog.Error("failed to get market stats", "err", err)
-					}
+					p.processRecord(ctx, record)
 				case <-p.dataGetter.EndChannel():
+					// The end is signaled once every record is sent, but the
+					// record channel may still hold some.
+					p.drain(ctx)
 					return
 				}
 			}
 		}()
 	}
+	stopFlushes := p.startFlushes(ctx)
 	wg.Wait()
+	if p.dedup != nil {
+		// The last trades read are checked together.
+		p.flushLock.RLock()
+		p.aggregateUnseen(ctx, p.dedup.dequeue())
+		p.flushLock.RUnlock()
+	}
+	stopFlushes()
+	if err := context.Cause(ctx); err != nil {
+		p.discard()
+		return err
+	}
+	if readErr != nil {
+		p.discard()
+		return readErr
+	}
+
+	// The last flush fails as well when an earlier one did.
+	err = p.flush(ctx)
+	p.storeLock.Lock()
+	p.flushErr = nil
+	p.storeLock.Unlock()
+	if err != nil {
+		return err
+	}
+	if committer, ok := p.dataGetter.(externals.Committer); ok && !p.dryRun {
+		if err := committer.Commit(); err != nil {
+			slog.Error("failed to commit read data", "err", err)
+			err = fmt.Errorf("failed to commit read data: %w", err)
+			p.run.error(err)
+			return err
+		}
+	}
+	slog.Info("pipeline ended", "run_id", p.run.id, "duplicates", p.duplicates.Load())
+	return nil
+}
+
+// storeRun stores the record of the run ended with err, when the database
+// keeps the history of the runs. The run is not failed when its record
+// cannot be stored: its stats are.
+func (p *Pipeline) storeRun(err error) {
+	database, ok := p.clickhosue.(externals.RunDatabase)
+	if !ok || p.dryRun {
+		return
+	}
+	if err := database.InsertRun(p.run.record(p.configHash, err)); err != nil {
+		slog.Error("failed to insert pipeline run", "run_id", p.run.id, "err", err)
+	}
+}
+
+// LastProgress returns the time of the last record processed or run ended,
+// for a stuck pipeline to be told from an idle one reading empty batches.
+func (p *Pipeline) LastProgress() time.Time {
+	return time.Unix(0, p.progress.Load())
+}
+
+// Duplicates returns the number of duplicate trades dropped so far.
+func (p *Pipeline) Duplicates() uint64 {
+	return p.duplicates.Load()
+}
+
+// startFlushes flushes the caches every flush interval until the returned
+// function is called.
+func (p *Pipeline) startFlushes(ctx context.Context) func() {
+	if p.flushInterval <= 0 {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(p.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.flush(ctx)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// discard drops the records cached so far, of a run that failed.
+func (p *Pipeline) discard() {
+	p.storeLock.Lock()
+	defer p.storeLock.Unlock()
+	p.flushLock.Lock()
+	defer p.flushLock.Unlock()
+	p.marketStatsCache.reset()
+	if p.transactions != nil {
+		p.transactions.reset()
+	}
+	if p.dedup != nil {
+		p.dedup.take()
+	}
+	p.verifications.reset()
+	p.buffered.Store(0)
+	p.flushErr = nil
+}
+
+// flush stores the records cached so far. Once a store failed, the run is
+// bound to be read again: it is stopped, and the following records are
+// dropped.
+func (p *Pipeline) flush(ctx context.Context) error {
+	p.storeLock.Lock()
+	defer p.storeLock.Unlock()
+
+	p.flushLock.Lock()
+	stats := p.marketStatsCache.reset()
+	var transactions []internal.Transaction
+	if p.transactions != nil {
+		transactions = p.transactions.reset()
+	}
+	var keys []string
+	if p.dedup != nil {
+		keys = p.dedup.take()
+	}
+	verifications := p.verifications.reset()
+	p.buffered.Store(0)
+	p.flushLock.Unlock()
+
+	if p.flushErr != nil {
+		return p.flushErr
+	}
+	for key, stat := range stats {
+		stat.RunID = p.run.id
+		stats[key] = stat
+	}
+	for i := range transactions {
+		transactions[i].RunID = p.run.id
+	}
+	p.flushErr = p.store(ctx, stats, transactions, keys, verifications)
+	if p.flushErr != nil {
+		p.run.error(p.flushErr)
+		p.cancel(p.flushErr)
+	}
+	return p.flushErr
+}
+
+// store writes the stats to the sinks, inserts the stats, or the
+// transactions for databases deriving the stats from them, then marks their
+// trades seen and inserts their verifications. A database that is the seen
+// store as well marks the trades seen in the transaction of their stats.
+func (p *Pipeline) store(ctx context.Context, stats map[string]internal.MarketStat, transactions []internal.Transaction, keys []string, verifications []internal.Verification) error {
+	if p.dryRun {
+		if len(stats) > 0 || len(transactions) > 0 {
+			slog.Info("dry run, not storing", "stats", len(stats), "transactions", len(transactions), "verifications", len(verifications))
+		}
+		return nil
+	}
+	if len(stats) > 0 {
+		for _, sink := range p.sinks {
+			if err := insertMarket(ctx, sink, "sink", stats); err != nil {
+				slog.Error("failed to write market stats to sink", "err", err)
+				return fmt.Errorf("failed to write market stats to sink: %w", err)
+			}
+		}
+	}
+	if p.transactions != nil {
+		if len(transactions) > 0 {
+			_, span := tracer.Start(ctx, "InsertTransactions", trace.WithAttributes(attribute.Int("transactions", len(transactions))))
+			err := p.clickhosue.(externals.TransactionDatabase).InsertTransactions(transactions)
+			tracing.End(span, err)
+			if err != nil {
+				slog.Error("failed to insert transactions", "err", err)
+				return fmt.Errorf("failed to insert transactions: %w", err)
+			}
+			p.run.written.Add(uint64(len(transactions)))
+		}
+	} else if inserter, ok := p.clickhosue.(externals.MarketSeenInserter); ok && any(p.dedupStore()) == any(p.clickhosue) {
+		if len(stats) > 0 || len(keys) > 0 {
+			_, span := tracer.Start(ctx, "InsertMarketSeen", trace.WithAttributes(
+				attribute.Int("stats", len(stats)),
+				attribute.Int("keys", len(keys))))
+			err := inserter.InsertMarketSeen(stats, keys)
+			tracing.End(span, err)
+			if err != nil {
+				slog.Error("failed to insert market stats", "err", err)
+				return fmt.Errorf("failed to insert market stats: %w", err)
+			}
+			p.run.written.Add(uint64(len(stats)))
+		}
+		// The trades are marked seen with their stats.
+		keys = nil
+	} else if len(stats) > 0 {
+		if err := insertMarket(ctx, p.clickhosue, "database", stats); err != nil {
+			slog.Error("failed to insert market stats", "err", err)
+			return fmt.Errorf("failed to insert market stats: %w", err)
+		}
+		p.run.written.Add(uint64(len(stats)))
+	}
+	if marker, ok := p.dedupStore().(externals.SeenMarker); ok && len(keys) > 0 {
+		if err := marker.MarkSeen(keys); err != nil {
+			slog.Error("failed to mark transactions seen", "err", err)
+			return fmt.Errorf("failed to mark transactions seen: %w", err)
+		}
+	}
+	if database, ok := p.clickhosue.(externals.VerificationDatabase); ok && len(verifications) > 0 {
+		if err := database.InsertVerifications(verifications); err != nil {
+			slog.Error("failed to insert verifications", "err", err)
+			return fmt.Errorf("failed to insert verifications: %w", err)
+		}
+	}
+	return nil
+}
 
-	p.clickhosue.InsertMarket(p.marketStatsCache.stats)
-	slog.Info("pipeline ended")
+// insertMarket inserts the stats into a database or a sink, in a span.
+func insertMarket(ctx context.Context, database externals.Database, target string, stats map[string]internal.MarketStat) error {
+	_, span := tracer.Start(ctx, "InsertMarket", trace.WithAttributes(
+		attribute.String("target", target),
+		attribute.Int("stats", len(stats))))
+	err := database.InsertMarket(stats)
+	tracing.End(span, err)
+	return err
+}
+
+func (p *Pipeline) drain(ctx context.Context) {
+	for {
+		select {
+		case record, ok := <-p.dataGetter.Channel():
+			if !ok {
+				return
+			}
+			p.processRecord(ctx, record)
+		default:
+			return
+		}
+	}
+}
+
+// processRecord aggregates a record, and flushes the caches once they hold
+// flush size records. The records of a stopped run are dropped.
+func (p *Pipeline) processRecord(ctx context.Context, record internal.Record) {
+	if ctx.Err() != nil {
+		return
+	}
+	metrics.RecordChannelDepth.Set(float64(len(p.dataGetter.Channel())))
+	p.progress.Store(time.Now().UnixNano())
+	p.run.read(record.Source)
+	p.cacheRecord(ctx, record)
+	if p.flushSize > 0 && p.buffered.Load() >= int64(p.flushSize) {
+		p.flush(ctx)
+	}
+}
+
+// dedupStore returns the seen store of the deduplication, if any.
+func (p *Pipeline) dedupStore() externals.SeenStore {
+	if p.dedup == nil {
+		return nil
+	}
+	return p.dedup.store
+}
+
+// cacheRecord aggregates a record into the caches, or queues it to be checked
+// against the seen store first.
+func (p *Pipeline) cacheRecord(ctx context.Context, record internal.Record) {
+	p.flushLock.RLock()
+	defer p.flushLock.RUnlock()
+
+	if p.dates != nil && !p.inRange(record) {
+		return
+	}
+	key := ""
+	if p.dedup != nil {
+		key = dedupKey(record)
+	}
+	if key != "" {
+		if !p.dedup.claim(key) {
+			p.duplicate()
+			return
+		}
+		if p.dedup.store != nil {
+			p.aggregateUnseen(ctx, p.dedup.enqueue(record, key))
+			return
+		}
+	}
+	p.aggregate(ctx, record, key)
+}
+
+// duplicate counts a duplicate trade dropped.
+func (p *Pipeline) duplicate() {
+	p.duplicates.Add(1)
+	p.run.duplicated.Add(1)
+	metrics.RecordsDuplicated.Inc()
+}
+
+// aggregateUnseen checks the queued records against the seen store, and
+// aggregates the ones not seen. When the store cannot be checked, the run
+// is stopped rather than counting trades twice.
+func (p *Pipeline) aggregateUnseen(ctx context.Context, queue []queuedRecord) {
+	if len(queue) == 0 || ctx.Err() != nil {
+		return
+	}
+	_, span := tracer.Start(ctx, "Seen", trace.WithAttributes(attribute.Int("trades", len(queue))))
+	checked := len(queue)
+	unseen, err := p.dedup.unseen(queue)
+	tracing.End(span, err)
+	if err != nil {
+		slog.Error("failed to check duplicates", "err", err)
+		err = fmt.Errorf("failed to check duplicates: %w", err)
+		p.run.error(err)
+		p.cancel(err)
+		return
+	}
+	for i := len(unseen); i < checked; i++ {
+		p.duplicate()
+	}
+	for _, q := range unseen {
+		p.aggregate(ctx, q.record, q.key)
+	}
+}
+
+// aggregate aggregates a record into the caches. key is the trade of the
+// record, "" when it is not deduplicated.
+func (p *Pipeline) aggregate(ctx context.Context, record internal.Record, key string) {
+	if err := p.GetMarketStats(ctx, record); err != nil {
+		slog.Error("failed to get market stats", "err", err)
+		reason := "unknown"
+		var recordErr *internal.RecordError
+		if errors.As(err, &recordErr) {
+			reason = recordErr.Reason
+		}
+		metrics.RecordsFailed.WithLabelValues(reason).Inc()
+		p.run.failed.Add(1)
+		if rejecter, ok := p.dataGetter.(externals.Rejecter); ok {
+			rejecter.Reject(record, err)
+		}
+		if key != "" {
+			p.dedup.release(key)
+		}
+		return
+	}
+	if key != "" {
+		p.dedup.accept(key)
+	}
+	if p.verifier != nil {
+		p.verifications.add(p.verifier.Verify(record))
+	}
+	p.buffered.Add(1)
+	p.run.aggregated.Add(1)
+	metrics.RecordsAggregated.Inc()
+}
+
+// inRange reports whether a record is in the range of the pipeline. Records
+// that cannot be parsed are, to fail as usual.
+func (p *Pipeline) inRange(record internal.Record) bool {
+	date, err := time.Parse(time.DateTime+".000", record.Timestamp)
+	if err != nil {
+		return true
+	}
+	projectID, err := strconv.ParseUint(record.ProjectID, 10, 64)
+	if err != nil {
+		return true
+	}
+	return p.dates.Contains(date, projectID)
 }
 
 type marketStatCache struct {
@@ -74,47 +610,122 @@ type marketStatCache struct {
 	stats map[string]internal.MarketStat
 }
 
-type propsSchema struct {
-	CurrencySymbol string `json:"currencySymbol"`
+type transactionCache struct {
+	mutex        sync.Mutex
+	transactions []internal.Transaction
 }
 
-type numsSchema struct {
-	CurrencyValueDecimal string `json:"currencyValueDecimal"`
+func (c *transactionCache) add(transaction internal.Transaction) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.transactions = append(c.transactions, transaction)
 }
 
-func (p *Pipeline) GetMarketStats(record internal.Record) error {
-	date, err := time.Parse(time.DateTime+".000", record.Timestamp)
+// reset empties the cache and returns the transactions it held.
+func (c *transactionCache) reset() []internal.Transaction {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	transactions := c.transactions
+	c.transactions = nil
+	return transactions
+}
+
+type verificationCache struct {
+	mutex         sync.Mutex
+	verifications []internal.Verification
+}
+
+func (c *verificationCache) add(verification internal.Verification) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.verifications = append(c.verifications, verification)
+}
+
+// reset empties the cache and returns the verifications it held.
+func (c *verificationCache) reset() []internal.Verification {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	verifications := c.verifications
+	c.verifications = nil
+	return verifications
+}
+
+// GetMarketStats prices a record and aggregates it into the caches, in a span
+// child of the span of ctx.
+func (p *Pipeline) GetMarketStats(ctx context.Context, record internal.Record) (err error) {
+	ctx, span := tracer.Start(ctx, "GetMarketStats", trace.WithAttributes(
+		attribute.String("project_id", record.ProjectID),
+		attribute.String("source", record.Source)))
+	defer func() { tracing.End(span, err) }()
+
+	parsed, err := internal.ParseRecord(record)
 	if err != nil {
-		return fmt.Errorf("failed to parse timestamp: %w", err)
+		return err
 	}
+	date, amount := parsed.Date, parsed.Amount
 	y, m, d := date.Date()
 	dateString := fmt.Sprintf("%02d-%02d-%d", d, m, y)
 
-	var props propsSchema
-	if err := json.Unmarshal([]byte(record.Props), &props); err != nil {
-		return fmt.Errorf("failed to unmarshal props: %w", err)
+	p.run.priceCalls.Add(1)
+	price, err := p.coingecko.GetPrice(ctx, parsed.Symbol, dateString)
+	if err != nil {
+		return &internal.RecordError{Reason: "price", Err: fmt.Errorf("failed to get price: %w", err)}
 	}
-	var nums numsSchema
-	if err := json.Unmarshal([]byte(record.Nums), &nums); err != nil {
-		return fmt.Errorf("failed to unmarshal props: %w", err)
+
+	key := dateString + "-" + record.ProjectID
+	dimensions := make(map[string]string, len(p.dimensions))
+	for _, name := range p.dimensions {
+		value, _ := record.Field(name)
+		dimensions[name] = value
+		key += "-" + name + "=" + value
 	}
-	amount, err := strconv.ParseFloat(nums.CurrencyValueDecimal, 64)
-	if err != nil {
-		return fmt.Errorf("failed to parse currency value decimal: %w", err)
+
+	// Databases storing the transactions derive the stats from them, the
+	// stats are only aggregated for the sinks.
+	if p.transactions != nil {
+		p.transactions.add(newTransaction(record, parsed, price, dimensions))
+		if len(p.sinks) == 0 {
+			return nil
+		}
 	}
+	p.marketStatsCache.Update(key, parsed.ProjectID, date, price, amount, dimensions)
+	return nil
+}
 
-	price, err := p.coingecko.GetPrice(props.CurrencySymbol, dateString)
-	if err != nil {
-		return fmt.Errorf("failed to get price: %w", err)
+func newTransaction(record internal.Record, parsed internal.ParsedRecord, price float64, dimensions map[string]string) internal.Transaction {
+	field := func(name string) string {
+		value, _ := record.Field(name)
+		return value
+	}
+	return internal.Transaction{
+		Timestamp:         parsed.Date,
+		Event:             record.Event,
+		ProjectID:         parsed.ProjectID,
+		ChainID:           field("props.chainId"),
+		CurrencyAddress:   field("props.currencyAddress"),
+		CurrencySymbol:    parsed.Symbol,
+		Amount:            parsed.Amount,
+		PriceUSD:          price,
+		ValueUSD:          price * parsed.Amount,
+		TxnHash:           field("props.txnHash"),
+		CollectionAddress: field("props.collectionAddress"),
+		TokenID:           field("props.tokenId"),
+		SourceFile:        record.Source,
+		Dimensions:        dimensions,
 	}
+}
 
-	key := dateString + "-" + record.ProjectID
+// reset empties the cache and returns the stats it held.
+func (c *marketStatCache) reset() map[string]internal.MarketStat {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	p.marketStatsCache.Update(key, record.ProjectID, date, price, amount)
-	return nil
+	stats := c.stats
+	c.stats = make(map[string]internal.MarketStat)
+	return stats
 }
 
-func (c *marketStatCache) Update(key, projectID string, date time.Time, price, amount float64) error {
+func (c *marketStatCache) Update(key string, projectID uint64, date time.Time, price, amount float64, dimensions map[string]string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -125,18 +736,15 @@ func (c *marketStatCache) Update(key, projectID string, date time.Time, price, a
 			ProjectID:   ms.ProjectID,
 			NumTx:       ms.NumTx + 1,
 			TotalVolume: ms.TotalVolume + (price * amount),
+			Dimensions:  ms.Dimensions,
 		}
 	} else {
-		pID, err := strconv.ParseUint(projectID, 10, 64)
-		if err != nil {
-			return fmt.Errorf("failed to parse project id: %w", err)
-		}
 		c.stats[key] = internal.MarketStat{
 			Date:        date,
-			ProjectID:   pID,
+			ProjectID:   projectID,
 			NumTx:       1,
 			TotalVolume: price * amount,
+			Dimensions:  dimensions,
 		}
 	}
-	return nil
 }
@@ -1,14 +1,19 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/tracing"
 	"github.com/lat1992/blockchain-data-aggregator/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/test-go/testify/mock"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestNewPipeline(t *testing.T) {
@@ -38,7 +43,7 @@ func TestPipeline_Run(t *testing.T) {
 	endChan := make(chan bool, 1)
 
 	mockCG.On("InitTokenIDs").Return(nil)
-	mockDG.On("ReadDataFromFiles").Return(nil)
+	mockDG.On("ReadDataFromFiles", mock.Anything).Return(nil)
 	mockDG.On("Channel").Return(recordChan)
 	mockDG.On("EndChannel").Return(endChan)
 	mockDB.On("InsertMarket", mock.Anything).Return(nil)
@@ -56,7 +61,7 @@ func TestPipeline_Run(t *testing.T) {
 		close(recordChan)
 	}()
 
-	mockCG.On("GetPrice", "BTC", "01-01-2024").Return(50000.0, nil)
+	mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
 
 	pipeline.Run()
 
@@ -82,7 +87,7 @@ func TestPipeline_GetMarketStats(t *testing.T) {
 				Nums:      `{"currencyValueDecimal":"1.5"}`,
 			},
 			setup: func(mockCG *mocks.CoinGeckoAPI) {
-				mockCG.On("GetPrice", "BTC", "01-01-2024").Return(50000.0, nil)
+				mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
 			},
 			stats: map[string]internal.MarketStat{
 				"01-01-2024-1234": {
@@ -146,7 +151,7 @@ func TestPipeline_GetMarketStats(t *testing.T) {
 				Nums:      `{"currencyValueDecimal":"1.5"}`,
 			},
 			setup: func(mockCG *mocks.CoinGeckoAPI) {
-				mockCG.On("GetPrice", "BTC", "01-01-2024").Return(0.0, fmt.Errorf("coingecko error"))
+				mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(0.0, fmt.Errorf("coingecko error"))
 			},
 			err: assert.AnError,
 		},
@@ -159,7 +164,7 @@ func TestPipeline_GetMarketStats(t *testing.T) {
 				Nums:      `{"currencyValueDecimal":"1.5"}`,
 			},
 			setup: func(mockCG *mocks.CoinGeckoAPI) {
-				mockCG.On("GetPrice", "BTC", "01-01-2024").Return(50000.0, nil)
+				mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
 			},
 			stats: map[string]internal.MarketStat{
 				"01-01-2024-1234": {
@@ -184,11 +189,11 @@ func TestPipeline_GetMarketStats(t *testing.T) {
 			pipeline := NewPipeline(mockCG, mockDG, mockDB, 1)
 
 			if tc.name == "multiple transactions for same project and date" {
-				err := pipeline.GetMarketStats(tc.record)
+				err := pipeline.GetMarketStats(context.Background(), tc.record)
 				assert.NoError(t, err)
 			}
 
-			err := pipeline.GetMarketStats(tc.record)
+			err := pipeline.GetMarketStats(context.Background(), tc.record)
 
 			if tc.err != nil {
 				assert.Error(t, err)
@@ -209,3 +214,712 @@ func TestPipeline_GetMarketStats(t *testing.T) {
 		})
 	}
 }
+
+func TestPipeline_GetMarketStatsDimensions(t *testing.T) {
+	mockCG := new(mocks.CoinGeckoAPI)
+	mockDG := new(mocks.DataGetterService)
+	mockDB := new(mocks.Database)
+
+	mockCG.On("InitTokenIDs").Return(nil)
+	mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+
+	pipeline := NewPipeline(mockCG, mockDG, mockDB, 1, WithDimensions([]string{"country", "props.chainId"}))
+
+	for _, country := range []string{"DE", "FR", "DE"} {
+		err := pipeline.GetMarketStats(context.Background(), internal.Record{
+			Timestamp: "2024-01-01 12:00:00.000",
+			ProjectID: "1234",
+			Props:     `{"currencySymbol":"BTC","chainId":"137"}`,
+			Nums:      `{"currencyValueDecimal":"1.5"}`,
+			Columns:   map[string]string{"country": country},
+		})
+		assert.NoError(t, err)
+	}
+
+	stats := pipeline.marketStatsCache.stats
+	assert.Len(t, stats, 2)
+	de := stats["01-01-2024-1234-country=DE-props.chainId=137"]
+	assert.Equal(t, uint64(2), de.NumTx)
+	assert.Equal(t, 150000.0, de.TotalVolume)
+	assert.Equal(t, map[string]string{"country": "DE", "props.chainId": "137"}, de.Dimensions)
+	fr := stats["01-01-2024-1234-country=FR-props.chainId=137"]
+	assert.Equal(t, uint64(1), fr.NumTx)
+	assert.Equal(t, map[string]string{"country": "FR", "props.chainId": "137"}, fr.Dimensions)
+}
+
+// committingDataGetter records whether the data read was committed.
+type committingDataGetter struct {
+	*mocks.DataGetterService
+	committed int
+}
+
+func (g *committingDataGetter) Commit() error {
+	g.committed++
+	return nil
+}
+
+func TestPipeline_RunCommit(t *testing.T) {
+	tests := []struct {
+		name      string
+		insertErr error
+		committed int
+	}{
+		{name: "committed after insert", committed: 1},
+		{name: "not committed when insert fails", insertErr: fmt.Errorf("connection refused"), committed: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCG := new(mocks.CoinGeckoAPI)
+			mockDG := &committingDataGetter{DataGetterService: new(mocks.DataGetterService)}
+			mockDB := new(mocks.Database)
+
+			recordChan := make(chan internal.Record, 2)
+			endChan := make(chan bool, 1)
+
+			mockCG.On("InitTokenIDs").Return(nil)
+			mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+			mockDG.On("ReadDataFromFiles", mock.Anything).Return(nil)
+			mockDG.On("Channel").Return(recordChan)
+			mockDG.On("EndChannel").Return(endChan)
+			mockDB.On("InsertMarket", mock.Anything).Return(tc.insertErr)
+
+			// Records still buffered when the end is signaled are processed.
+			for i := 0; i < 2; i++ {
+				recordChan <- internal.Record{
+					Timestamp: "2024-01-01 12:00:00.000",
+					ProjectID: "1234",
+					Props:     `{"currencySymbol":"BTC"}`,
+					Nums:      `{"currencyValueDecimal":"1.5"}`,
+				}
+			}
+			endChan <- true
+
+			pipeline := NewPipeline(mockCG, mockDG, mockDB, 1)
+			err := pipeline.Run()
+
+			assert.Equal(t, tc.insertErr != nil, err != nil)
+			assert.Equal(t, tc.committed, mockDG.committed)
+			stats := mockDB.Calls[0].Arguments.Get(0).(map[string]internal.MarketStat)
+			assert.Equal(t, uint64(2), stats["01-01-2024-1234"].NumTx)
+			assert.Empty(t, pipeline.marketStatsCache.stats)
+		})
+	}
+}
+
+func TestPipeline_RunReadError(t *testing.T) {
+	mockCG := new(mocks.CoinGeckoAPI)
+	mockDG := &committingDataGetter{DataGetterService: new(mocks.DataGetterService)}
+	mockDB := new(mocks.Database)
+
+	recordChan := make(chan internal.Record, 1)
+	endChan := make(chan bool, 1)
+
+	mockCG.On("InitTokenIDs").Return(nil)
+	mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+	mockDG.On("ReadDataFromFiles", mock.Anything).Return(fmt.Errorf("unexpected EOF"))
+	mockDG.On("Channel").Return(recordChan)
+	mockDG.On("EndChannel").Return(endChan)
+
+	// The record read before the error is neither stored nor kept for the
+	// next run.
+	recordChan <- internal.Record{
+		Timestamp: "2024-01-01 12:00:00.000",
+		ProjectID: "1234",
+		Props:     `{"currencySymbol":"BTC"}`,
+		Nums:      `{"currencyValueDecimal":"1.5"}`,
+	}
+	endChan <- true
+
+	pipeline := NewPipeline(mockCG, mockDG, mockDB, 1)
+	err := pipeline.Run()
+
+	assert.ErrorContains(t, err, "unexpected EOF")
+	assert.Equal(t, 0, mockDG.committed)
+	mockDB.AssertNotCalled(t, "InsertMarket", mock.Anything)
+	assert.Empty(t, pipeline.marketStatsCache.stats)
+}
+
+// rejectingDataGetter records the records rejected by the pipeline.
+type rejectingDataGetter struct {
+	*mocks.DataGetterService
+	rejected []internal.Record
+}
+
+func (g *rejectingDataGetter) Reject(record internal.Record, _ error) {
+	g.rejected = append(g.rejected, record)
+}
+
+func TestPipeline_RunReject(t *testing.T) {
+	mockCG := new(mocks.CoinGeckoAPI)
+	mockDG := &rejectingDataGetter{DataGetterService: new(mocks.DataGetterService)}
+	mockDB := new(mocks.Database)
+
+	recordChan := make(chan internal.Record, 2)
+	endChan := make(chan bool, 1)
+
+	mockCG.On("InitTokenIDs").Return(nil)
+	mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+	mockCG.On("GetPrice", mock.Anything, "ETH", "01-01-2024").Return(0.0, fmt.Errorf("coingecko error"))
+	mockDG.On("ReadDataFromFiles", mock.Anything).Return(nil)
+	mockDG.On("Channel").Return(recordChan)
+	mockDG.On("EndChannel").Return(endChan)
+	mockDB.On("InsertMarket", mock.Anything).Return(nil)
+
+	for i, symbol := range []string{"BTC", "ETH"} {
+		recordChan <- internal.Record{
+			Timestamp: "2024-01-01 12:00:00.000",
+			ProjectID: "1234",
+			Props:     `{"currencySymbol":"` + symbol + `"}`,
+			Nums:      `{"currencyValueDecimal":"1.5"}`,
+			Origin:    i,
+		}
+	}
+	endChan <- true
+
+	pipeline := NewPipeline(mockCG, mockDG, mockDB, 1)
+	assert.NoError(t, pipeline.Run())
+
+	// The record that cannot be priced is reported to the data getter.
+	if assert.Len(t, mockDG.rejected, 1) {
+		assert.Equal(t, 1, mockDG.rejected[0].Origin)
+	}
+}
+
+// lockingDatabase records the locks of the runs, and whether the stats were
+// stored holding one.
+type lockingDatabase struct {
+	*mocks.Database
+	lockErr  error
+	locked   bool
+	locks    int
+	unlocks  int
+	inserted bool
+}
+
+func (d *lockingDatabase) LockRun(ctx context.Context) (func() error, error) {
+	if d.lockErr != nil {
+		return nil, d.lockErr
+	}
+	d.locks++
+	d.locked = true
+	return func() error {
+		d.unlocks++
+		d.locked = false
+		return nil
+	}, nil
+}
+
+func (d *lockingDatabase) InsertMarket(stats map[string]internal.MarketStat) error {
+	d.inserted = d.locked
+	return d.Database.InsertMarket(stats)
+}
+
+func TestPipeline_RunLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		lockErr error
+		locks   int
+	}{
+		{name: "stored holding the lock", locks: 1},
+		{name: "not run without the lock", lockErr: fmt.Errorf("connection refused")},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCG := new(mocks.CoinGeckoAPI)
+			mockDG := new(mocks.DataGetterService)
+			mockDB := &lockingDatabase{Database: new(mocks.Database), lockErr: tc.lockErr}
+
+			recordChan := make(chan internal.Record, 1)
+			endChan := make(chan bool, 1)
+
+			mockCG.On("InitTokenIDs").Return(nil)
+			mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+			mockDG.On("ReadDataFromFiles", mock.Anything).Return(nil)
+			mockDG.On("Channel").Return(recordChan)
+			mockDG.On("EndChannel").Return(endChan)
+			mockDB.On("InsertMarket", mock.Anything).Return(nil)
+
+			recordChan <- internal.Record{
+				Timestamp: "2024-01-01 12:00:00.000",
+				ProjectID: "1234",
+				Props:     `{"currencySymbol":"BTC"}`,
+				Nums:      `{"currencyValueDecimal":"1.5"}`,
+			}
+			endChan <- true
+
+			err := NewPipeline(mockCG, mockDG, mockDB, 1).Run()
+
+			assert.Equal(t, tc.lockErr != nil, err != nil)
+			assert.Equal(t, tc.locks, mockDB.locks)
+			assert.Equal(t, tc.locks, mockDB.unlocks)
+			assert.Equal(t, tc.lockErr == nil, mockDB.inserted)
+			if tc.lockErr != nil {
+				mockDG.AssertNotCalled(t, "ReadDataFromFiles", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestPipeline_RunVerifications(t *testing.T) {
+	mockCG := new(mocks.CoinGeckoAPI)
+	mockDG := new(mocks.DataGetterService)
+	mockDB := new(mocks.VerificationDatabase)
+	mockVerifier := new(mocks.Verifier)
+
+	recordChan := make(chan internal.Record, 2)
+	endChan := make(chan bool, 1)
+	record := internal.Record{
+		Timestamp: "2024-01-01 12:00:00.000",
+		ProjectID: "1234",
+		Props:     `{"currencySymbol":"BTC","txnHash":"0x01"}`,
+		Nums:      `{"currencyValueDecimal":"1.5"}`,
+	}
+	recordChan <- record
+	recordChan <- internal.Record{Timestamp: "invalid"}
+	endChan <- true
+
+	mockCG.On("InitTokenIDs").Return(nil)
+	mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+	mockDG.On("ReadDataFromFiles", mock.Anything).Return(nil)
+	mockDG.On("Channel").Return(recordChan)
+	mockDG.On("EndChannel").Return(endChan)
+	mockDB.On("InsertMarket", mock.Anything).Return(nil)
+	verification := internal.Verification{ProjectID: "1234", TxnHash: "0x01", Status: internal.VerificationVerified}
+	mockVerifier.On("Verify", record).Return(verification)
+	mockDB.On("InsertVerifications", []internal.Verification{verification}).Return(nil)
+
+	pipeline := NewPipeline(mockCG, mockDG, mockDB, 1, WithVerifier(mockVerifier))
+	assert.NoError(t, pipeline.Run())
+
+	mockVerifier.AssertNumberOfCalls(t, "Verify", 1)
+	mockDB.AssertExpectations(t)
+}
+
+func TestPipeline_RunDeduplication(t *testing.T) {
+	mockCG := new(mocks.CoinGeckoAPI)
+	mockDG := new(mocks.DataGetterService)
+	mockDB := new(mocks.Database)
+	mockStore := new(mocks.SeenStore)
+
+	trade := func(txnHash, tokenID string) internal.Record {
+		return internal.Record{
+			Timestamp: "2024-01-01 12:00:00.000",
+			ProjectID: "1234",
+			Props:     `{"currencySymbol":"BTC","chainId":"137","txnHash":"` + txnHash + `","tokenId":"` + tokenID + `"}`,
+			Nums:      `{"currencyValueDecimal":"1.5"}`,
+		}
+	}
+	records := []internal.Record{
+		trade("0xAA", "1"),
+		trade("0xaa", "1"), // same trade from an overlapping file
+		trade("0xaa", "2"), // another token of the same transaction
+		trade("0xbb", "1"), // counted by an earlier run
+		{Timestamp: "2024-01-01 12:00:00.000", ProjectID: "1234", Props: `{"currencySymbol":"BTC"}`, Nums: `{"currencyValueDecimal":"1.5"}`},
+	}
+	recordChan := make(chan internal.Record, len(records))
+	endChan := make(chan bool, 1)
+	for _, record := range records {
+		recordChan <- record
+	}
+	endChan <- true
+
+	mockCG.On("InitTokenIDs").Return(nil)
+	mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+	mockDG.On("ReadDataFromFiles", mock.Anything).Return(nil)
+	mockDG.On("Channel").Return(recordChan)
+	mockDG.On("EndChannel").Return(endChan)
+	mockDB.On("InsertMarket", mock.Anything).Return(nil)
+	mockStore.On("Seen", mock.Anything).Return(map[string]bool{"137-0xbb-1": true}, nil)
+	mockStore.On("MarkSeen", mock.Anything).Return(nil)
+
+	pipeline := NewPipeline(mockCG, mockDG, mockDB, 1, WithDeduplication(mockStore))
+	assert.NoError(t, pipeline.Run())
+
+	stats := mockDB.Calls[0].Arguments.Get(0).(map[string]internal.MarketStat)
+	assert.Equal(t, uint64(3), stats["01-01-2024-1234"].NumTx)
+	assert.Equal(t, uint64(2), pipeline.Duplicates())
+	// The new trades of the run are checked in one batch.
+	mockStore.AssertNumberOfCalls(t, "Seen", 1)
+	assert.ElementsMatch(t, []string{"137-0xaa-1", "137-0xaa-2", "137-0xbb-1"}, mockStore.Calls[0].Arguments.Get(0))
+	assert.ElementsMatch(t, []string{"137-0xaa-1", "137-0xaa-2"}, mockStore.Calls[1].Arguments.Get(0))
+}
+
+// marketSeenDatabase is a seen store storing the stats as well, marking their
+// trades seen in the same transaction.
+type marketSeenDatabase struct {
+	*mocks.SeenStore
+	stats map[string]internal.MarketStat
+	keys  []string
+}
+
+func (d *marketSeenDatabase) InsertMarket(stats map[string]internal.MarketStat) error {
+	return fmt.Errorf("stats stored apart from their trades")
+}
+
+func (d *marketSeenDatabase) InsertMarketSeen(stats map[string]internal.MarketStat, keys []string) error {
+	d.stats, d.keys = stats, keys
+	return nil
+}
+
+func TestPipeline_RunDeduplicationMarketSeen(t *testing.T) {
+	mockCG := new(mocks.CoinGeckoAPI)
+	mockDG := new(mocks.DataGetterService)
+	mockDB := &marketSeenDatabase{SeenStore: new(mocks.SeenStore)}
+
+	recordChan := make(chan internal.Record, 1)
+	endChan := make(chan bool, 1)
+	recordChan <- internal.Record{
+		Timestamp: "2024-01-01 12:00:00.000",
+		ProjectID: "1234",
+		Props:     `{"currencySymbol":"BTC","chainId":"137","txnHash":"0xaa","tokenId":"1"}`,
+		Nums:      `{"currencyValueDecimal":"1.5"}`,
+	}
+	endChan <- true
+
+	mockCG.On("InitTokenIDs").Return(nil)
+	mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+	mockDG.On("ReadDataFromFiles", mock.Anything).Return(nil)
+	mockDG.On("Channel").Return(recordChan)
+	mockDG.On("EndChannel").Return(endChan)
+	mockDB.On("Seen", mock.Anything).Return(map[string]bool{}, nil)
+
+	assert.NoError(t, NewPipeline(mockCG, mockDG, mockDB, 1, WithDeduplication(mockDB)).Run())
+
+	assert.Equal(t, uint64(1), mockDB.stats["01-01-2024-1234"].NumTx)
+	assert.Equal(t, []string{"137-0xaa-1"}, mockDB.keys)
+	mockDB.AssertNotCalled(t, "MarkSeen", mock.Anything)
+}
+
+func TestPipeline_RunDeduplicationError(t *testing.T) {
+	mockCG := new(mocks.CoinGeckoAPI)
+	mockDG := &committingDataGetter{DataGetterService: new(mocks.DataGetterService)}
+	mockDB := new(mocks.Database)
+	mockStore := new(mocks.SeenStore)
+
+	recordChan := make(chan internal.Record, 1)
+	endChan := make(chan bool, 1)
+	recordChan <- internal.Record{
+		Timestamp: "2024-01-01 12:00:00.000",
+		ProjectID: "1234",
+		Props:     `{"currencySymbol":"BTC","chainId":"137","txnHash":"0xaa","tokenId":"1"}`,
+		Nums:      `{"currencyValueDecimal":"1.5"}`,
+	}
+	endChan <- true
+
+	mockCG.On("InitTokenIDs").Return(nil)
+	mockDG.On("ReadDataFromFiles", mock.Anything).Return(nil)
+	mockDG.On("Channel").Return(recordChan)
+	mockDG.On("EndChannel").Return(endChan)
+	mockStore.On("Seen", mock.Anything).Return(nil, fmt.Errorf("connection refused"))
+
+	// A trade that cannot be checked fails the run rather than being
+	// counted twice.
+	pipeline := NewPipeline(mockCG, mockDG, mockDB, 1, WithDeduplication(mockStore))
+	assert.ErrorContains(t, pipeline.Run(), "connection refused")
+	mockDB.AssertNotCalled(t, "InsertMarket", mock.Anything)
+	assert.Equal(t, 0, mockDG.committed)
+}
+
+func TestPipeline_RunTransactions(t *testing.T) {
+	mockCG := new(mocks.CoinGeckoAPI)
+	mockDG := new(mocks.DataGetterService)
+	mockDB := new(mocks.TransactionDatabase)
+
+	recordChan := make(chan internal.Record, 2)
+	endChan := make(chan bool, 1)
+	recordChan <- internal.Record{
+		Timestamp: "2024-01-01 12:00:00.000",
+		Event:     "BUY_ITEMS",
+		ProjectID: "1234",
+		Props:     `{"currencySymbol":"BTC","chainId":"137","currencyAddress":"0xcc","txnHash":"0x01","collectionAddress":"0xdd","tokenId":"7"}`,
+		Nums:      `{"currencyValueDecimal":"1.5"}`,
+		Source:    "data/2024-01-01.csv",
+	}
+	recordChan <- internal.Record{Timestamp: "invalid"}
+	endChan <- true
+
+	mockCG.On("InitTokenIDs").Return(nil)
+	mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+	mockDG.On("ReadDataFromFiles", mock.Anything).Return(nil)
+	mockDG.On("Channel").Return(recordChan)
+	mockDG.On("EndChannel").Return(endChan)
+	mockDB.On("InsertTransactions", mock.Anything).Return(nil)
+
+	pipeline := NewPipeline(mockCG, mockDG, mockDB, 1)
+	assert.NoError(t, pipeline.Run())
+
+	mockDB.AssertNotCalled(t, "InsertMarket", mock.Anything)
+	transactions := mockDB.Calls[0].Arguments.Get(0).([]internal.Transaction)
+	assert.Equal(t, []internal.Transaction{{
+		Timestamp:         time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Event:             "BUY_ITEMS",
+		ProjectID:         1234,
+		ChainID:           "137",
+		CurrencyAddress:   "0xcc",
+		CurrencySymbol:    "BTC",
+		Amount:            1.5,
+		PriceUSD:          50000,
+		ValueUSD:          75000,
+		TxnHash:           "0x01",
+		CollectionAddress: "0xdd",
+		TokenID:           "7",
+		SourceFile:        "data/2024-01-01.csv",
+		Dimensions:        map[string]string{},
+		RunID:             pipeline.run.id,
+	}}, transactions)
+	assert.Empty(t, pipeline.transactions.transactions)
+}
+
+func TestPipeline_RunFlush(t *testing.T) {
+	testCases := []struct {
+		name           string
+		noDedup        bool
+		insertErr      error
+		wantErr        bool
+		wantInserts    int
+		wantAggregated uint64
+		committed      int
+	}{
+		{
+			name:           "flushes by size",
+			wantInserts:    3,
+			wantAggregated: 5,
+			committed:      1,
+		},
+		{
+			name:           "failed flush",
+			insertErr:      fmt.Errorf("insert failed"),
+			wantErr:        true,
+			wantInserts:    1,
+			wantAggregated: 2,
+		},
+		{
+			name:    "without deduplication",
+			noDedup: true,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCG := new(mocks.CoinGeckoAPI)
+			mockDG := &committingDataGetter{DataGetterService: new(mocks.DataGetterService)}
+			mockDB := new(mocks.Database)
+
+			recordChan := make(chan internal.Record, 5)
+			endChan := make(chan bool, 1)
+			for i := 0; i < 5; i++ {
+				recordChan <- internal.Record{
+					Timestamp: "2024-01-01 12:00:00.000",
+					ProjectID: "1234",
+					Props:     `{"currencySymbol":"BTC"}`,
+					Nums:      `{"currencyValueDecimal":"1.5"}`,
+				}
+			}
+			endChan <- true
+
+			mockCG.On("InitTokenIDs").Return(nil)
+			mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+			mockDG.DataGetterService.On("ReadDataFromFiles", mock.Anything).Return(nil)
+			mockDG.DataGetterService.On("Channel").Return(recordChan)
+			mockDG.DataGetterService.On("EndChannel").Return(endChan)
+			mockDB.On("InsertMarket", mock.Anything).Return(tc.insertErr)
+
+			opts := []Option{WithFlush(2, 0)}
+			if !tc.noDedup {
+				opts = append(opts, WithDeduplication(nil))
+			}
+			pipeline := NewPipeline(mockCG, mockDG, mockDB, 1, opts...)
+			err := pipeline.Run()
+
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.committed, mockDG.committed)
+			mockDB.AssertNumberOfCalls(t, "InsertMarket", tc.wantInserts)
+			// The records read after a failed flush are dropped.
+			assert.Equal(t, tc.wantAggregated, pipeline.run.aggregated.Load())
+			if !tc.wantErr {
+				var numTx uint64
+				for _, call := range mockDB.Calls {
+					numTx += call.Arguments.Get(0).(map[string]internal.MarketStat)["01-01-2024-1234"].NumTx
+				}
+				assert.Equal(t, uint64(5), numTx)
+			}
+		})
+	}
+}
+
+func TestPipeline_RunSinks(t *testing.T) {
+	testCases := []struct {
+		name    string
+		sinkErr error
+		wantErr bool
+	}{
+		{
+			name: "normal case",
+		},
+		{
+			name:    "sink error",
+			sinkErr: fmt.Errorf("disk full"),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCG := new(mocks.CoinGeckoAPI)
+			mockDG := new(mocks.DataGetterService)
+			mockDB := new(mocks.TransactionDatabase)
+			mockSink := new(mocks.Database)
+
+			recordChan := make(chan internal.Record, 1)
+			endChan := make(chan bool, 1)
+			recordChan <- internal.Record{
+				Timestamp: "2024-01-01 12:00:00.000",
+				ProjectID: "1234",
+				Props:     `{"currencySymbol":"BTC"}`,
+				Nums:      `{"currencyValueDecimal":"1.5"}`,
+			}
+			endChan <- true
+
+			mockCG.On("InitTokenIDs").Return(nil)
+			mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+			mockDG.On("ReadDataFromFiles", mock.Anything).Return(nil)
+			mockDG.On("Channel").Return(recordChan)
+			mockDG.On("EndChannel").Return(endChan)
+			mockDB.On("InsertTransactions", mock.Anything).Return(nil)
+			mockSink.On("InsertMarket", mock.Anything).Return(tc.sinkErr)
+
+			pipeline := NewPipeline(mockCG, mockDG, mockDB, 1, WithSinks(mockSink))
+			err := pipeline.Run()
+
+			assert.Equal(t, tc.wantErr, err != nil)
+			stats := mockSink.Calls[0].Arguments.Get(0).(map[string]internal.MarketStat)
+			assert.Equal(t, uint64(1), stats["01-01-2024-1234"].NumTx)
+			assert.Equal(t, 75000.0, stats["01-01-2024-1234"].TotalVolume)
+			if tc.wantErr {
+				mockDB.AssertNotCalled(t, "InsertTransactions", mock.Anything)
+			} else {
+				mockDB.AssertNumberOfCalls(t, "InsertTransactions", 1)
+			}
+		})
+	}
+}
+
+func TestPipeline_RunTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown, err := tracing.Setup(context.Background(), "test", "", tracing.WithExporter(exporter))
+	assert.NoError(t, err)
+
+	mockCG := new(mocks.CoinGeckoAPI)
+	mockDG := new(mocks.DataGetterService)
+	mockDB := new(mocks.Database)
+
+	recordChan := make(chan internal.Record, 2)
+	endChan := make(chan bool, 1)
+	recordChan <- internal.Record{
+		Timestamp: "2024-01-01 12:00:00.000",
+		ProjectID: "1234",
+		Props:     `{"currencySymbol":"BTC"}`,
+		Nums:      `{"currencyValueDecimal":"1.5"}`,
+	}
+	recordChan <- internal.Record{Timestamp: "invalid", ProjectID: "1234"}
+	endChan <- true
+
+	inSpan := mock.MatchedBy(func(ctx context.Context) bool {
+		return trace.SpanContextFromContext(ctx).IsValid()
+	})
+	mockCG.On("InitTokenIDs").Return(nil)
+	mockCG.On("GetPrice", inSpan, "BTC", "01-01-2024").Return(50000.0, nil)
+	mockDG.On("ReadDataFromFiles", inSpan).Return(nil)
+	mockDG.On("Channel").Return(recordChan)
+	mockDG.On("EndChannel").Return(endChan)
+	mockDB.On("InsertMarket", mock.Anything).Return(fmt.Errorf("connection refused"))
+
+	pipeline := NewPipeline(mockCG, mockDG, mockDB, 1)
+	assert.Error(t, pipeline.Run())
+	defer shutdown(context.Background())
+
+	spans := map[string][]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = append(spans[span.Name], span)
+	}
+	if !assert.Len(t, spans["Pipeline.Run"], 1) {
+		return
+	}
+	run := spans["Pipeline.Run"][0]
+	assert.Equal(t, codes.Error, run.Status.Code)
+	assert.Len(t, spans["ReadDataFromFiles"], 1)
+	assert.Len(t, spans["GetMarketStats"], 2)
+	assert.Len(t, spans["InsertMarket"], 1)
+	for _, name := range []string{"ReadDataFromFiles", "GetMarketStats", "InsertMarket"} {
+		for _, span := range spans[name] {
+			assert.Equal(t, run.SpanContext.SpanID(), span.Parent.SpanID(), "parent of %s", name)
+		}
+	}
+	assert.Equal(t, codes.Error, spans["InsertMarket"][0].Status.Code)
+	mockCG.AssertExpectations(t)
+	mockDG.AssertExpectations(t)
+}
+
+func TestPipeline_RunRecord(t *testing.T) {
+	testCases := []struct {
+		name       string
+		insertErr  error
+		wantStatus string
+		wantErrors []string
+		wantRows   uint64
+	}{
+		{name: "succeeded", wantStatus: internal.RunSucceeded, wantRows: 1},
+		{name: "failed", insertErr: fmt.Errorf("connection refused"), wantStatus: internal.RunFailed,
+			wantErrors: []string{"failed to insert market stats: connection refused"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCG := new(mocks.CoinGeckoAPI)
+			mockDG := new(mocks.DataGetterService)
+			mockDB := new(mocks.RunDatabase)
+
+			recordChan := make(chan internal.Record, 3)
+			endChan := make(chan bool, 1)
+			for _, value := range []string{"1.5", "0.5"} {
+				recordChan <- internal.Record{
+					Timestamp: "2024-01-01 12:00:00.000",
+					ProjectID: "1234",
+					Props:     `{"currencySymbol":"BTC"}`,
+					Nums:      `{"currencyValueDecimal":"` + value + `"}`,
+					Source:    "data/2024-01-01.csv",
+				}
+			}
+			recordChan <- internal.Record{Timestamp: "invalid", Source: "data/2024-01-02.csv"}
+			endChan <- true
+
+			mockCG.On("InitTokenIDs").Return(nil)
+			mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+			mockDG.On("ReadDataFromFiles", mock.Anything).Return(nil)
+			mockDG.On("Channel").Return(recordChan)
+			mockDG.On("EndChannel").Return(endChan)
+			mockDB.On("InsertMarket", mock.Anything).Return(tc.insertErr)
+			mockDB.On("InsertRun", mock.Anything).Return(nil)
+
+			pipeline := NewPipeline(mockCG, mockDG, mockDB, 1, WithConfigHash("abc"))
+			err := pipeline.Run()
+			assert.Equal(t, tc.insertErr != nil, err != nil)
+
+			stats := mockDB.Calls[0].Arguments.Get(0).(map[string]internal.MarketStat)
+			run := mockDB.Calls[1].Arguments.Get(0).(internal.PipelineRun)
+			assert.NotEmpty(t, run.ID)
+			assert.Equal(t, run.ID, stats["01-01-2024-1234"].RunID)
+			assert.Equal(t, tc.wantStatus, run.Status)
+			assert.Equal(t, "abc", run.ConfigHash)
+			assert.Equal(t, map[string]uint64{"data/2024-01-01.csv": 2, "data/2024-01-02.csv": 1}, run.Sources)
+			assert.Equal(t, uint64(2), run.RecordsAggregated)
+			assert.Equal(t, uint64(1), run.RecordsFailed)
+			assert.Equal(t, uint64(2), run.PriceCalls)
+			assert.Equal(t, tc.wantRows, run.RowsWritten)
+			assert.Equal(t, tc.wantErrors, run.Errors)
+			assert.False(t, run.EndedAt.Before(run.StartedAt))
+		})
+	}
+}
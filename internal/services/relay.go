@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/tracing"
+)
+
+// Relay reads records from a data getter and publishes them in batches, for
+// the indexers to aggregate. The data getter must end its reading with a
+// single end signal.
+type Relay struct {
+	dataGetter externals.DataGetterService
+	publisher  externals.Publisher
+	batchSize  int
+}
+
+func NewRelay(dg externals.DataGetterService, pub externals.Publisher, batchSize int) *Relay {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	return &Relay{
+		dataGetter: dg,
+		publisher:  pub,
+		batchSize:  batchSize,
+	}
+}
+
+// Run publishes the records of the data getter until its end. The data getter
+// is committed only once all the records are read and published: a failed
+// read fails the run, for its records to be read again.
+func (r *Relay) Run() (err error) {
+	slog.Info("relay started")
+	ctx, span := tracer.Start(context.Background(), "Relay.Run")
+	defer func() { tracing.End(span, err) }()
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- r.dataGetter.ReadDataFromFiles(ctx)
+	}()
+
+	var publishErr error
+	batch := make([]internal.Record, 0, r.batchSize)
+	flush := func() {
+		if len(batch) > 0 && publishErr == nil {
+			publishErr = r.publisher.Publish(batch)
+		}
+		batch = batch[:0]
+	}
+
+	for done := false; !done; {
+		select {
+		case record := <-r.dataGetter.Channel():
+			batch = append(batch, record)
+			if len(batch) == r.batchSize {
+				flush()
+			}
+		case <-r.dataGetter.EndChannel():
+			for len(r.dataGetter.Channel()) > 0 {
+				batch = append(batch, <-r.dataGetter.Channel())
+				if len(batch) == r.batchSize {
+					flush()
+				}
+			}
+			flush()
+			done = true
+		}
+	}
+
+	if err := <-readErr; err != nil {
+		slog.Error("failed to read data from files", "err", err)
+		return fmt.Errorf("failed to read data from files: %w", err)
+	}
+	if publishErr != nil {
+		return fmt.Errorf("failed to publish records: %w", publishErr)
+	}
+	if committer, ok := r.dataGetter.(externals.Committer); ok {
+		if err := committer.Commit(); err != nil {
+			return fmt.Errorf("failed to commit read data: %w", err)
+		}
+	}
+	slog.Info("relay ended")
+	return nil
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/test-go/testify/mock"
+)
+
+func TestRelay_Run(t *testing.T) {
+	tests := []struct {
+		name       string
+		readErr    error
+		publishErr error
+		batches    int
+		committed  int
+	}{
+		{name: "published in batches", batches: 3, committed: 1},
+		{name: "not committed when publishing fails", publishErr: fmt.Errorf("broker unavailable"), batches: 1, committed: 0},
+		{name: "not committed when reading fails", readErr: fmt.Errorf("unexpected EOF"), batches: 3, committed: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDG := &committingDataGetter{DataGetterService: new(mocks.DataGetterService)}
+			mockPub := new(mocks.Publisher)
+
+			recordChan := make(chan internal.Record, 5)
+			endChan := make(chan bool, 1)
+			for i := 0; i < 5; i++ {
+				recordChan <- internal.Record{ProjectID: fmt.Sprint(i)}
+			}
+			endChan <- true
+
+			mockDG.On("ReadDataFromFiles", mock.Anything).Return(tc.readErr)
+			mockDG.On("Channel").Return(recordChan)
+			mockDG.On("EndChannel").Return(endChan)
+			var published []string
+			mockPub.On("Publish", mock.Anything).Run(func(args mock.Arguments) {
+				for _, record := range args.Get(0).([]internal.Record) {
+					published = append(published, record.ProjectID)
+				}
+			}).Return(tc.publishErr)
+
+			err := NewRelay(mockDG, mockPub, 2).Run()
+
+			assert.Equal(t, tc.publishErr != nil || tc.readErr != nil, err != nil)
+			mockPub.AssertNumberOfCalls(t, "Publish", tc.batches)
+			assert.Equal(t, tc.committed, mockDG.committed)
+			if tc.readErr != nil {
+				assert.ErrorContains(t, err, "unexpected EOF")
+			}
+			if tc.publishErr == nil {
+				assert.Equal(t, []string{"0", "1", "2", "3", "4"}, published)
+			}
+		})
+	}
+}
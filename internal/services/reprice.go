@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/internal/tracing"
+)
+
+// Repricer recomputes the USD volumes of a range of days from the native
+// amounts stored, without reading the records again, e.g. once CoinGecko
+// corrected a historical price or a token mapping changed.
+type Repricer struct {
+	coingecko externals.CoinGeckoAPI
+	database  externals.RepriceDatabase
+}
+
+func NewRepricer(cg externals.CoinGeckoAPI, db externals.RepriceDatabase) *Repricer {
+	cg.InitTokenIDs()
+	return &Repricer{
+		coingecko: cg,
+		database:  db,
+	}
+}
+
+// Plan prices the currencies traded over the days from to to, and returns
+// their USD volumes at the stored prices and at these, by day and currency,
+// without rewriting them. It fails when a price cannot be fetched. A zero
+// price, as for the tokens unknown to CoinGecko, would wipe the volumes: the
+// currency is skipped, and keeps its volume.
+func (r *Repricer) Plan(ctx context.Context, from, to time.Time) ([]internal.Repricing, error) {
+	volumes, err := r.database.CurrencyVolumes(ctx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get currency volumes: %w", err)
+	}
+	repricings := make([]internal.Repricing, 0, len(volumes))
+	for _, volume := range volumes {
+		y, m, d := volume.Date.Date()
+		price, err := r.coingecko.GetPrice(ctx, volume.CurrencySymbol, fmt.Sprintf("%02d-%02d-%d", d, m, y))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get price of %s on %s: %w", volume.CurrencySymbol, volume.Date.Format(time.DateOnly), err)
+		}
+		if price == 0 {
+			slog.Warn("no price, currency skipped", "currency", volume.CurrencySymbol, "date", volume.Date.Format(time.DateOnly))
+			repricings = append(repricings, internal.Repricing{
+				Date:           volume.Date,
+				CurrencySymbol: volume.CurrencySymbol,
+				Amount:         volume.Amount,
+				Before:         volume.VolumeUSD,
+				After:          volume.VolumeUSD,
+				Skipped:        true,
+			})
+			continue
+		}
+		repricings = append(repricings, internal.Repricing{
+			Date:           volume.Date,
+			CurrencySymbol: volume.CurrencySymbol,
+			Amount:         volume.Amount,
+			PriceUSD:       price,
+			Before:         volume.VolumeUSD,
+			After:          volume.Amount * price,
+		})
+	}
+	return repricings, nil
+}
+
+// Run plans the repricing of the days from to to, then rewrites their USD
+// volumes, but those of the skipped currencies, and returns them before and
+// after. Nothing is rewritten when a price cannot be fetched.
+func (r *Repricer) Run(ctx context.Context, from, to time.Time) (_ []internal.Repricing, err error) {
+	ctx, span := tracer.Start(ctx, "Repricer.Run")
+	defer func() { tracing.End(span, err) }()
+
+	repricings, err := r.Plan(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	prices := make([]internal.Price, 0, len(repricings))
+	for _, repricing := range repricings {
+		if repricing.Skipped {
+			continue
+		}
+		prices = append(prices, internal.Price{Date: repricing.Date, CurrencySymbol: repricing.CurrencySymbol, PriceUSD: repricing.PriceUSD})
+	}
+	if err := r.database.Reprice(ctx, prices); err != nil {
+		return nil, fmt.Errorf("failed to reprice: %w", err)
+	}
+	slog.Info("repriced", "from", from.Format(time.DateOnly), "to", to.Format(time.DateOnly), "currencies", len(prices), "skipped", len(repricings)-len(prices))
+	return repricings, nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/test-go/testify/mock"
+)
+
+func TestRepricer_Run(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	volumes := []internal.CurrencyVolume{
+		{Date: from, CurrencySymbol: "BTC", Amount: 2, VolumeUSD: 80000},
+		{Date: to, CurrencySymbol: "ETH", Amount: 10, VolumeUSD: 20000},
+	}
+	testCases := []struct {
+		name           string
+		priceErr       error
+		noPrice        bool
+		repriceErr     error
+		wantErr        bool
+		wantRepricings []internal.Repricing
+	}{
+		{
+			name: "repriced",
+			wantRepricings: []internal.Repricing{
+				{Date: from, CurrencySymbol: "BTC", Amount: 2, PriceUSD: 50000, Before: 80000, After: 100000},
+				{Date: to, CurrencySymbol: "ETH", Amount: 10, PriceUSD: 2500, Before: 20000, After: 25000},
+			},
+		},
+		{name: "price error", priceErr: fmt.Errorf("token id not found"), wantErr: true},
+		{
+			name:    "no price",
+			noPrice: true,
+			wantRepricings: []internal.Repricing{
+				{Date: from, CurrencySymbol: "BTC", Amount: 2, PriceUSD: 50000, Before: 80000, After: 100000},
+				{Date: to, CurrencySymbol: "ETH", Amount: 10, Before: 20000, After: 20000, Skipped: true},
+			},
+		},
+		{name: "reprice error", repriceErr: fmt.Errorf("connection refused"), wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCG := new(mocks.CoinGeckoAPI)
+			mockDB := new(mocks.RepriceDatabase)
+			mockCG.On("InitTokenIDs").Return(nil)
+			mockCG.On("GetPrice", mock.Anything, "BTC", "01-01-2024").Return(50000.0, nil)
+			ethPrice := 2500.0
+			if tc.noPrice {
+				ethPrice = 0
+			}
+			mockCG.On("GetPrice", mock.Anything, "ETH", "02-01-2024").Return(ethPrice, tc.priceErr)
+			mockDB.On("CurrencyVolumes", mock.Anything, from, to).Return(volumes, nil)
+			mockDB.On("Reprice", mock.Anything, mock.Anything).Return(tc.repriceErr)
+
+			repricings, err := NewRepricer(mockCG, mockDB).Run(context.Background(), from, to)
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantRepricings, repricings)
+			if tc.priceErr != nil {
+				mockDB.AssertNotCalled(t, "Reprice", mock.Anything, mock.Anything)
+				return
+			}
+			prices := []internal.Price{{Date: from, CurrencySymbol: "BTC", PriceUSD: 50000}}
+			if !tc.noPrice {
+				prices = append(prices, internal.Price{Date: to, CurrencySymbol: "ETH", PriceUSD: 2500})
+			}
+			mockDB.AssertCalled(t, "Reprice", mock.Anything, prices)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+// maxRunErrors bounds the errors kept in the record of a run.
+const maxRunErrors = 100
+
+// runLog counts what a run reads and writes, for its audit record.
+type runLog struct {
+	id         string
+	startedAt  time.Time
+	aggregated atomic.Uint64
+	failed     atomic.Uint64
+	duplicated atomic.Uint64
+	written    atomic.Uint64
+	priceCalls atomic.Uint64
+
+	// mutex guards sources and errors.
+	mutex   sync.Mutex
+	sources map[string]uint64
+	errors  []string
+}
+
+func newRunLog() *runLog {
+	return &runLog{
+		id:        uuid.NewString(),
+		startedAt: time.Now().UTC(),
+		sources:   make(map[string]uint64),
+	}
+}
+
+func (l *runLog) read(source string) {
+	l.mutex.Lock()
+	l.sources[source]++
+	l.mutex.Unlock()
+}
+
+func (l *runLog) error(err error) {
+	l.mutex.Lock()
+	if len(l.errors) < maxRunErrors {
+		l.errors = append(l.errors, err.Error())
+	}
+	l.mutex.Unlock()
+}
+
+// record returns the record of the run, ended with err.
+func (l *runLog) record(configHash string, err error) internal.PipelineRun {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	status := internal.RunSucceeded
+	if err != nil {
+		status = internal.RunFailed
+	}
+	return internal.PipelineRun{
+		ID:                l.id,
+		StartedAt:         l.startedAt,
+		EndedAt:           time.Now().UTC(),
+		Status:            status,
+		ConfigHash:        configHash,
+		Sources:           l.sources,
+		RecordsAggregated: l.aggregated.Load(),
+		RecordsFailed:     l.failed.Load(),
+		RecordsDuplicated: l.duplicated.Load(),
+		RowsWritten:       l.written.Load(),
+		PriceCalls:        l.priceCalls.Load(),
+		Errors:            l.errors,
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lat1992/blockchain-data-aggregator/externals"
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+)
+
+// maxInvalidExamples bounds the invalid records reported by a validation.
+const maxInvalidExamples = 10
+
+// Validation is the report of the validation of the records of a source.
+type Validation struct {
+	Records uint64
+	// Invalid is the number of invalid records by reason, e.g. "timestamp".
+	Invalid map[string]uint64
+	// Examples are the errors of the first invalid records.
+	Examples []string
+}
+
+// Validate reads the records of the data getter and checks that they can be
+// aggregated, without pricing nor storing them. The data getter must end its
+// reading with a single end signal, and is not committed.
+func Validate(ctx context.Context, dg externals.DataGetterService) (Validation, error) {
+	v := Validation{Invalid: make(map[string]uint64)}
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- dg.ReadDataFromFiles(ctx)
+	}()
+
+	for done := false; !done; {
+		select {
+		case record := <-dg.Channel():
+			v.check(record)
+		case <-dg.EndChannel():
+			done = true
+		}
+	}
+	// The end is signaled once every record is sent, but the record channel
+	// may still hold some.
+	for drained := false; !drained; {
+		select {
+		case record := <-dg.Channel():
+			v.check(record)
+		default:
+			drained = true
+		}
+	}
+	if err := <-readErr; err != nil {
+		return v, fmt.Errorf("failed to read data from files: %w", err)
+	}
+	return v, nil
+}
+
+func (v *Validation) check(record internal.Record) {
+	v.Records++
+	_, err := internal.ParseRecord(record)
+	if err == nil {
+		return
+	}
+	reason := "unknown"
+	var recordErr *internal.RecordError
+	if errors.As(err, &recordErr) {
+		reason = recordErr.Reason
+	}
+	v.Invalid[reason]++
+	if len(v.Examples) < maxInvalidExamples {
+		v.Examples = append(v.Examples, fmt.Sprintf("%s: %s", record.Source, err))
+	}
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/lat1992/blockchain-data-aggregator/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/test-go/testify/mock"
+)
+
+func TestValidate(t *testing.T) {
+	valid := internal.Record{
+		Timestamp: "2024-01-01 12:00:00.000",
+		ProjectID: "1234",
+		Props:     `{"currencySymbol":"BTC"}`,
+		Nums:      `{"currencyValueDecimal":"1.5"}`,
+		Source:    "data/2024-01-01.csv",
+	}
+	invalidTimestamp, invalidProject, invalidAmount := valid, valid, valid
+	invalidTimestamp.Timestamp = "2024-01-01"
+	invalidProject.ProjectID = "project"
+	invalidAmount.Nums = `{"currencyValueDecimal":"one"}`
+
+	testCases := []struct {
+		name        string
+		records     []internal.Record
+		readErr     error
+		wantErr     bool
+		wantRecords uint64
+		wantInvalid map[string]uint64
+	}{
+		{name: "valid", records: []internal.Record{valid, valid}, wantRecords: 2, wantInvalid: map[string]uint64{}},
+		{
+			name:        "invalid",
+			records:     []internal.Record{valid, invalidTimestamp, invalidProject, invalidAmount, invalidAmount},
+			wantRecords: 5,
+			wantInvalid: map[string]uint64{"timestamp": 1, "project_id": 1, "amount": 2},
+		},
+		{name: "read error", readErr: fmt.Errorf("no such directory"), wantErr: true, wantInvalid: map[string]uint64{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDG := new(mocks.DataGetterService)
+			recordChan := make(chan internal.Record, len(tc.records))
+			endChan := make(chan bool, 1)
+			for _, record := range tc.records {
+				recordChan <- record
+			}
+			endChan <- true
+			mockDG.On("ReadDataFromFiles", mock.Anything).Return(tc.readErr)
+			mockDG.On("Channel").Return(recordChan)
+			mockDG.On("EndChannel").Return(endChan)
+
+			v, err := Validate(context.Background(), mockDG)
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantRecords, v.Records)
+			assert.Equal(t, tc.wantInvalid, v.Invalid)
+			var invalid uint64
+			for _, count := range tc.wantInvalid {
+				invalid += count
+			}
+			assert.Len(t, v.Examples, int(invalid))
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package internal
 
-import "time"
+import (
+	"net/url"
+	"time"
+)
 
 type Record struct {
 	Timestamp string
@@ -8,6 +11,14 @@ type Record struct {
 	ProjectID string
 	Props     string
 	Nums      string
+	// Columns holds every column of the source row keyed by its header name.
+	Columns map[string]string
+	// Source identifies where the record was read from, e.g. its file.
+	Source string
+	// Origin is set by the data getters told of the records the pipeline
+	// fails to aggregate, to tell them apart, e.g. the request they were
+	// received in. It is not stored.
+	Origin any
 }
 
 type MarketStat struct {
@@ -15,4 +26,178 @@ type MarketStat struct {
 	ProjectID   uint64
 	NumTx       uint64
 	TotalVolume float64
+	Dimensions  map[string]string
+	// RunID is the id of the pipeline run that aggregated the stat.
+	RunID string
+}
+
+// Transaction is a priced record, the detail of the market stats.
+type Transaction struct {
+	Timestamp         time.Time
+	Event             string
+	ProjectID         uint64
+	ChainID           string
+	CurrencyAddress   string
+	CurrencySymbol    string
+	Amount            float64
+	PriceUSD          float64
+	ValueUSD          float64
+	TxnHash           string
+	CollectionAddress string
+	TokenID           string
+	SourceFile        string
+	Dimensions        map[string]string
+	RunID             string
+}
+
+// Statuses of a pipeline run.
+const (
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// PipelineRun is the audit record of a pipeline run: what it read, and what
+// it wrote.
+type PipelineRun struct {
+	ID        string
+	StartedAt time.Time
+	EndedAt   time.Time
+	Status    string
+	// ConfigHash identifies the configuration of the run.
+	ConfigHash string
+	// Sources is the number of records read by source, e.g. by file.
+	Sources           map[string]uint64
+	RecordsAggregated uint64
+	RecordsFailed     uint64
+	RecordsDuplicated uint64
+	// RowsWritten is the number of stats, or transactions, inserted into the
+	// database.
+	RowsWritten uint64
+	PriceCalls  uint64
+	Errors      []string
+}
+
+// BackfillRange is the days From to To, both included, whose stats are
+// recomputed, of a project, or of every project when ProjectID is 0. From and
+// To are UTC dates.
+type BackfillRange struct {
+	From      time.Time
+	To        time.Time
+	ProjectID uint64
+}
+
+// Contains reports whether the stats of a project at date are in the range.
+func (r BackfillRange) Contains(date time.Time, projectID uint64) bool {
+	day := date.UTC().Truncate(24 * time.Hour)
+	return !day.Before(r.From) && !day.After(r.To) && (r.ProjectID == 0 || r.ProjectID == projectID)
+}
+
+// Days returns the days of the range.
+func (r BackfillRange) Days() []time.Time {
+	var days []time.Time
+	for day := r.From; !day.After(r.To); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return days
+}
+
+// CurrencyVolume is the native amount of a currency traded over a day, and
+// its USD volume.
+type CurrencyVolume struct {
+	Date           time.Time
+	CurrencySymbol string
+	Amount         float64
+	VolumeUSD      float64
+}
+
+// Price is the USD price of a currency over a day.
+type Price struct {
+	Date           time.Time
+	CurrencySymbol string
+	PriceUSD       float64
+}
+
+// Repricing is the USD volume of a currency over a day, before and after it
+// was repriced. A skipped currency, without a price, keeps its volume.
+type Repricing struct {
+	Date           time.Time
+	CurrencySymbol string
+	Amount         float64
+	PriceUSD       float64
+	Before         float64
+	After          float64
+	Skipped        bool
+}
+
+// Verification statuses of a record checked against its chain.
+const (
+	// VerificationVerified is a transaction found on chain with a transfer of
+	// the reported currency and amount.
+	VerificationVerified = "verified"
+	// VerificationUnverified is a transaction that could not be checked.
+	VerificationUnverified = "unverified"
+	// VerificationMismatched is a transaction found on chain that failed or
+	// does not transfer the reported currency and amount.
+	VerificationMismatched = "mismatched"
+)
+
+type Verification struct {
+	Date             time.Time
+	ProjectID        string
+	ChainID          string
+	TxnHash          string
+	CurrencyAddress  string
+	CurrencyValueRaw string
+	Status           string
+	Reason           string
+}
+
+// DimensionsKey returns a canonical form of dimensions, sorted by name, that
+// identifies them in the sorting keys of the tables.
+func DimensionsKey(dimensions map[string]string) string {
+	values := make(url.Values, len(dimensions))
+	for name, value := range dimensions {
+		values.Set(name, value)
+	}
+	return values.Encode()
+}
+
+// Granularities of the stats of a project.
+const (
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+)
+
+// StatsQuery selects stored stats. From and To are inclusive dates.
+type StatsQuery struct {
+	ProjectID   uint64
+	From        time.Time
+	To          time.Time
+	Granularity string
+	Limit       int
+	Offset      int
+}
+
+// PeriodStat is the stats of a project over a day, week or month, starting at
+// Period.
+type PeriodStat struct {
+	Period      time.Time `json:"period"`
+	NumTx       uint64    `json:"num_transactions"`
+	TotalVolume float64   `json:"total_volume_usd"`
+}
+
+// ProjectStat is the stats of a project over the dates of a query.
+type ProjectStat struct {
+	ProjectID   uint64  `json:"project_id"`
+	NumTx       uint64  `json:"num_transactions"`
+	TotalVolume float64 `json:"total_volume_usd"`
+}
+
+// CurrencyStat is the stats of the trades of a project in a currency.
+type CurrencyStat struct {
+	CurrencySymbol string  `json:"currency_symbol"`
+	NumTx          uint64  `json:"num_transactions"`
+	Amount         float64 `json:"amount"`
+	TotalVolume    float64 `json:"total_volume_usd"`
 }
@@ -0,0 +1,95 @@
+// Package tracing exports the OpenTelemetry spans of the services over OTLP.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type config struct {
+	insecure    bool
+	sampleRatio float64
+	exporter    sdktrace.SpanExporter
+}
+
+type Option func(*config)
+
+// WithInsecure exports the spans over plain HTTP.
+func WithInsecure() Option {
+	return func(c *config) {
+		c.insecure = true
+	}
+}
+
+// WithSampleRatio samples the given ratio of the traces, 1 sampling them all.
+func WithSampleRatio(ratio float64) Option {
+	return func(c *config) {
+		c.sampleRatio = ratio
+	}
+}
+
+// WithExporter exports the spans to exporter rather than over OTLP, as soon
+// as they end, e.g. to an in-memory exporter in tests.
+func WithExporter(exporter sdktrace.SpanExporter) Option {
+	return func(c *config) {
+		c.exporter = exporter
+	}
+}
+
+// Setup installs the global tracer provider of the service, exporting its
+// spans in batches to the OTLP/HTTP collector at endpoint, host:port. The
+// returned function exports the pending spans and stops the provider.
+func Setup(ctx context.Context, service, endpoint string, opts ...Option) (func(context.Context) error, error) {
+	c := &config{sampleRatio: 1}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	var processor sdktrace.SpanProcessor
+	if c.exporter != nil {
+		processor = sdktrace.NewSimpleSpanProcessor(c.exporter)
+	} else {
+		exporterOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+		if c.insecure {
+			exporterOpts = append(exporterOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(ctx, exporterOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create otlp exporter: %w", err)
+		}
+		processor = sdktrace.NewBatchSpanProcessor(exporter)
+	}
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(service)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.sampleRatio))))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// End ends a span, recording err as its error when not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
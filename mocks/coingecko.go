@@ -1,26 +1,30 @@
 package mocks
 
-import "github.com/test-go/testify/mock"
+import (
+	context "context"
+
+	"github.com/test-go/testify/mock"
+)
 
 // CoinGeckoAPI is an autogenerated mock type for the CoinGeckoAPI type
 type CoinGeckoAPI struct {
 	mock.Mock
 }
 
-// GetPrice provides a mock function with given fields: symbol, date
-func (_m *CoinGeckoAPI) GetPrice(symbol string, date string) (float64, error) {
-	ret := _m.Called(symbol, date)
+// GetPrice provides a mock function with given fields: ctx, symbol, date
+func (_m *CoinGeckoAPI) GetPrice(ctx context.Context, symbol string, date string) (float64, error) {
+	ret := _m.Called(ctx, symbol, date)
 
 	var r0 float64
-	if rf, ok := ret.Get(0).(func(string, string) float64); ok {
-		r0 = rf(symbol, date)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) float64); ok {
+		r0 = rf(ctx, symbol, date)
 	} else {
 		r0 = ret.Get(0).(float64)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(symbol, date)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, symbol, date)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	context "context"
+
 	internal "github.com/lat1992/blockchain-data-aggregator/internal"
 	"github.com/test-go/testify/mock"
 )
@@ -42,13 +44,13 @@ func (_m *DataGetterService) EndChannel() chan bool {
 	return r0
 }
 
-// ReadDataFromFiles provides a mock function with given fields:
-func (_m *DataGetterService) ReadDataFromFiles() error {
-	ret := _m.Called()
+// ReadDataFromFiles provides a mock function with given fields: ctx
+func (_m *DataGetterService) ReadDataFromFiles(ctx context.Context) error {
+	ret := _m.Called(ctx)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func() error); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -1,6 +1,10 @@
 package mocks
 
 import (
+	"context"
+	"time"
+
+	externals "github.com/lat1992/blockchain-data-aggregator/externals"
 	internal "github.com/lat1992/blockchain-data-aggregator/internal"
 	"github.com/test-go/testify/mock"
 )
@@ -23,3 +27,159 @@ func (_m *Database) InsertMarket(stats map[string]internal.MarketStat) error {
 
 	return r0
 }
+
+// VerificationDatabase is an autogenerated mock type for the Database and VerificationDatabase types
+type VerificationDatabase struct {
+	Database
+}
+
+// InsertVerifications provides a mock function with given fields: verifications
+func (_m *VerificationDatabase) InsertVerifications(verifications []internal.Verification) error {
+	ret := _m.Called(verifications)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func([]internal.Verification) error); ok {
+		r0 = rf(verifications)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// TransactionDatabase is an autogenerated mock type for the Database and TransactionDatabase types
+type TransactionDatabase struct {
+	Database
+}
+
+// InsertTransactions provides a mock function with given fields: transactions
+func (_m *TransactionDatabase) InsertTransactions(transactions []internal.Transaction) error {
+	ret := _m.Called(transactions)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func([]internal.Transaction) error); ok {
+		r0 = rf(transactions)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RunDatabase is an autogenerated mock type for the Database and RunDatabase types
+type RunDatabase struct {
+	Database
+}
+
+// InsertRun provides a mock function with given fields: run
+func (_m *RunDatabase) InsertRun(run internal.PipelineRun) error {
+	ret := _m.Called(run)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(internal.PipelineRun) error); ok {
+		r0 = rf(run)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Backfill is an autogenerated mock type for the Backfill type
+type Backfill struct {
+	RunDatabase
+}
+
+// Commit provides a mock function with given fields:
+func (_m *Backfill) Commit() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Rollback provides a mock function with given fields:
+func (_m *Backfill) Rollback() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// BackfillDatabase is an autogenerated mock type for the BackfillDatabase type
+type BackfillDatabase struct {
+	mock.Mock
+}
+
+// Backfill provides a mock function with given fields: r
+func (_m *BackfillDatabase) Backfill(r internal.BackfillRange) (externals.Backfill, error) {
+	ret := _m.Called(r)
+
+	var r0 externals.Backfill
+	if rf, ok := ret.Get(0).(func(internal.BackfillRange) externals.Backfill); ok {
+		r0 = rf(r)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).(externals.Backfill)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(internal.BackfillRange) error); ok {
+		r1 = rf(r)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RepriceDatabase is an autogenerated mock type for the RepriceDatabase type
+type RepriceDatabase struct {
+	mock.Mock
+}
+
+// CurrencyVolumes provides a mock function with given fields: ctx, from, to
+func (_m *RepriceDatabase) CurrencyVolumes(ctx context.Context, from time.Time, to time.Time) ([]internal.CurrencyVolume, error) {
+	ret := _m.Called(ctx, from, to)
+
+	var r0 []internal.CurrencyVolume
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, time.Time) []internal.CurrencyVolume); ok {
+		r0 = rf(ctx, from, to)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]internal.CurrencyVolume)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, time.Time) error); ok {
+		r1 = rf(ctx, from, to)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Reprice provides a mock function with given fields: ctx, prices
+func (_m *RepriceDatabase) Reprice(ctx context.Context, prices []internal.Price) error {
+	ret := _m.Called(ctx, prices)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []internal.Price) error); ok {
+		r0 = rf(ctx, prices)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -0,0 +1,25 @@
+package mocks
+
+import (
+	internal "github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/test-go/testify/mock"
+)
+
+// Publisher is an autogenerated mock type for the Publisher type
+type Publisher struct {
+	mock.Mock
+}
+
+// Publish provides a mock function with given fields: records
+func (_m *Publisher) Publish(records []internal.Record) error {
+	ret := _m.Called(records)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func([]internal.Record) error); ok {
+		r0 = rf(records)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
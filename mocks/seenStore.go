@@ -0,0 +1,43 @@
+package mocks
+
+import "github.com/test-go/testify/mock"
+
+// SeenStore is an autogenerated mock type for the SeenStore type
+type SeenStore struct {
+	mock.Mock
+}
+
+// Seen provides a mock function with given fields: keys
+func (_m *SeenStore) Seen(keys []string) (map[string]bool, error) {
+	ret := _m.Called(keys)
+
+	var r0 map[string]bool
+	if rf, ok := ret.Get(0).(func([]string) map[string]bool); ok {
+		r0 = rf(keys)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).(map[string]bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]string) error); ok {
+		r1 = rf(keys)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MarkSeen provides a mock function with given fields: keys
+func (_m *SeenStore) MarkSeen(keys []string) error {
+	ret := _m.Called(keys)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func([]string) error); ok {
+		r0 = rf(keys)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -0,0 +1,69 @@
+package mocks
+
+import (
+	context "context"
+
+	internal "github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/test-go/testify/mock"
+)
+
+// StatsReader is an autogenerated mock type for the StatsReader type
+type StatsReader struct {
+	mock.Mock
+}
+
+// CurrencyStats provides a mock function with given fields: ctx, query
+func (_m *StatsReader) CurrencyStats(ctx context.Context, query internal.StatsQuery) ([]internal.CurrencyStat, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []internal.CurrencyStat
+	if rf, ok := ret.Get(0).(func(context.Context, internal.StatsQuery) []internal.CurrencyStat); ok {
+		r0 = rf(ctx, query)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]internal.CurrencyStat)
+	}
+
+	return r0, ret.Error(1)
+}
+
+// MarketStats provides a mock function with given fields: ctx, query
+func (_m *StatsReader) MarketStats(ctx context.Context, query internal.StatsQuery) ([]internal.MarketStat, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []internal.MarketStat
+	if rf, ok := ret.Get(0).(func(context.Context, internal.StatsQuery) []internal.MarketStat); ok {
+		r0 = rf(ctx, query)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]internal.MarketStat)
+	}
+
+	return r0, ret.Error(1)
+}
+
+// ProjectStats provides a mock function with given fields: ctx, query
+func (_m *StatsReader) ProjectStats(ctx context.Context, query internal.StatsQuery) ([]internal.PeriodStat, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []internal.PeriodStat
+	if rf, ok := ret.Get(0).(func(context.Context, internal.StatsQuery) []internal.PeriodStat); ok {
+		r0 = rf(ctx, query)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]internal.PeriodStat)
+	}
+
+	return r0, ret.Error(1)
+}
+
+// TopProjects provides a mock function with given fields: ctx, query
+func (_m *StatsReader) TopProjects(ctx context.Context, query internal.StatsQuery) ([]internal.ProjectStat, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []internal.ProjectStat
+	if rf, ok := ret.Get(0).(func(context.Context, internal.StatsQuery) []internal.ProjectStat); ok {
+		r0 = rf(ctx, query)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]internal.ProjectStat)
+	}
+
+	return r0, ret.Error(1)
+}
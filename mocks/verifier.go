@@ -0,0 +1,25 @@
+package mocks
+
+import (
+	internal "github.com/lat1992/blockchain-data-aggregator/internal"
+	"github.com/test-go/testify/mock"
+)
+
+// Verifier is an autogenerated mock type for the Verifier type
+type Verifier struct {
+	mock.Mock
+}
+
+// Verify provides a mock function with given fields: record
+func (_m *Verifier) Verify(record internal.Record) internal.Verification {
+	ret := _m.Called(record)
+
+	var r0 internal.Verification
+	if rf, ok := ret.Get(0).(func(internal.Record) internal.Verification); ok {
+		r0 = rf(record)
+	} else {
+		r0 = ret.Get(0).(internal.Verification)
+	}
+
+	return r0
+}